@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/bulk"
+	"github.com/jdharms/threat-detect/internal/config"
+	"github.com/jdharms/threat-detect/internal/db"
+)
+
+const exportUsage = `usage: detect export [-format csv|jsonl] [-tenant name] [filters] [-o file]
+
+Write a tenant's stored results in address order, as a CSV document or JSON lines, to
+standard output or a file.  The output can be read back with "detect import".
+
+`
+
+// runExport implements the "export" subcommand.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), exportUsage)
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "csv", "\"csv\" or \"jsonl\" (JSON lines)")
+	tenant := fs.String("tenant", auth.DefaultTenant, "tenant whose results to export")
+	cidr := fs.String("cidr", "", "only addresses within this CIDR network")
+	listed := fs.String("listed", "", "only listed addresses if true, or only clean ones if false")
+	tag := fs.String("tag", "", "only addresses with this tag")
+	note := fs.String("note", "", "only addresses with a note containing this text")
+	since := fs.String("since", "", "only results updated at or after this RFC 3339 time")
+	until := fs.String("until", "", "only results updated before this RFC 3339 time")
+	limit := fs.Int("limit", 0, "export at most this many results, or 0 for all of them")
+	out := fs.String("o", "", "file to write instead of standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments")
+	}
+
+	f, err := parseFormat(*format)
+	if err != nil {
+		return err
+	}
+	filter, err := exportFilter(*cidr, *listed, *since, *until, *limit)
+	if err != nil {
+		return err
+	}
+	if *tag != "" {
+		filter.Tag = tag
+	}
+	if *note != "" {
+		filter.Note = note
+	}
+
+	cfg := envConfig()
+	if err := requireStoredDatabase(cfg.Database); err != nil {
+		return err
+	}
+	client, err := openDatabase(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer client.Close()
+
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+
+	n, err := bulk.Export(context.Background(), w, client, *tenant, f, filter)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "exported %d results\n", n)
+	return nil
+}
+
+// exportFilter builds the filter given by the export flags, which are empty or zero when
+// not set.
+func exportFilter(cidr, listed, since, until string, limit int) (model.ExportFilter, error) {
+	var f model.ExportFilter
+	if cidr != "" {
+		f.Cidr = &cidr
+	}
+	if listed != "" {
+		b, err := strconv.ParseBool(listed)
+		if err != nil {
+			return f, fmt.Errorf("-listed: expected true or false, got %q", listed)
+		}
+		f.Listed = &b
+	}
+	for _, t := range []struct {
+		flag  string
+		value string
+		field **time.Time
+	}{
+		{"since", since, &f.Since},
+		{"until", until, &f.Until},
+	} {
+		if t.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			return f, fmt.Errorf("-%s: expected an RFC 3339 time such as \"2021-01-02T15:04:05Z\", got %q", t.flag, t.value)
+		}
+		*t.field = &parsed
+	}
+	if limit < 0 {
+		return f, fmt.Errorf("-limit mustn't be negative")
+	}
+	if limit > 0 {
+		f.Limit = &limit
+	}
+	return f, nil
+}
+
+// parseFormat reads the -format flag of the export and import subcommands.
+func parseFormat(s string) (model.DataFormat, error) {
+	switch s {
+	case "csv":
+		return model.DataFormatCsv, nil
+	case "jsonl":
+		return model.DataFormatJSONLines, nil
+	default:
+		return "", fmt.Errorf("-format: expected csv or jsonl, got %q", s)
+	}
+}
+
+// requireStoredDatabase rejects the in-memory store, which the export and import
+// subcommands would only see empty.
+func requireStoredDatabase(c config.DatabaseConfig) error {
+	if c.Driver != "postgres" && c.Path == db.MemoryPath {
+		return fmt.Errorf("the in-memory store can't be exported or imported from the command line")
+	}
+	return nil
+}
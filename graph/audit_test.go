@@ -0,0 +1,147 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/db"
+)
+
+type mockAudit struct {
+	entries []model.AuditEntry
+	filter  model.AuditLogFilter
+	addErr  error
+}
+
+func (m *mockAudit) AddAuditEntry(ctx context.Context, tenant string, entry model.AuditEntry) error {
+	if m.addErr != nil {
+		return m.addErr
+	}
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *mockAudit) EachAuditEntry(ctx context.Context, tenant string, filter model.AuditLogFilter, fn func(*model.AuditEntry) error) error {
+	m.filter = filter
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		if err := fn(&m.entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestAuditRecordsOperations(t *testing.T) {
+	audit := &mockAudit{}
+	resolver := limitsResolver()
+	resolver.Audit = audit
+	h := NewHandler(resolver, HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10})
+
+	alice := auth.Principal{Name: "alice", Roles: auth.AllRoles}
+	res := postQueryAs(t, h, alice, map[string]interface{}{"query": detailsQuery})
+	if len(res.Errors) != 0 {
+		t.Fatalf("unexpected error: %s", res.Errors[0].Message)
+	}
+
+	if len(audit.entries) != 1 {
+		t.Fatalf("expected 1 audit entry, got %d", len(audit.entries))
+	}
+	entry := audit.entries[0]
+	if entry.Principal != "alice" || entry.APIKeyID != nil || entry.Operation != model.AuditOperationGetIPDetails || entry.Arguments != `{"ip":["1.2.3.4"]}` {
+		t.Errorf("unexpected audit entry: %+v", entry)
+	}
+
+	withKey := auth.Principal{Name: "alice", Roles: auth.AllRoles, KeyID: "key-1"}
+	if res := postQueryAs(t, h, withKey, map[string]interface{}{"query": detailsQuery}); len(res.Errors) != 0 {
+		t.Fatalf("unexpected error: %s", res.Errors[0].Message)
+	}
+	if entry := audit.entries[1]; entry.Principal != "alice" || entry.APIKeyID == nil || *entry.APIKeyID != "key-1" {
+		t.Errorf("expected the api key to be recorded, got %+v", entry)
+	}
+	audit.entries = audit.entries[:1]
+
+	res = postQuery(t, h, map[string]interface{}{"query": `{ auditLog(filter: {principal: "alice"}) { principal operation } }`})
+	if len(res.Errors) != 0 {
+		t.Fatalf("unexpected error: %s", res.Errors[0].Message)
+	}
+	if entries := res.Data["auditLog"].([]interface{}); len(entries) != 1 {
+		t.Errorf("expected 1 entry, got %v", entries)
+	}
+	if *audit.filter.Principal != "alice" || *audit.filter.Limit != defaultAuditLogLimit {
+		t.Errorf("unexpected filter: %+v", audit.filter)
+	}
+
+	res = postQuery(t, h, map[string]interface{}{"query": `{ auditLog(filter: {limit: 5000}) { id } }`})
+	if errorCode(res) != CodeInvalidInput {
+		t.Errorf("expected %s for too large a limit, got %+v", CodeInvalidInput, res.Errors)
+	}
+}
+
+func TestAuditRecordsMutations(t *testing.T) {
+	store := db.NewMemoryStore()
+	audit := &mockAudit{}
+	resolver := limitsResolver()
+	resolver.Deleter = store
+	resolver.APIKeys = store
+	resolver.Bulk = store
+	resolver.Annotations = store
+	resolver.Backups = mockBackups{}
+	resolver.Audit = audit
+	h := NewHandler(resolver, HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10})
+
+	testCases := []struct {
+		query     string
+		operation model.AuditOperation
+		arguments string
+	}{
+		{`mutation { deleteIPDetails(ip: ["1.2.3.4"]) }`, model.AuditOperationDeleteIPDetails, `{"ip":["1.2.3.4"]}`},
+		{`mutation { createAPIKey(input: {name: "ci", scopes: [READ]}) { key } }`, model.AuditOperationCreateAPIKey, `{"expires_at":null,"name":"ci","scopes":["READ"]}`},
+		{`mutation { revokeAPIKey(id: "key-1") { id } }`, model.AuditOperationRevokeAPIKey, `{"id":"key-1"}`},
+		{`mutation { backupDatabase { path } }`, model.AuditOperationBackupDatabase, `{}`},
+		{`mutation { importIPDetails(format: CSV, data: "ip_address\n1.2.3.4\n") { imported } }`, model.AuditOperationImportIPDetails, `{"bytes":19,"conflict":"SKIP","format":"CSV"}`},
+		{`mutation { tagIP(ip: "1.2.3.4", tags: ["c2"]) { name } }`, model.AuditOperationTagIP, `{"ip":["1.2.3.4"],"tags":["c2"]}`},
+		{`mutation { untagIP(ip: "1.2.3.4", tags: ["c2"]) { name } }`, model.AuditOperationUntagIP, `{"ip":["1.2.3.4"],"tags":["c2"]}`},
+		{`mutation { addNote(ip: "1.2.3.4", body: "seen in phishing") { id } }`, model.AuditOperationAddNote, `{"body":"seen in phishing","ip":["1.2.3.4"]}`},
+	}
+
+	for _, test := range testCases {
+		t.Run(string(test.operation), func(t *testing.T) {
+			audit.entries = nil
+			// revoking an unknown key fails, but the attempt is still recorded
+			postQuery(t, h, map[string]interface{}{"query": test.query})
+
+			if len(audit.entries) != 1 {
+				t.Fatalf("expected 1 audit entry, got %+v", audit.entries)
+			}
+			if entry := audit.entries[0]; entry.Operation != test.operation || entry.Arguments != test.arguments {
+				t.Errorf("expected %s with %s, got %+v", test.operation, test.arguments, entry)
+			}
+		})
+	}
+
+	audit.addErr = errors.New("disk full")
+	res := postQuery(t, h, map[string]interface{}{"query": `mutation { tagIP(ip: "1.2.3.4", tags: ["c2"]) { name } }`})
+	if errorCode(res) != CodeInternal {
+		t.Errorf("expected %s when the audit log can't be written, got %+v", CodeInternal, res.Errors)
+	}
+	if tags, _ := store.Tags(context.Background(), auth.DefaultTenant, []string{"1.2.3.4"}); len(tags) != 0 {
+		t.Errorf("expected nothing to be tagged, got %+v", tags)
+	}
+}
+
+func TestAuditFailureBlocksOperation(t *testing.T) {
+	resolver := limitsResolver()
+	resolver.Audit = &mockAudit{addErr: errors.New("disk full")}
+	h := NewHandler(resolver, HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10})
+
+	res := postQuery(t, h, map[string]interface{}{"query": detailsQuery})
+	if errorCode(res) != CodeInternal {
+		t.Errorf("expected %s when the audit log can't be written, got %+v", CodeInternal, res.Errors)
+	}
+	if res.Data["getIPDetails"] != nil {
+		t.Error("expected no details to be returned")
+	}
+}
@@ -0,0 +1,103 @@
+package graph
+
+import (
+	"time"
+
+	"github.com/jdharms/threat-detect/graph/generated"
+	"github.com/jdharms/threat-detect/graph/model"
+)
+
+// Complexity costs for fields that do real work.  Fields not configured here cost 1
+// plus the cost of their children.
+const (
+	getIPDetailsCost = 2
+	statsCost        = 50
+	enqueuePerIPCost = 2
+
+	// auditLog is a single indexed query and is only available to admins, so it isn't
+	// charged per entry.
+	auditLogCost = 20
+
+	// ipDetailsInNetwork is an index range scan, charged for each record it may return.
+	networkSearchCost = 5
+
+	// exportIPDetails builds its whole document in memory, so it's charged for every
+	// exportRecordsPerCost records it may return on top of a base cost.  At the maximum
+	// limit it still fits the default complexity limit.
+	exportCost           = 50
+	exportRecordsPerCost = 20
+
+	// The tags and notes on each record are batched into one query per operation, but
+	// are still charged per record, as they're sent for each of them.
+	annotationsCost = 2
+
+	// List fields multiply the cost of their children by the number of items we
+	// expect them to return.
+	listingsEstimate = 10
+	daysEstimate     = 30
+	networksEstimate = 10 // matches the limit applied by the database
+	tagsEstimate     = 3
+	notesEstimate    = 3
+)
+
+// Complexity returns the per-field complexity budget used with a complexity limit.
+func Complexity() generated.ComplexityRoot {
+	var c generated.ComplexityRoot
+
+	c.Query.GetIPDetails = func(childComplexity int, ip string) int {
+		return getIPDetailsCost + childComplexity
+	}
+	c.Query.Stats = func(childComplexity int, since *time.Time) int {
+		return statsCost + childComplexity
+	}
+	c.Query.AuditLog = func(childComplexity int, filter *model.AuditLogFilter) int {
+		return auditLogCost + childComplexity
+	}
+	c.Query.IPDetailsInNetwork = func(childComplexity int, cidr string, filter *model.NetworkFilter) int {
+		var limit *int
+		if filter != nil {
+			limit = filter.Limit
+		}
+		return networkSearchCost + listLimit(limit, defaultNetworkLimit, maxNetworkLimit)*childComplexity
+	}
+	c.Query.ExportIPDetails = func(childComplexity int, format model.DataFormat, filter *model.ExportFilter) int {
+		var limit *int
+		if filter != nil {
+			limit = filter.Limit
+		}
+		return exportCost + listLimit(limit, defaultExportLimit, maxExportLimit)/exportRecordsPerCost
+	}
+	c.IPDetails.Tags = func(childComplexity int) int {
+		return annotationsCost + tagsEstimate*childComplexity
+	}
+	c.IPDetails.Notes = func(childComplexity int) int {
+		return annotationsCost + notesEstimate*childComplexity
+	}
+	c.Stats.ByListing = func(childComplexity int) int {
+		return listingsEstimate * childComplexity
+	}
+	c.Stats.LookupsPerDay = func(childComplexity int) int {
+		return daysEstimate * childComplexity
+	}
+	c.Stats.TopNetworks = func(childComplexity int) int {
+		return networksEstimate * childComplexity
+	}
+	c.Mutation.Enqueue = func(childComplexity int, ip []string) int {
+		return enqueuePerIPCost*len(ip) + childComplexity
+	}
+
+	return c
+}
+
+// listLimit returns the number of items a list field given limit may return.  Limits
+// outside 1 to max are rejected by the resolvers, so they're charged as max.
+func listLimit(limit *int, def, max int) int {
+	switch {
+	case limit == nil:
+		return def
+	case *limit < 1 || *limit > max:
+		return max
+	default:
+		return *limit
+	}
+}
@@ -0,0 +1,20 @@
+package graph
+
+import (
+	"context"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+)
+
+// HasRole implements the @hasRole directive, refusing to resolve the field unless the
+// principal in the request context holds role.
+func HasRole(ctx context.Context, obj interface{}, next graphql.Resolver, role model.Role) (interface{}, error) {
+	if err := auth.RequireRole(ctx, strings.ToLower(string(role))); err != nil {
+		return nil, err
+	}
+	return next(ctx)
+}
@@ -0,0 +1,140 @@
+package graph
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jdharms/threat-detect/graph/generated"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+)
+
+type mockAPIKeys struct {
+	hash  string
+	owner string
+}
+
+func (m *mockAPIKeys) CreateAPIKey(ctx context.Context, tenant string, key model.APIKey, keyHash string) (model.APIKey, error) {
+	m.hash = keyHash
+	m.owner = key.Owner
+	key.ID = "key-id"
+	return key, nil
+}
+
+func (m *mockAPIKeys) ListAPIKeys(ctx context.Context, tenant string) ([]*model.APIKey, error) {
+	return []*model.APIKey{}, nil
+}
+
+func (m *mockAPIKeys) RevokeAPIKey(ctx context.Context, tenant string, id string) (model.APIKey, error) {
+	return model.APIKey{ID: id}, nil
+}
+
+func TestHasRole(t *testing.T) {
+	resolver := limitsResolver()
+	resolver.APIKeys = &mockAPIKeys{}
+	h := NewHandler(resolver, HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10})
+
+	reader := auth.Principal{Name: "reader", Roles: []string{auth.RoleReader}}
+
+	testCases := []struct {
+		name         string
+		principal    auth.Principal
+		query        string
+		expectedCode string
+	}{
+		{"reader can read", reader, detailsQuery, ""},
+		{"reader cannot enqueue", reader, `mutation { enqueue(ip: ["1.2.3.4"]) { job_id } }`, CodeForbidden},
+		{"reader cannot list keys", reader, `{ apiKeys { id } }`, CodeForbidden},
+		{"reader cannot delete", reader, `mutation { deleteIPDetails(ip: ["1.2.3.4"]) }`, CodeForbidden},
+		{"admin can list keys", auth.Principal{Name: "admin", Roles: []string{auth.RoleAdmin}}, `{ apiKeys { id } }`, ""},
+		{"introspection needs no scope", auth.Principal{Name: "none"}, `{ __typename }`, ""},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			res := postQueryAs(t, h, test.principal, map[string]interface{}{"query": test.query})
+			if errorCode(res) != test.expectedCode {
+				t.Errorf("expected code %q, got %+v", test.expectedCode, res.Errors)
+			}
+		})
+	}
+}
+
+// Fields without @hasRole are open to every authenticated principal, so every operation
+// must declare the role it needs.
+func TestOperationsDeclareRoles(t *testing.T) {
+	schema := generated.NewExecutableSchema(generated.Config{Resolvers: &Resolver{}}).Schema()
+
+	for _, object := range []string{"Query", "Mutation"} {
+		for _, field := range schema.Types[object].Fields {
+			if strings.HasPrefix(field.Name, "__") {
+				continue
+			}
+			if field.Directives.ForName("hasRole") == nil {
+				t.Errorf("%s.%s has no @hasRole directive", object, field.Name)
+			}
+		}
+	}
+}
+
+type mockDeleter struct {
+	deleted []string
+}
+
+func (m *mockDeleter) DeleteIPDetails(ctx context.Context, tenant string, addrs []string) (int, error) {
+	m.deleted = append(m.deleted, addrs...)
+	return len(addrs), nil
+}
+
+func TestDeleteIPDetails(t *testing.T) {
+	deleter := &mockDeleter{}
+	resolver := limitsResolver()
+	resolver.Deleter = deleter
+	h := NewHandler(resolver, HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10})
+
+	res := postQuery(t, h, map[string]interface{}{"query": `mutation { deleteIPDetails(ip: ["1.2.3.4", "foo"]) }`})
+	if errorCode(res) != CodeInvalidInput || len(deleter.deleted) != 0 {
+		t.Errorf("expected invalid input and nothing deleted, got %+v", res.Errors)
+	}
+
+	res = postQuery(t, h, map[string]interface{}{"query": `mutation { deleteIPDetails(ip: ["1.2.3.4", "5.6.7.8"]) }`})
+	if len(res.Errors) != 0 {
+		t.Fatalf("unexpected error: %s", res.Errors[0].Message)
+	}
+	if res.Data["deleteIPDetails"] != float64(2) {
+		t.Errorf("expected 2 deleted, got %v", res.Data["deleteIPDetails"])
+	}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	store := &mockAPIKeys{}
+	resolver := limitsResolver()
+	resolver.APIKeys = store
+	h := NewHandler(resolver, HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10})
+
+	res := postQuery(t, h, map[string]interface{}{
+		"query": `mutation { createAPIKey(input: {name: "ci", scopes: [READ]}) { key api_key { id owner scopes } } }`,
+	})
+	if len(res.Errors) != 0 {
+		t.Fatalf("unexpected error: %s", res.Errors[0].Message)
+	}
+
+	payload := res.Data["createAPIKey"].(map[string]interface{})
+	key := payload["key"].(string)
+	if !strings.HasPrefix(key, "tdk_") {
+		t.Errorf("expected a tdk_ key, got %s", key)
+	}
+	if store.hash != auth.HashAPIKey(key) {
+		t.Error("expected the store to receive the hash of the returned key")
+	}
+	if store.owner != "test" {
+		t.Errorf("expected the key to be owned by the admin creating it, got %q", store.owner)
+	}
+	res = postQuery(t, h, map[string]interface{}{
+		"query": `mutation { createAPIKey(input: {name: "ci", owner: "root", scopes: [ADMIN]}) { key } }`,
+	})
+	if len(res.Errors) == 0 {
+		t.Error("expected an owner to be rejected")
+	}
+}
@@ -0,0 +1,101 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/bulk"
+	"github.com/jdharms/threat-detect/internal/db"
+	"github.com/jdharms/threat-detect/internal/dnsbl"
+	"github.com/jdharms/threat-detect/internal/jobs"
+	"github.com/jdharms/threat-detect/internal/ratelimit"
+)
+
+// Values placed in the "code" extension of errors returned to GraphQL clients.
+const (
+	CodeNotFound        = "NOT_FOUND"
+	CodeInvalidInput    = "INVALID_INPUT"
+	CodeUnauthenticated = "UNAUTHENTICATED"
+	CodeForbidden       = "FORBIDDEN"
+	CodeRateLimited     = "RATE_LIMITED"
+	CodeInternal        = "INTERNAL"
+)
+
+const internalErrorMessage = "internal error"
+
+// InputError reports an argument that failed validation.
+type InputError string
+
+func (e InputError) Error() string {
+	return string(e)
+}
+
+// ErrorPresenter maps errors returned by resolvers onto machine-readable codes.  Errors
+// we don't recognize are logged and replaced with a generic message so that database
+// and network details aren't leaked to callers.
+func ErrorPresenter(ctx context.Context, err error) *gqlerror.Error {
+	var gqlErr *gqlerror.Error
+	if !errors.As(err, &gqlErr) {
+		gqlErr = gqlerror.WrapPath(graphql.GetPath(ctx), err)
+	}
+
+	// Errors created by gqlgen itself (parsing, validation, bad arguments) don't wrap
+	// anything and are already safe to show to the caller.
+	inner := errors.Unwrap(gqlErr)
+	if inner == nil {
+		return gqlErr
+	}
+
+	code := ErrorCode(inner)
+	if code == CodeInternal {
+		log.Printf("internal error at %s: %s", gqlErr.Path.String(), inner.Error())
+		gqlErr.Message = internalErrorMessage
+	}
+	errcode.Set(gqlErr, code)
+	if retryAfter := RetryAfter(inner); retryAfter > 0 {
+		gqlErr.Extensions["retryAfter"] = retryAfter
+	}
+
+	return gqlErr
+}
+
+// ErrorCode classifies an error returned by the service's dependencies.
+func ErrorCode(err error) string {
+	var notFound db.ErrNotFound
+	var jobNotFound jobs.ErrNotFound
+	var invalidIP dnsbl.InvalidIPv4AddrError
+	var invalidInput InputError
+	var badRecord *bulk.RecordError
+	var limited ratelimit.ErrLimited
+	switch {
+	case errors.As(err, &notFound), errors.As(err, &jobNotFound):
+		return CodeNotFound
+	case errors.As(err, &invalidIP), errors.As(err, &invalidInput), errors.As(err, &badRecord):
+		return CodeInvalidInput
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return CodeUnauthenticated
+	case errors.Is(err, auth.ErrForbidden):
+		return CodeForbidden
+	case errors.As(err, &limited):
+		return CodeRateLimited
+	default:
+		return CodeInternal
+	}
+}
+
+// RetryAfter returns the number of seconds a rate limited caller should wait before
+// retrying, or zero if err isn't a rate limit that will lift.
+func RetryAfter(err error) int {
+	var limited ratelimit.ErrLimited
+	if !errors.As(err, &limited) {
+		return 0
+	}
+	return int(math.Ceil(limited.RetryAfter.Seconds()))
+}
@@ -0,0 +1,94 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/bulk"
+	"github.com/jdharms/threat-detect/internal/db"
+	"github.com/jdharms/threat-detect/internal/dnsbl"
+)
+
+func TestErrorPresenter(t *testing.T) {
+	testCases := []struct {
+		name            string
+		err             error
+		expectedCode    interface{}
+		expectedMessage string
+	}{
+		{
+			"not found",
+			db.ErrNotFound{},
+			CodeNotFound,
+			"",
+		},
+		{
+			"invalid ip",
+			dnsbl.ValidateIPv4("foobar"),
+			CodeInvalidInput,
+			"foobar is not a valid IPv4 address",
+		},
+		{
+			"unreadable import",
+			&bulk.RecordError{Record: 3, Err: fmt.Errorf("invalid updated_at")},
+			CodeInvalidInput,
+			"record 3: invalid updated_at",
+		},
+		{
+			"unauthenticated",
+			fmt.Errorf("checking role: %w", auth.ErrUnauthenticated),
+			CodeUnauthenticated,
+			"",
+		},
+		{
+			"forbidden",
+			auth.ErrForbidden,
+			CodeForbidden,
+			"",
+		},
+		{
+			"internal error text is hidden",
+			fmt.Errorf("error inserting row: database is locked"),
+			CodeInternal,
+			internalErrorMessage,
+		},
+		{
+			"gqlgen errors pass through",
+			&gqlerror.Error{Message: "bad argument"},
+			nil,
+			"bad argument",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			res := ErrorPresenter(context.Background(), graphql.ErrorOnPath(context.Background(), test.err))
+			if res.Extensions["code"] != test.expectedCode {
+				t.Errorf("expected code %v but got %v", test.expectedCode, res.Extensions["code"])
+			}
+			if test.expectedMessage != "" && res.Message != test.expectedMessage {
+				t.Errorf("expected message '%s' but got '%s'", test.expectedMessage, res.Message)
+			}
+		})
+	}
+}
+
+func TestGetIPDetailsRejectsInvalidIP(t *testing.T) {
+	sut := Resolver{
+		Getter: mockGetter{getFunc: func(s string) (model.IPDetails, error) {
+			t.Error("Getter should not be called for an invalid ip")
+			return model.IPDetails{}, nil
+		}},
+	}
+
+	_, err := sut.Query().GetIPDetails(context.Background(), "not-an-ip")
+	if _, ok := err.(dnsbl.InvalidIPv4AddrError); !ok {
+		t.Errorf("expected an InvalidIPv4AddrError, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package graph
+
+import (
+	"context"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+
+	"github.com/jdharms/threat-detect/graph/generated"
+)
+
+type HandlerOptions struct {
+	// ComplexityLimit is the largest complexity an operation may have, as computed with
+	// the budgets from Complexity().
+	ComplexityLimit int
+
+	// DepthLimit is the deepest an operation's selections may be nested.
+	DepthLimit int
+
+	// PersistedQueries, when non-nil, restricts clients to the listed operations.
+	// Otherwise clients may register any query with automatic persisted queries.
+	PersistedQueries PersistedQueries
+}
+
+// NewHandler builds the GraphQL server for the resolver, mirroring
+// handler.NewDefaultServer with the addition of query and rate limits.
+func NewHandler(resolver *Resolver, opts HandlerOptions) *handler.Server {
+	srv := handler.New(generated.NewExecutableSchema(generated.Config{
+		Resolvers:  resolver,
+		Complexity: Complexity(),
+		Directives: generated.DirectiveRoot{HasRole: HasRole},
+	}))
+
+	srv.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: 10 * time.Second,
+	})
+	srv.AddTransport(transport.Options{})
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.MultipartForm{})
+
+	srv.SetQueryCache(lru.New(1000))
+	srv.SetErrorPresenter(ErrorPresenter)
+	srv.AroundOperations(func(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+		if err := resolver.AllowQuery(ctx); err != nil {
+			return graphql.OneShot(&graphql.Response{Errors: gqlerror.List{ErrorPresenter(ctx, err)}})
+		}
+		return next(contextWithAnnotationLoader(ctx, resolver.Annotations))
+	})
+
+	srv.Use(extension.Introspection{})
+	if opts.PersistedQueries != nil {
+		srv.Use(extension.AutomaticPersistedQuery{Cache: opts.PersistedQueries})
+		srv.Use(opts.PersistedQueries)
+	} else {
+		srv.Use(extension.AutomaticPersistedQuery{Cache: lru.New(100)})
+	}
+	srv.Use(extension.FixedComplexityLimit(opts.ComplexityLimit))
+	srv.Use(DepthLimit{Limit: opts.DepthLimit})
+
+	return srv
+}
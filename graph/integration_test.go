@@ -0,0 +1,139 @@
+package graph
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/db"
+	"github.com/jdharms/threat-detect/internal/jobs"
+)
+
+// TestServerWithMemoryStore runs operations through the whole GraphQL handler, with
+// only the DNSBL faked.
+func TestServerWithMemoryStore(t *testing.T) {
+	store := db.NewMemoryStore()
+	h := NewHandler(&Resolver{
+		Adder:   store,
+		Getter:  store,
+		Deleter: store,
+		Shared:  store,
+		Stats:   store,
+		DNSBL:   &countingDNSBL{},
+		Jobs:    jobs.NewTracker(),
+		APIKeys: store,
+		Audit:   store,
+	}, HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10})
+	alice := auth.Principal{Name: "alice", Roles: auth.AllRoles, Tenant: "red"}
+
+	res := postQueryAs(t, h, alice, map[string]interface{}{
+		"query": `mutation { enqueue(ip: ["1.2.3.4"]) { job_id } }`,
+	})
+	if len(res.Errors) > 0 {
+		t.Fatalf("unexpected errors enqueueing: %v", res.Errors)
+	}
+	jobID := res.Data["enqueue"].(map[string]interface{})["job_id"]
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res = postQueryAs(t, h, alice, map[string]interface{}{
+			"query":     `query($id: ID!) { job(id: $id) { status } }`,
+			"variables": map[string]interface{}{"id": jobID},
+		})
+		if len(res.Errors) > 0 {
+			t.Fatalf("unexpected errors checking job: %v", res.Errors)
+		}
+		if res.Data["job"].(map[string]interface{})["status"] == "DONE" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the job to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res = postQueryAs(t, h, alice, map[string]interface{}{
+		"query": `{
+			getIPDetails(ip: "1.2.3.4") { response_code }
+			stats { total listed }
+			auditLog { operation }
+		}`,
+	})
+	if len(res.Errors) > 0 {
+		t.Fatalf("unexpected errors querying: %v", res.Errors)
+	}
+	if code := res.Data["getIPDetails"].(map[string]interface{})["response_code"]; code != "127.0.0.2" {
+		t.Errorf("expected the stored response code, got %v", code)
+	}
+	if stats := res.Data["stats"].(map[string]interface{}); stats["total"] != 1.0 || stats["listed"] != 1.0 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+	if entries := res.Data["auditLog"].([]interface{}); len(entries) != 2 {
+		t.Errorf("expected the enqueue and lookup to be audited, got %v", entries)
+	}
+
+	bob := auth.Principal{Name: "bob", Roles: auth.AllRoles, Tenant: "blue"}
+	res = postQueryAs(t, h, bob, map[string]interface{}{
+		"query": `{ getIPDetails(ip: "1.2.3.4") { response_code } }`,
+	})
+	if errorCode(res) != CodeNotFound {
+		t.Errorf("expected another tenant's lookup not to be found, got %v", res.Errors)
+	}
+}
+
+// TestAnnotationsWithMemoryStore tags and notes an address, then reads them back through
+// the stored result.
+func TestAnnotationsWithMemoryStore(t *testing.T) {
+	store := db.NewMemoryStore()
+	if err := store.AddIPDetails(context.Background(), "red", model.IPDetails{IPAddress: "1.2.3.4", ResponseCode: "127.0.0.2"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	h := NewHandler(&Resolver{
+		Getter:      store,
+		Networks:    store,
+		Annotations: store,
+	}, HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10})
+	alice := auth.Principal{Name: "alice", Roles: auth.AllRoles, Tenant: "red"}
+
+	res := postQueryAs(t, h, alice, map[string]interface{}{
+		"query": `mutation {
+			tagIP(ip: "1.2.3.4", tags: ["relay", "customer"]) { name }
+			addNote(ip: "1.2.3.4", body: "known customer relay") { id }
+		}`,
+	})
+	if len(res.Errors) > 0 {
+		t.Fatalf("unexpected errors annotating: %v", res.Errors)
+	}
+
+	res = postQueryAs(t, h, alice, map[string]interface{}{
+		"query": `{
+			getIPDetails(ip: "1.2.3.4") { tags { name author } notes { body author } }
+			ipDetailsInNetwork(cidr: "1.2.3.0/24", filter: {tag: "relay"}) { ip_address }
+		}`,
+	})
+	if len(res.Errors) > 0 {
+		t.Fatalf("unexpected errors querying: %v", res.Errors)
+	}
+	details := res.Data["getIPDetails"].(map[string]interface{})
+	tags := details["tags"].([]interface{})
+	if len(tags) != 2 || tags[0].(map[string]interface{})["name"] != "customer" || tags[0].(map[string]interface{})["author"] != "alice" {
+		t.Errorf("expected both tags by alice, got %v", tags)
+	}
+	notes := details["notes"].([]interface{})
+	if len(notes) != 1 || notes[0].(map[string]interface{})["body"] != "known customer relay" || notes[0].(map[string]interface{})["author"] != "alice" {
+		t.Errorf("expected alice's note, got %v", notes)
+	}
+	if found := res.Data["ipDetailsInNetwork"].([]interface{}); len(found) != 1 {
+		t.Errorf("expected the tagged address to be found, got %v", found)
+	}
+
+	reader := auth.Principal{Name: "carol", Roles: []string{auth.RoleReader}, Tenant: "red"}
+	res = postQueryAs(t, h, reader, map[string]interface{}{
+		"query": `mutation { tagIP(ip: "1.2.3.4", tags: ["vpn"]) { name } }`,
+	})
+	if errorCode(res) != CodeForbidden {
+		t.Errorf("expected a reader not to be allowed to tag, got %v", res.Errors)
+	}
+}
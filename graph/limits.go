@@ -0,0 +1,152 @@
+package graph
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	CodeDepthLimitExceeded = "DEPTH_LIMIT_EXCEEDED"
+	CodeQueryNotAllowed    = "PERSISTED_QUERY_NOT_ALLOWED"
+)
+
+// DepthLimit rejects operations whose selection sets are nested more than Limit fields
+// deep.  Introspection fields are not counted so that tooling keeps working.
+type DepthLimit struct {
+	Limit int
+}
+
+var _ interface {
+	graphql.OperationContextMutator
+	graphql.HandlerExtension
+} = DepthLimit{}
+
+func (d DepthLimit) ExtensionName() string {
+	return "DepthLimit"
+}
+
+func (d DepthLimit) Validate(schema graphql.ExecutableSchema) error {
+	if d.Limit < 1 {
+		return fmt.Errorf("DepthLimit.Limit must be at least 1")
+	}
+	return nil
+}
+
+func (d DepthLimit) MutateOperationContext(ctx context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	op := rc.Doc.Operations.ForName(rc.OperationName)
+	if op == nil {
+		return nil
+	}
+
+	if depth := selectionDepth(op.SelectionSet); depth > d.Limit {
+		err := gqlerror.Errorf("operation has depth %d, which exceeds the limit of %d", depth, d.Limit)
+		errcode.Set(err, CodeDepthLimitExceeded)
+		return err
+	}
+
+	return nil
+}
+
+func selectionDepth(set ast.SelectionSet) int {
+	deepest := 0
+	for _, sel := range set {
+		depth := 0
+		switch s := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name, "__") {
+				continue
+			}
+			depth = 1 + selectionDepth(s.SelectionSet)
+		case *ast.InlineFragment:
+			depth = selectionDepth(s.SelectionSet)
+		case *ast.FragmentSpread:
+			if s.Definition != nil {
+				depth = selectionDepth(s.Definition.SelectionSet)
+			}
+		}
+		if depth > deepest {
+			deepest = depth
+		}
+	}
+	return deepest
+}
+
+// PersistedQueries is a read only set of known operations keyed by the hex encoded
+// SHA-256 hash of their query text.  It can be used as the cache for gqlgen's
+// AutomaticPersistedQuery extension so clients can send hashes in place of queries,
+// and as an extension itself to reject any operation that isn't in the set.
+type PersistedQueries map[string]string
+
+var _ interface {
+	graphql.Cache
+	graphql.OperationParameterMutator
+	graphql.HandlerExtension
+} = PersistedQueries{}
+
+// LoadPersistedQueries reads a JSON object mapping query hashes to query text,
+// verifying that every hash matches its query.
+func LoadPersistedQueries(path string) (PersistedQueries, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading persisted queries: %w", err)
+	}
+
+	var listed map[string]string
+	if err := json.Unmarshal(contents, &listed); err != nil {
+		return nil, fmt.Errorf("error parsing persisted queries: %w", err)
+	}
+
+	// Hashes are kept in lowercase, as queryHash returns them, whichever case the file
+	// uses.
+	pq := PersistedQueries{}
+	for hash, query := range listed {
+		if queryHash(query) != strings.ToLower(hash) {
+			return nil, fmt.Errorf("persisted query hash %s does not match its query", hash)
+		}
+		pq[strings.ToLower(hash)] = query
+	}
+
+	return pq, nil
+}
+
+func (pq PersistedQueries) Get(ctx context.Context, key string) (interface{}, bool) {
+	query, ok := pq[strings.ToLower(key)]
+	return query, ok
+}
+
+// Add is a no-op: clients aren't allowed to register new queries.
+func (pq PersistedQueries) Add(ctx context.Context, key string, value interface{}) {}
+
+func (pq PersistedQueries) ExtensionName() string {
+	return "PersistedQueryAllowlist"
+}
+
+func (pq PersistedQueries) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+// MutateOperationParameters must run after the AutomaticPersistedQuery extension so that
+// hash-only requests have already had their query text filled in.
+func (pq PersistedQueries) MutateOperationParameters(ctx context.Context, rawParams *graphql.RawParams) *gqlerror.Error {
+	if _, ok := pq[queryHash(rawParams.Query)]; !ok {
+		err := gqlerror.Errorf("operation is not in the list of allowed queries")
+		errcode.Set(err, CodeQueryNotAllowed)
+		return err
+	}
+	return nil
+}
+
+func queryHash(query string) string {
+	b := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,265 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/db"
+	"github.com/jdharms/threat-detect/internal/ratelimit"
+)
+
+type gqlResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func postQuery(t *testing.T, h http.Handler, body map[string]interface{}) gqlResponse {
+	return postQueryAs(t, h, auth.Principal{Name: "test", Roles: auth.AllRoles}, body)
+}
+
+func postQueryAs(t *testing.T, h http.Handler, p auth.Principal, body map[string]interface{}) gqlResponse {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("error encoding request: %s", err.Error())
+	}
+
+	req := httptest.NewRequest("POST", "/graphql", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(auth.ContextWithPrincipal(req.Context(), p))
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+
+	var res gqlResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &res); err != nil {
+		t.Fatalf("error decoding response %q: %s", recorder.Body.String(), err.Error())
+	}
+	return res
+}
+
+func errorCode(res gqlResponse) string {
+	if len(res.Errors) == 0 {
+		return ""
+	}
+	code, _ := res.Errors[0].Extensions["code"].(string)
+	return code
+}
+
+func limitsResolver() *Resolver {
+	return &Resolver{
+		Getter: mockGetter{getFunc: func(s string) (model.IPDetails, error) {
+			return model.IPDetails{IPAddress: s}, nil
+		}},
+		Stats: &mockStats{},
+	}
+}
+
+const detailsQuery = `{ getIPDetails(ip: "1.2.3.4") { ip_address } }`
+
+func TestDepthLimit(t *testing.T) {
+	h := NewHandler(limitsResolver(), HandlerOptions{ComplexityLimit: 1000, DepthLimit: 2})
+
+	res := postQuery(t, h, map[string]interface{}{"query": detailsQuery})
+	if len(res.Errors) != 0 {
+		t.Errorf("unexpected error for shallow query: %s", res.Errors[0].Message)
+	}
+
+	res = postQuery(t, h, map[string]interface{}{
+		"query": `query { ...s } fragment s on Query { stats { by_listing { count } } }`,
+	})
+	if errorCode(res) != CodeDepthLimitExceeded {
+		t.Errorf("expected %s for deep query, got %+v", CodeDepthLimitExceeded, res.Errors)
+	}
+}
+
+func TestComplexityLimit(t *testing.T) {
+	h := NewHandler(limitsResolver(), HandlerOptions{ComplexityLimit: 10, DepthLimit: 10})
+
+	res := postQuery(t, h, map[string]interface{}{
+		"query": `mutation { enqueue(ip: ["1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4", "5.5.5.5"]) { queued_ips } }`,
+	})
+	if errorCode(res) != "COMPLEXITY_LIMIT_EXCEEDED" {
+		t.Errorf("expected complexity error, got %+v", res.Errors)
+	}
+
+	res = postQuery(t, h, map[string]interface{}{"query": `{ stats { total } }`})
+	if errorCode(res) != "COMPLEXITY_LIMIT_EXCEEDED" {
+		t.Errorf("expected complexity error for stats, got %+v", res.Errors)
+	}
+}
+
+func TestNetworkSearchComplexity(t *testing.T) {
+	resolver := limitsResolver()
+	resolver.Networks = db.NewMemoryStore()
+	h := NewHandler(resolver, HandlerOptions{ComplexityLimit: 500, DepthLimit: 10})
+
+	testCases := []struct {
+		name    string
+		query   string
+		allowed bool
+	}{
+		{"default limit", `{ ipDetailsInNetwork(cidr: "10.0.0.0/8") { ip_address } }`, true},
+		{"default limit, more fields", `{ ipDetailsInNetwork(cidr: "10.0.0.0/8") { ip_address response_code created_at updated_at uuid } }`, false},
+		{"small limit, more fields", `{ ipDetailsInNetwork(cidr: "10.0.0.0/8", filter: {limit: 50}) { ip_address response_code created_at updated_at uuid } }`, true},
+		{"large limit", `{ ipDetailsInNetwork(cidr: "10.0.0.0/8", filter: {limit: 1000}) { ip_address } }`, false},
+		{"limit over the maximum", `{ ipDetailsInNetwork(cidr: "10.0.0.0/8", filter: {limit: 100000000}) { ip_address } }`, false},
+		{"annotations", `{ ipDetailsInNetwork(cidr: "10.0.0.0/8", filter: {limit: 50}) { ip_address tags { name } notes { body } } }`, false},
+		{"small limit, annotations", `{ ipDetailsInNetwork(cidr: "10.0.0.0/8", filter: {limit: 20}) { ip_address tags { name } notes { body } } }`, true},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			res := postQuery(t, h, map[string]interface{}{"query": test.query})
+			if limited := errorCode(res) == "COMPLEXITY_LIMIT_EXCEEDED"; limited == test.allowed {
+				t.Errorf("expected allowed to be %v, got %+v", test.allowed, res.Errors)
+			}
+		})
+	}
+}
+
+func TestExportComplexity(t *testing.T) {
+	resolver := limitsResolver()
+	resolver.Bulk = db.NewMemoryStore()
+	h := NewHandler(resolver, HandlerOptions{ComplexityLimit: 500, DepthLimit: 10})
+
+	testCases := []struct {
+		name    string
+		query   string
+		allowed bool
+	}{
+		{"default limit", `{ exportIPDetails(format: CSV) }`, true},
+		{"larger limit", `{ exportIPDetails(format: CSV, filter: {limit: 5000}) }`, true},
+		{"maximum limit", `{ exportIPDetails(format: CSV, filter: {limit: 10000}) }`, false},
+		{"limit over the maximum", `{ exportIPDetails(format: CSV, filter: {limit: 100000000}) }`, false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			res := postQuery(t, h, map[string]interface{}{"query": test.query})
+			if limited := errorCode(res) == "COMPLEXITY_LIMIT_EXCEEDED"; limited == test.allowed {
+				t.Errorf("expected allowed to be %v, got %+v", test.allowed, res.Errors)
+			}
+		})
+	}
+}
+
+func TestPersistedQueryAllowlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.json")
+	contents, _ := json.Marshal(map[string]string{queryHash(detailsQuery): detailsQuery})
+	if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	pq, err := LoadPersistedQueries(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading persisted queries: %s", err.Error())
+	}
+
+	h := NewHandler(limitsResolver(), HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10, PersistedQueries: pq})
+
+	res := postQuery(t, h, map[string]interface{}{"query": detailsQuery})
+	if len(res.Errors) != 0 {
+		t.Errorf("unexpected error for allowed query: %s", res.Errors[0].Message)
+	}
+
+	res = postQuery(t, h, map[string]interface{}{
+		"extensions": map[string]interface{}{
+			"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": queryHash(detailsQuery)},
+		},
+	})
+	if len(res.Errors) != 0 {
+		t.Errorf("unexpected error for allowed query sent by hash: %s", res.Errors[0].Message)
+	}
+
+	res = postQuery(t, h, map[string]interface{}{"query": `{ stats { total } }`})
+	if errorCode(res) != CodeQueryNotAllowed {
+		t.Errorf("expected %s for unknown query, got %+v", CodeQueryNotAllowed, res.Errors)
+	}
+}
+
+func TestPersistedQueryHashCase(t *testing.T) {
+	hash := strings.ToUpper(queryHash(detailsQuery))
+	path := filepath.Join(t.TempDir(), "queries.json")
+	contents, _ := json.Marshal(map[string]string{hash: detailsQuery})
+	if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	pq, err := LoadPersistedQueries(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading persisted queries: %s", err.Error())
+	}
+	h := NewHandler(limitsResolver(), HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10, PersistedQueries: pq})
+
+	res := postQuery(t, h, map[string]interface{}{"query": detailsQuery})
+	if len(res.Errors) != 0 {
+		t.Errorf("unexpected error for a query listed with an uppercase hash: %s", res.Errors[0].Message)
+	}
+
+	res = postQuery(t, h, map[string]interface{}{
+		"extensions": map[string]interface{}{
+			"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": hash},
+		},
+	})
+	if len(res.Errors) != 0 {
+		t.Errorf("unexpected error for a query sent by uppercase hash: %s", res.Errors[0].Message)
+	}
+}
+
+func TestLoadPersistedQueriesRejectsBadHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.json")
+	contents, _ := json.Marshal(map[string]string{strings.Repeat("0", 64): detailsQuery})
+	if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := LoadPersistedQueries(path); err == nil {
+		t.Error("expected an error loading a query with a mismatched hash")
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	resolver := limitsResolver()
+	resolver.Limits = ratelimit.NewLimiter(ratelimit.Config{
+		Default: ratelimit.Policy{QueriesPerMinute: 2, IPsPerHour: 1},
+	})
+	h := NewHandler(resolver, HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10})
+
+	res := postQuery(t, h, map[string]interface{}{
+		"query": `mutation { enqueue(ip: ["1.1.1.1", "2.2.2.2"]) { job_id } }`,
+	})
+	if errorCode(res) != CodeRateLimited {
+		t.Errorf("expected %s for too many IPs, got %+v", CodeRateLimited, res.Errors)
+	}
+	if _, ok := res.Errors[0].Extensions["retryAfter"]; ok {
+		t.Error("expected no retryAfter for a request larger than the limit")
+	}
+
+	res = postQuery(t, h, map[string]interface{}{"query": detailsQuery})
+	if len(res.Errors) != 0 {
+		t.Errorf("unexpected error: %s", res.Errors[0].Message)
+	}
+
+	res = postQuery(t, h, map[string]interface{}{"query": detailsQuery})
+	if errorCode(res) != CodeRateLimited {
+		t.Fatalf("expected %s once the query limit is used up, got %+v", CodeRateLimited, res.Errors)
+	}
+	if res.Errors[0].Extensions["retryAfter"] != float64(30) {
+		t.Errorf("expected retryAfter of 30 seconds, got %v", res.Errors[0].Extensions["retryAfter"])
+	}
+
+	res = postQueryAs(t, h, auth.Principal{Name: "other", Roles: auth.AllRoles}, map[string]interface{}{"query": detailsQuery})
+	if len(res.Errors) != 0 {
+		t.Errorf("expected other principals not to be limited, got %s", res.Errors[0].Message)
+	}
+}
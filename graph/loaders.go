@@ -0,0 +1,129 @@
+package graph
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+)
+
+// How long the annotation loader waits for more addresses before querying, and the most
+// addresses it asks for in one query.
+const (
+	annotationBatchWait = time.Millisecond
+	maxAnnotationBatch  = 500
+)
+
+type loaderCtx string
+
+// annotationLoader batches the tags and notes fields of the records in one operation.
+// gqlgen resolves the records in a list concurrently, so their fields arrive together
+// and a list of records costs one query for each field rather than one per record.
+type annotationLoader struct {
+	store  Annotations
+	tenant string
+
+	mu    sync.Mutex
+	tags  *annotationBatch
+	notes *annotationBatch
+}
+
+// annotationBatch collects addresses until it's fetched, and then holds the results.
+type annotationBatch struct {
+	addrs   []string
+	started bool
+	done    chan struct{}
+
+	tags  map[string][]*model.Tag
+	notes map[string][]*model.Note
+	err   error
+}
+
+// contextWithAnnotationLoader returns a copy of ctx holding a loader for the tenant of
+// the principal in ctx.
+func contextWithAnnotationLoader(ctx context.Context, store Annotations) context.Context {
+	l := &annotationLoader{store: store, tenant: auth.TenantFromContext(ctx)}
+	return context.WithValue(ctx, loaderCtx("annotations"), l)
+}
+
+// annotationLoaderFromContext returns the loader in ctx, or a new one if the operation
+// didn't start with one.
+func annotationLoaderFromContext(ctx context.Context, store Annotations) *annotationLoader {
+	if l, ok := ctx.Value(loaderCtx("annotations")).(*annotationLoader); ok {
+		return l
+	}
+	return &annotationLoader{store: store, tenant: auth.TenantFromContext(ctx)}
+}
+
+// Tags returns the tags on addr by name.
+func (l *annotationLoader) Tags(ctx context.Context, addr string) ([]*model.Tag, error) {
+	b := l.join(&l.tags, addr, func(b *annotationBatch) {
+		b.tags, b.err = l.store.Tags(ctx, l.tenant, b.addrs)
+	})
+	<-b.done
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.tags[addr] == nil {
+		return []*model.Tag{}, nil
+	}
+	return b.tags[addr], nil
+}
+
+// Notes returns the notes on addr, oldest first.
+func (l *annotationLoader) Notes(ctx context.Context, addr string) ([]*model.Note, error) {
+	b := l.join(&l.notes, addr, func(b *annotationBatch) {
+		b.notes, b.err = l.store.Notes(ctx, l.tenant, b.addrs)
+	})
+	<-b.done
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.notes[addr] == nil {
+		return []*model.Note{}, nil
+	}
+	return b.notes[addr], nil
+}
+
+// join adds addr to the batch waiting in *current, starting one if there isn't one.  The
+// batch is fetched annotationBatchWait after it was started, or as soon as it's full.
+func (l *annotationLoader) join(current **annotationBatch, addr string, fetch func(*annotationBatch)) *annotationBatch {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := *current
+	if b == nil {
+		b = &annotationBatch{done: make(chan struct{})}
+		*current = b
+		time.AfterFunc(annotationBatchWait, func() { l.run(current, b, fetch) })
+	}
+
+	b.addrs = append(b.addrs, addr)
+	if len(b.addrs) == maxAnnotationBatch {
+		*current = nil
+		go l.run(current, b, fetch)
+	}
+	return b
+}
+
+// run fetches b unless it has already been fetched.  No more addresses are added to b
+// once it has started.
+func (l *annotationLoader) run(current **annotationBatch, b *annotationBatch, fetch func(*annotationBatch)) {
+	l.mu.Lock()
+	if b.started {
+		l.mu.Unlock()
+		return
+	}
+	b.started = true
+	if *current == b {
+		*current = nil
+	}
+	l.mu.Unlock()
+
+	fetch(b)
+	close(b.done)
+}
@@ -0,0 +1,117 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/db"
+)
+
+// countingAnnotations counts the queries made for tags and notes, and records how many
+// addresses each query for tags asked for.
+type countingAnnotations struct {
+	*db.MemoryStore
+
+	mu         sync.Mutex
+	tagCalls   int
+	noteCalls  int
+	tagBatches []int
+}
+
+func (c *countingAnnotations) Tags(ctx context.Context, tenant string, addrs []string) (map[string][]*model.Tag, error) {
+	c.mu.Lock()
+	c.tagCalls++
+	c.tagBatches = append(c.tagBatches, len(addrs))
+	c.mu.Unlock()
+	return c.MemoryStore.Tags(ctx, tenant, addrs)
+}
+
+func (c *countingAnnotations) Notes(ctx context.Context, tenant string, addrs []string) (map[string][]*model.Note, error) {
+	c.mu.Lock()
+	c.noteCalls++
+	c.mu.Unlock()
+	return c.MemoryStore.Notes(ctx, tenant, addrs)
+}
+
+func TestAnnotationsAreBatched(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	for i := 1; i <= 5; i++ {
+		addr := fmt.Sprintf("10.0.0.%d", i)
+		if err := store.AddIPDetails(ctx, "red", model.IPDetails{IPAddress: addr}); err != nil {
+			t.Fatal(err.Error())
+		}
+		if i%2 == 1 {
+			if _, err := store.TagIP(ctx, "red", addr, []string{"relay"}, "alice"); err != nil {
+				t.Fatal(err.Error())
+			}
+			if _, err := store.AddNote(ctx, "red", model.Note{IPAddress: addr, Body: "seen " + addr, Author: "alice"}); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+	}
+	annotations := &countingAnnotations{MemoryStore: store}
+	h := NewHandler(&Resolver{Networks: store, Annotations: annotations}, HandlerOptions{ComplexityLimit: 1000, DepthLimit: 10})
+	alice := auth.Principal{Name: "alice", Roles: auth.AllRoles, Tenant: "red"}
+
+	res := postQueryAs(t, h, alice, map[string]interface{}{
+		"query": `{ ipDetailsInNetwork(cidr: "10.0.0.0/24", filter: {limit: 10}) { ip_address tags { name } notes { body } } }`,
+	})
+	if len(res.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", res.Errors)
+	}
+	if annotations.tagCalls != 1 || annotations.noteCalls != 1 {
+		t.Errorf("expected one query for tags and one for notes, got %d and %d", annotations.tagCalls, annotations.noteCalls)
+	}
+
+	records := res.Data["ipDetailsInNetwork"].([]interface{})
+	if len(records) != 5 {
+		t.Fatalf("expected 5 records, got %d", len(records))
+	}
+	for i, r := range records {
+		record := r.(map[string]interface{})
+		tags, notes := record["tags"].([]interface{}), record["notes"].([]interface{})
+		expected := 0
+		if i%2 == 0 {
+			expected = 1
+		}
+		if len(tags) != expected || len(notes) != expected {
+			t.Errorf("expected %d tags and notes on %s, got %v and %v", expected, record["ip_address"], tags, notes)
+		}
+	}
+}
+
+func TestAnnotationLoaderSplitsLargeBatches(t *testing.T) {
+	annotations := &countingAnnotations{MemoryStore: db.NewMemoryStore()}
+	ctx := auth.ContextWithPrincipal(context.Background(), auth.Principal{Name: "alice", Tenant: "red"})
+	ctx = contextWithAnnotationLoader(ctx, annotations)
+	l := annotationLoaderFromContext(ctx, annotations)
+
+	var wg sync.WaitGroup
+	for i := 0; i < maxAnnotationBatch+1; i++ {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			tags, err := l.Tags(ctx, addr)
+			if err != nil || tags == nil || len(tags) != 0 {
+				t.Errorf("expected no tags on %s, got %v and %v", addr, tags, err)
+			}
+		}(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+	wg.Wait()
+
+	total := 0
+	for _, n := range annotations.tagBatches {
+		if n > maxAnnotationBatch {
+			t.Errorf("expected at most %d addresses in a query, got %d", maxAnnotationBatch, n)
+		}
+		total += n
+	}
+	if total != maxAnnotationBatch+1 || len(annotations.tagBatches) < 2 {
+		t.Errorf("expected every address to be asked for once, got batches of %v", annotations.tagBatches)
+	}
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// IPDetails is written by hand, rather than generated, so that its tags and notes are
+// resolved only when a query asks for them.
+type IPDetails struct {
+	UUID         string    `json:"uuid"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	ResponseCode string    `json:"response_code"`
+	IPAddress    string    `json:"ip_address"`
+}
@@ -3,17 +3,400 @@
 package model
 
 import (
+	"fmt"
+	"io"
+	"strconv"
 	"time"
 )
 
+type APIKey struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	Owner     string        `json:"owner"`
+	Scopes    []APIKeyScope `json:"scopes"`
+	CreatedAt time.Time     `json:"created_at"`
+	ExpiresAt *time.Time    `json:"expires_at"`
+	RevokedAt *time.Time    `json:"revoked_at"`
+}
+
+type AuditEntry struct {
+	ID        string         `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	Principal string         `json:"principal"`
+	APIKeyID  *string        `json:"api_key_id"`
+	ClientIP  string         `json:"client_ip"`
+	Operation AuditOperation `json:"operation"`
+	Arguments string         `json:"arguments"`
+}
+
+type AuditLogFilter struct {
+	Principal *string         `json:"principal"`
+	Operation *AuditOperation `json:"operation"`
+	IP        *string         `json:"ip"`
+	Since     *time.Time      `json:"since"`
+	Until     *time.Time      `json:"until"`
+	Limit     *int            `json:"limit"`
+}
+
+type Backup struct {
+	Path      string    `json:"path"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateAPIKeyInput struct {
+	Name      string        `json:"name"`
+	Scopes    []APIKeyScope `json:"scopes"`
+	ExpiresAt *time.Time    `json:"expires_at"`
+}
+
+type CreateAPIKeyPayload struct {
+	// The key itself.  It is not stored and cannot be retrieved again.
+	Key    string  `json:"key"`
+	APIKey *APIKey `json:"api_key"`
+}
+
+type DailyLookups struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
 type EnqueuePayload struct {
+	JobID     string   `json:"job_id"`
 	QueuedIps []string `json:"queued_ips"`
 }
 
-type IPDetails struct {
-	UUID         string    `json:"uuid"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ResponseCode string    `json:"response_code"`
-	IPAddress    string    `json:"ip_address"`
+type ExportFilter struct {
+	Cidr   *string    `json:"cidr"`
+	Listed *bool      `json:"listed"`
+	Tag    *string    `json:"tag"`
+	Note   *string    `json:"note"`
+	Since  *time.Time `json:"since"`
+	Until  *time.Time `json:"until"`
+	Limit  *int       `json:"limit"`
+}
+
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+type Job struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Status     JobStatus  `json:"status"`
+	Ips        []string   `json:"ips"`
+	Completed  int        `json:"completed"`
+	Failed     int        `json:"failed"`
+}
+
+type ListingCount struct {
+	Listing string `json:"listing"`
+	Count   int    `json:"count"`
+}
+
+type NetworkCount struct {
+	Network string `json:"network"`
+	Listed  int    `json:"listed"`
+}
+
+type NetworkFilter struct {
+	Listed *bool   `json:"listed"`
+	Tag    *string `json:"tag"`
+	Note   *string `json:"note"`
+	Limit  *int    `json:"limit"`
+}
+
+type Note struct {
+	ID        string    `json:"id"`
+	IPAddress string    `json:"ip_address"`
+	Body      string    `json:"body"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Stats struct {
+	Total         int             `json:"total"`
+	Listed        int             `json:"listed"`
+	Clean         int             `json:"clean"`
+	ByListing     []*ListingCount `json:"by_listing"`
+	LookupsPerDay []*DailyLookups `json:"lookups_per_day"`
+	TopNetworks   []*NetworkCount `json:"top_networks"`
+}
+
+type Tag struct {
+	Name      string    `json:"name"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type APIKeyScope string
+
+const (
+	APIKeyScopeRead    APIKeyScope = "READ"
+	APIKeyScopeEnqueue APIKeyScope = "ENQUEUE"
+	APIKeyScopeAdmin   APIKeyScope = "ADMIN"
+)
+
+var AllAPIKeyScope = []APIKeyScope{
+	APIKeyScopeRead,
+	APIKeyScopeEnqueue,
+	APIKeyScopeAdmin,
+}
+
+func (e APIKeyScope) IsValid() bool {
+	switch e {
+	case APIKeyScopeRead, APIKeyScopeEnqueue, APIKeyScopeAdmin:
+		return true
+	}
+	return false
+}
+
+func (e APIKeyScope) String() string {
+	return string(e)
+}
+
+func (e *APIKeyScope) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = APIKeyScope(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid APIKeyScope", str)
+	}
+	return nil
+}
+
+func (e APIKeyScope) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type AuditOperation string
+
+const (
+	AuditOperationEnqueue         AuditOperation = "ENQUEUE"
+	AuditOperationGetIPDetails    AuditOperation = "GET_IP_DETAILS"
+	AuditOperationCheck           AuditOperation = "CHECK"
+	AuditOperationDeleteIPDetails AuditOperation = "DELETE_IP_DETAILS"
+	AuditOperationCreateAPIKey    AuditOperation = "CREATE_API_KEY"
+	AuditOperationRevokeAPIKey    AuditOperation = "REVOKE_API_KEY"
+	AuditOperationBackupDatabase  AuditOperation = "BACKUP_DATABASE"
+	AuditOperationImportIPDetails AuditOperation = "IMPORT_IP_DETAILS"
+	AuditOperationTagIP           AuditOperation = "TAG_IP"
+	AuditOperationUntagIP         AuditOperation = "UNTAG_IP"
+	AuditOperationAddNote         AuditOperation = "ADD_NOTE"
+)
+
+var AllAuditOperation = []AuditOperation{
+	AuditOperationEnqueue,
+	AuditOperationGetIPDetails,
+	AuditOperationCheck,
+	AuditOperationDeleteIPDetails,
+	AuditOperationCreateAPIKey,
+	AuditOperationRevokeAPIKey,
+	AuditOperationBackupDatabase,
+	AuditOperationImportIPDetails,
+	AuditOperationTagIP,
+	AuditOperationUntagIP,
+	AuditOperationAddNote,
+}
+
+func (e AuditOperation) IsValid() bool {
+	switch e {
+	case AuditOperationEnqueue, AuditOperationGetIPDetails, AuditOperationCheck, AuditOperationDeleteIPDetails, AuditOperationCreateAPIKey, AuditOperationRevokeAPIKey, AuditOperationBackupDatabase, AuditOperationImportIPDetails, AuditOperationTagIP, AuditOperationUntagIP, AuditOperationAddNote:
+		return true
+	}
+	return false
+}
+
+func (e AuditOperation) String() string {
+	return string(e)
+}
+
+func (e *AuditOperation) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = AuditOperation(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid AuditOperation", str)
+	}
+	return nil
+}
+
+func (e AuditOperation) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ConflictPolicy string
+
+const (
+	ConflictPolicySkip      ConflictPolicy = "SKIP"
+	ConflictPolicyOverwrite ConflictPolicy = "OVERWRITE"
+	ConflictPolicyNewer     ConflictPolicy = "NEWER"
+)
+
+var AllConflictPolicy = []ConflictPolicy{
+	ConflictPolicySkip,
+	ConflictPolicyOverwrite,
+	ConflictPolicyNewer,
+}
+
+func (e ConflictPolicy) IsValid() bool {
+	switch e {
+	case ConflictPolicySkip, ConflictPolicyOverwrite, ConflictPolicyNewer:
+		return true
+	}
+	return false
+}
+
+func (e ConflictPolicy) String() string {
+	return string(e)
+}
+
+func (e *ConflictPolicy) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ConflictPolicy(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ConflictPolicy", str)
+	}
+	return nil
+}
+
+func (e ConflictPolicy) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type DataFormat string
+
+const (
+	DataFormatCsv       DataFormat = "CSV"
+	DataFormatJSONLines DataFormat = "JSON_LINES"
+)
+
+var AllDataFormat = []DataFormat{
+	DataFormatCsv,
+	DataFormatJSONLines,
+}
+
+func (e DataFormat) IsValid() bool {
+	switch e {
+	case DataFormatCsv, DataFormatJSONLines:
+		return true
+	}
+	return false
+}
+
+func (e DataFormat) String() string {
+	return string(e)
+}
+
+func (e *DataFormat) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = DataFormat(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid DataFormat", str)
+	}
+	return nil
+}
+
+func (e DataFormat) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type JobStatus string
+
+const (
+	JobStatusPending JobStatus = "PENDING"
+	JobStatusDone    JobStatus = "DONE"
+)
+
+var AllJobStatus = []JobStatus{
+	JobStatusPending,
+	JobStatusDone,
+}
+
+func (e JobStatus) IsValid() bool {
+	switch e {
+	case JobStatusPending, JobStatusDone:
+		return true
+	}
+	return false
+}
+
+func (e JobStatus) String() string {
+	return string(e)
+}
+
+func (e *JobStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = JobStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid JobStatus", str)
+	}
+	return nil
+}
+
+func (e JobStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type Role string
+
+const (
+	RoleReader    Role = "READER"
+	RoleSubmitter Role = "SUBMITTER"
+	RoleAdmin     Role = "ADMIN"
+)
+
+var AllRole = []Role{
+	RoleReader,
+	RoleSubmitter,
+	RoleAdmin,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleReader, RoleSubmitter, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
 }
@@ -1,27 +1,266 @@
 package graph
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
 	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/dnsbl"
 )
 
 // This file will not be regenerated automatically.
 //
 // It serves as dependency injection for your app, add any dependencies you require here.
 
+// Number of entries returned by the auditLog query by default, and at most.
+const (
+	defaultAuditLogLimit = 100
+	maxAuditLogLimit     = 1000
+)
+
+// Number of records returned by the ipDetailsInNetwork query by default, and at most.
+const (
+	defaultNetworkLimit = 100
+	maxNetworkLimit     = 1000
+)
+
+// Number of records returned by the exportIPDetails query by default, and at most.  The
+// export is held in memory, so larger ones should use the detect export command.
+const (
+	defaultExportLimit = 1000
+	maxExportLimit     = 10000
+)
+
+// Largest document the importIPDetails mutation accepts.  The data is held in memory, so
+// larger imports should use the detect import command.
+const maxImportBytes = 10 << 20
+
+// Longest tag and note analysts can attach to an address.
+const (
+	maxTagLength  = 64
+	maxNoteLength = 4096
+)
+
+// A DNSBL result stored by any tenant is reused for lookups this soon after it.
+const sharedResultMaxAge = time.Hour
+
+// Stored data belongs to a tenant, passed as the first argument after any context, and
+// is only visible to principals in that tenant.
+
 type IPDetailsAdder interface {
-	AddIPDetails(details model.IPDetails) error
+	AddIPDetails(ctx context.Context, tenant string, details model.IPDetails) error
 }
 
 type IPDetailsGetter interface {
-	GetIPDetails(addr string) (model.IPDetails, error)
+	GetIPDetails(ctx context.Context, tenant string, addr string) (model.IPDetails, error)
+}
+
+type IPDetailsDeleter interface {
+	DeleteIPDetails(ctx context.Context, tenant string, addrs []string) (int, error)
+}
+
+// NetworkSearcher finds a tenant's records for the addresses within a network.
+type NetworkSearcher interface {
+	// IPDetailsInNetwork returns the records matching filter in address order.
+	IPDetailsInNetwork(ctx context.Context, tenant string, network *net.IPNet, filter model.NetworkFilter) ([]*model.IPDetails, error)
+}
+
+// BulkDetails exports and imports a tenant's records in bulk.
+type BulkDetails interface {
+	// EachIPDetails calls fn with the records matching filter in address order, stopping
+	// if fn returns an error.
+	EachIPDetails(ctx context.Context, tenant string, filter model.ExportFilter, fn func(*model.IPDetails) error) error
+	// ImportIPDetails stores records in one transaction, resolving conflicts with
+	// existing records by policy.
+	ImportIPDetails(ctx context.Context, tenant string, records []model.IPDetails, policy model.ConflictPolicy) (model.ImportResult, error)
+}
+
+// Annotations stores analysts' tags and notes on a tenant's addresses.
+type Annotations interface {
+	// TagIP and UntagIP return the tags on the address afterwards.
+	TagIP(ctx context.Context, tenant string, addr string, tags []string, author string) ([]*model.Tag, error)
+	UntagIP(ctx context.Context, tenant string, addr string, tags []string) ([]*model.Tag, error)
+	AddNote(ctx context.Context, tenant string, note model.Note) (model.Note, error)
+	// Tags and Notes return the annotations on each of addrs, keyed by address, leaving
+	// out addresses without any.
+	Tags(ctx context.Context, tenant string, addrs []string) (map[string][]*model.Tag, error)
+	Notes(ctx context.Context, tenant string, addrs []string) (map[string][]*model.Note, error)
+}
+
+// SharedResults finds DNSBL results recently looked up by any tenant.
+type SharedResults interface {
+	SharedResult(ctx context.Context, addr string, since time.Time) (string, bool, error)
+}
+
+type StatsGetter interface {
+	GetStats(ctx context.Context, tenant string, since time.Time) (model.Stats, error)
 }
 
 type DNSBLClient interface {
-	Query(ip string) (string, error)
+	Query(ctx context.Context, ip string) (string, error)
+}
+
+type JobTracker interface {
+	Start(tenant string, ips []string) string
+	Finish(id string, err error)
+	Get(tenant string, id string) (model.Job, error)
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, tenant string, key model.APIKey, keyHash string) (model.APIKey, error)
+	ListAPIKeys(ctx context.Context, tenant string) ([]*model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, tenant string, id string) (model.APIKey, error)
+}
+
+type AuditLog interface {
+	AddAuditEntry(ctx context.Context, tenant string, entry model.AuditEntry) error
+	// EachAuditEntry calls fn with the entries matching filter, newest first, stopping
+	// if fn returns an error.
+	EachAuditEntry(ctx context.Context, tenant string, filter model.AuditLogFilter, fn func(*model.AuditEntry) error) error
+}
+
+// DatabaseBackups writes backups of the database.
+type DatabaseBackups interface {
+	Backup(ctx context.Context) (model.Backup, error)
+}
+
+type RateLimiter interface {
+	AllowQuery(ctx context.Context) error
+	AllowIPs(ctx context.Context, n int) error
 }
 
 type Resolver struct {
-	Adder  IPDetailsAdder
-	Getter IPDetailsGetter
-	DNSBL  DNSBLClient
+	Adder       IPDetailsAdder
+	Getter      IPDetailsGetter
+	Deleter     IPDetailsDeleter
+	Networks    NetworkSearcher
+	Bulk        BulkDetails
+	Shared      SharedResults
+	Stats       StatsGetter
+	DNSBL       DNSBLClient
+	Jobs        JobTracker
+	APIKeys     APIKeyStore
+	Limits      RateLimiter
+	Audit       AuditLog
+	Backups     DatabaseBackups
+	Annotations Annotations
+}
+
+// audit records that the principal in ctx is performing op with args.  Operations must
+// not go ahead if they couldn't be recorded.
+func (r *Resolver) audit(ctx context.Context, op model.AuditOperation, args map[string]interface{}) error {
+	if r.Audit == nil {
+		return nil
+	}
+
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("error encoding audit arguments: %w", err)
+	}
+
+	p, _ := auth.PrincipalFromContext(ctx)
+	var keyID *string
+	if p.KeyID != "" {
+		keyID = &p.KeyID
+	}
+	err = r.Audit.AddAuditEntry(ctx, auth.TenantFromContext(ctx), model.AuditEntry{
+		Principal: p.Name,
+		APIKeyID:  keyID,
+		ClientIP:  auth.ClientIPFromContext(ctx),
+		Operation: op,
+		Arguments: string(encoded),
+	})
+	if err != nil {
+		return fmt.Errorf("error recording audit entry: %w", err)
+	}
+	return nil
+}
+
+// validateTags checks tags given to tagIP or untagIP.
+func validateTags(tags []string) error {
+	if len(tags) == 0 {
+		return InputError("at least one tag is required")
+	}
+	for _, tag := range tags {
+		if tag == "" || len(tag) > maxTagLength || strings.TrimSpace(tag) != tag {
+			return InputError(fmt.Sprintf("tags must be 1 to %d characters, without surrounding spaces", maxTagLength))
+		}
+	}
+	return nil
+}
+
+// AllowQuery counts one query against the rate limits of the principal in ctx.
+func (r *Resolver) AllowQuery(ctx context.Context) error {
+	if r.Limits == nil {
+		return nil
+	}
+	return r.Limits.AllowQuery(ctx)
+}
+
+// AllowIPs counts n enqueued addresses against the rate limits of the principal in ctx.
+func (r *Resolver) AllowIPs(ctx context.Context, n int) error {
+	if r.Limits == nil {
+		return nil
+	}
+	return r.Limits.AllowIPs(ctx, n)
+}
+
+// lookup queries the DNSBL for address and stores the result for tenant.  A recent
+// result stored by any tenant is reused instead of querying the DNSBL again.
+func (r *Resolver) lookup(ctx context.Context, tenant string, address string) error {
+	res, ok, err := r.sharedResult(ctx, address)
+	if err != nil {
+		log.Printf("error checking for a shared result for %s: %s", address, err.Error())
+	}
+	if !ok {
+		res, err = r.DNSBL.Query(ctx, address)
+		if err != nil {
+			return fmt.Errorf("error querying DNSBL: %w", err)
+		}
+	}
+
+	err = r.Adder.AddIPDetails(ctx, tenant, model.IPDetails{
+		UUID:         "",
+		CreatedAt:    time.Time{},
+		UpdatedAt:    time.Time{},
+		ResponseCode: res,
+		IPAddress:    address,
+	})
+	if err != nil {
+		return fmt.Errorf("error adding ip details: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Resolver) sharedResult(ctx context.Context, address string) (string, bool, error) {
+	if r.Shared == nil {
+		return "", false, nil
+	}
+	return r.Shared.SharedResult(ctx, address, time.Now().Add(-sharedResultMaxAge))
+}
+
+// Check looks address up immediately, rather than in the background, and returns the
+// stored result.
+func (r *Resolver) Check(ctx context.Context, address string) (model.IPDetails, error) {
+	if err := dnsbl.ValidateIPv4(address); err != nil {
+		return model.IPDetails{}, err
+	}
+
+	if err := r.audit(ctx, model.AuditOperationCheck, map[string]interface{}{"ip": []string{address}}); err != nil {
+		return model.IPDetails{}, err
+	}
+
+	tenant := auth.TenantFromContext(ctx)
+	if err := r.lookup(ctx, tenant, address); err != nil {
+		return model.IPDetails{}, err
+	}
+
+	return r.Getter.GetIPDetails(ctx, tenant, address)
 }
@@ -5,44 +5,239 @@ package graph
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jdharms/threat-detect/graph/generated"
 	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/bulk"
+	"github.com/jdharms/threat-detect/internal/dnsbl"
 )
 
+func (r *iPDetailsResolver) Tags(ctx context.Context, obj *model.IPDetails) ([]*model.Tag, error) {
+	return annotationLoaderFromContext(ctx, r.Annotations).Tags(ctx, obj.IPAddress)
+}
+
+func (r *iPDetailsResolver) Notes(ctx context.Context, obj *model.IPDetails) ([]*model.Note, error) {
+	return annotationLoaderFromContext(ctx, r.Annotations).Notes(ctx, obj.IPAddress)
+}
+
 func (r *mutationResolver) Enqueue(ctx context.Context, ip []string) (*model.EnqueuePayload, error) {
+	for _, addr := range ip {
+		if err := dnsbl.ValidateIPv4(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.AllowIPs(ctx, len(ip)); err != nil {
+		return nil, err
+	}
+
+	if err := r.audit(ctx, model.AuditOperationEnqueue, map[string]interface{}{"ip": ip}); err != nil {
+		return nil, err
+	}
+
+	tenant := auth.TenantFromContext(ctx)
+	jobID := r.Jobs.Start(tenant, ip)
+
+	// The lookups outlive the request, so they mustn't be cancelled along with it.
+	lookupCtx := context.Background()
 	queued := []string{}
 	for _, addr := range ip {
 		queued = append(queued, addr)
 		go func(address string) {
-			res, err := r.DNSBL.Query(address)
-			if err != nil {
-				log.Printf("error querying DNSBL: %s", err.Error())
-				return
-			}
-
-			err = r.Adder.AddIPDetails(model.IPDetails{
-				UUID:         "",
-				CreatedAt:    time.Time{},
-				UpdatedAt:    time.Time{},
-				ResponseCode: res,
-				IPAddress:    address,
-			})
+			err := r.lookup(lookupCtx, tenant, address)
 			if err != nil {
-				log.Printf("error adding ip details: %s", err.Error())
-				return
+				log.Printf("error looking up %s: %s", address, err.Error())
 			}
+			r.Jobs.Finish(jobID, err)
 		}(addr)
 	}
 	return &model.EnqueuePayload{
+		JobID:     jobID,
 		QueuedIps: queued,
 	}, nil
 }
 
+func (r *mutationResolver) DeleteIPDetails(ctx context.Context, ip []string) (int, error) {
+	for _, address := range ip {
+		if err := dnsbl.ValidateIPv4(address); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := r.audit(ctx, model.AuditOperationDeleteIPDetails, map[string]interface{}{"ip": ip}); err != nil {
+		return 0, err
+	}
+
+	return r.Deleter.DeleteIPDetails(ctx, auth.TenantFromContext(ctx), ip)
+}
+
+func (r *mutationResolver) CreateAPIKey(ctx context.Context, input model.CreateAPIKeyInput) (*model.CreateAPIKeyPayload, error) {
+	err := r.audit(ctx, model.AuditOperationCreateAPIKey, map[string]interface{}{
+		"name":       input.Name,
+		"scopes":     input.Scopes,
+		"expires_at": input.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// the key acts as the admin creating it, so it can't be used to act as anyone else
+	p, _ := auth.PrincipalFromContext(ctx)
+	stored, err := r.APIKeys.CreateAPIKey(ctx, auth.TenantFromContext(ctx), model.APIKey{
+		Name:      input.Name,
+		Owner:     p.Name,
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+	}, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.CreateAPIKeyPayload{
+		Key:    key,
+		APIKey: &stored,
+	}, nil
+}
+
+func (r *mutationResolver) RevokeAPIKey(ctx context.Context, id string) (*model.APIKey, error) {
+	if err := r.audit(ctx, model.AuditOperationRevokeAPIKey, map[string]interface{}{"id": id}); err != nil {
+		return nil, err
+	}
+
+	k, err := r.APIKeys.RevokeAPIKey(ctx, auth.TenantFromContext(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+func (r *mutationResolver) BackupDatabase(ctx context.Context) (*model.Backup, error) {
+	if r.Backups == nil {
+		return nil, InputError("backups are not configured")
+	}
+	if err := r.audit(ctx, model.AuditOperationBackupDatabase, map[string]interface{}{}); err != nil {
+		return nil, err
+	}
+
+	b, err := r.Backups.Backup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (r *mutationResolver) ImportIPDetails(ctx context.Context, format model.DataFormat, data string, conflict *model.ConflictPolicy) (*model.ImportResult, error) {
+	if len(data) > maxImportBytes {
+		return nil, InputError(fmt.Sprintf("data must be at most %d bytes; use the detect import command for larger imports", maxImportBytes))
+	}
+	policy := model.ConflictPolicySkip
+	if conflict != nil {
+		policy = *conflict
+	}
+
+	// the data itself may be large, so only its size is recorded
+	err := r.audit(ctx, model.AuditOperationImportIPDetails, map[string]interface{}{
+		"format":   format,
+		"conflict": policy,
+		"bytes":    len(data),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := bulk.Import(ctx, r.Bulk, auth.TenantFromContext(ctx), strings.NewReader(data), format, policy, bulk.DefaultBatchSize)
+	if err != nil {
+		// The batches before a bad record are kept, so say how much of the data was read.
+		var badRecord *bulk.RecordError
+		if stored := res.Imported + res.Skipped; errors.As(err, &badRecord) && stored > 0 {
+			return nil, InputError(fmt.Sprintf("%s (the %d records before it were stored)", err.Error(), stored))
+		}
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (r *mutationResolver) TagIP(ctx context.Context, ip string, tags []string) ([]*model.Tag, error) {
+	if err := dnsbl.ValidateIPv4(ip); err != nil {
+		return nil, err
+	}
+	if err := validateTags(tags); err != nil {
+		return nil, err
+	}
+
+	if err := r.audit(ctx, model.AuditOperationTagIP, map[string]interface{}{"ip": []string{ip}, "tags": tags}); err != nil {
+		return nil, err
+	}
+
+	p, _ := auth.PrincipalFromContext(ctx)
+	return r.Annotations.TagIP(ctx, auth.TenantFromContext(ctx), ip, tags, p.Name)
+}
+
+func (r *mutationResolver) UntagIP(ctx context.Context, ip string, tags []string) ([]*model.Tag, error) {
+	if err := dnsbl.ValidateIPv4(ip); err != nil {
+		return nil, err
+	}
+	if err := validateTags(tags); err != nil {
+		return nil, err
+	}
+
+	if err := r.audit(ctx, model.AuditOperationUntagIP, map[string]interface{}{"ip": []string{ip}, "tags": tags}); err != nil {
+		return nil, err
+	}
+
+	return r.Annotations.UntagIP(ctx, auth.TenantFromContext(ctx), ip, tags)
+}
+
+func (r *mutationResolver) AddNote(ctx context.Context, ip string, body string) (*model.Note, error) {
+	if err := dnsbl.ValidateIPv4(ip); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(body) == "" || len(body) > maxNoteLength {
+		return nil, InputError(fmt.Sprintf("notes must be 1 to %d characters", maxNoteLength))
+	}
+	if err := r.audit(ctx, model.AuditOperationAddNote, map[string]interface{}{"ip": []string{ip}, "body": body}); err != nil {
+		return nil, err
+	}
+
+	p, _ := auth.PrincipalFromContext(ctx)
+	n, err := r.Annotations.AddNote(ctx, auth.TenantFromContext(ctx), model.Note{
+		IPAddress: ip,
+		Body:      body,
+		Author:    p.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
 func (r *queryResolver) GetIPDetails(ctx context.Context, ip string) (*model.IPDetails, error) {
-	d, err := r.Getter.GetIPDetails(ip)
+	if err := dnsbl.ValidateIPv4(ip); err != nil {
+		return nil, err
+	}
+
+	if err := r.audit(ctx, model.AuditOperationGetIPDetails, map[string]interface{}{"ip": []string{ip}}); err != nil {
+		return nil, err
+	}
+
+	d, err := r.Getter.GetIPDetails(ctx, auth.TenantFromContext(ctx), ip)
 	if err != nil {
 		return nil, err
 	}
@@ -50,11 +245,135 @@ func (r *queryResolver) GetIPDetails(ctx context.Context, ip string) (*model.IPD
 	return &d, nil
 }
 
+func (r *queryResolver) IPDetailsInNetwork(ctx context.Context, cidr string, filter *model.NetworkFilter) ([]*model.IPDetails, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, InputError(fmt.Sprintf("%s is not a CIDR network", cidr))
+	}
+
+	f := model.NetworkFilter{}
+	if filter != nil {
+		f = *filter
+	}
+
+	limit := defaultNetworkLimit
+	if f.Limit != nil {
+		if *f.Limit < 1 || *f.Limit > maxNetworkLimit {
+			return nil, InputError(fmt.Sprintf("limit must be between 1 and %d", maxNetworkLimit))
+		}
+		limit = *f.Limit
+	}
+	f.Limit = &limit
+
+	return r.Networks.IPDetailsInNetwork(ctx, auth.TenantFromContext(ctx), network, f)
+}
+
+func (r *queryResolver) Stats(ctx context.Context, since *time.Time) (*model.Stats, error) {
+	var from time.Time
+	if since != nil {
+		from = *since
+	}
+
+	s, err := r.Resolver.Stats.GetStats(ctx, auth.TenantFromContext(ctx), from)
+	if err != nil {
+		return nil, err
+	}
+
+	// Several response codes can decode to the same list (e.g. XBL), so merge them.
+	byListing := []*model.ListingCount{}
+	index := map[string]*model.ListingCount{}
+	for _, lc := range s.ByListing {
+		name := dnsbl.ListingName(lc.Listing)
+		if existing, ok := index[name]; ok {
+			existing.Count += lc.Count
+			continue
+		}
+		merged := &model.ListingCount{Listing: name, Count: lc.Count}
+		index[name] = merged
+		byListing = append(byListing, merged)
+	}
+	sort.SliceStable(byListing, func(i, j int) bool { return byListing[i].Count > byListing[j].Count })
+	s.ByListing = byListing
+
+	return &s, nil
+}
+
+func (r *queryResolver) Job(ctx context.Context, id string) (*model.Job, error) {
+	j, err := r.Jobs.Get(auth.TenantFromContext(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &j, nil
+}
+
+func (r *queryResolver) APIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	return r.Resolver.APIKeys.ListAPIKeys(ctx, auth.TenantFromContext(ctx))
+}
+
+func (r *queryResolver) AuditLog(ctx context.Context, filter *model.AuditLogFilter) ([]*model.AuditEntry, error) {
+	f := model.AuditLogFilter{}
+	if filter != nil {
+		f = *filter
+	}
+
+	limit := defaultAuditLogLimit
+	if f.Limit != nil {
+		if *f.Limit < 1 || *f.Limit > maxAuditLogLimit {
+			return nil, InputError(fmt.Sprintf("limit must be between 1 and %d", maxAuditLogLimit))
+		}
+		limit = *f.Limit
+	}
+	f.Limit = &limit
+
+	entries := []*model.AuditEntry{}
+	err := r.Audit.EachAuditEntry(ctx, auth.TenantFromContext(ctx), f, func(e *model.AuditEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (r *queryResolver) ExportIPDetails(ctx context.Context, format model.DataFormat, filter *model.ExportFilter) (string, error) {
+	f := model.ExportFilter{}
+	if filter != nil {
+		f = *filter
+	}
+
+	if f.Cidr != nil {
+		if _, _, err := net.ParseCIDR(*f.Cidr); err != nil {
+			return "", InputError(fmt.Sprintf("%s is not a CIDR network", *f.Cidr))
+		}
+	}
+
+	limit := defaultExportLimit
+	if f.Limit != nil {
+		if *f.Limit < 1 || *f.Limit > maxExportLimit {
+			return "", InputError(fmt.Sprintf("limit must be between 1 and %d", maxExportLimit))
+		}
+		limit = *f.Limit
+	}
+	f.Limit = &limit
+
+	var b strings.Builder
+	if _, err := bulk.Export(ctx, &b, r.Bulk, auth.TenantFromContext(ctx), format, f); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// IPDetails returns generated.IPDetailsResolver implementation.
+func (r *Resolver) IPDetails() generated.IPDetailsResolver { return &iPDetailsResolver{r} }
+
 // Mutation returns generated.MutationResolver implementation.
 func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
 
 // Query returns generated.QueryResolver implementation.
 func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
 
+type iPDetailsResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
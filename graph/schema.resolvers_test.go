@@ -2,19 +2,24 @@ package graph
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/db"
+	"github.com/jdharms/threat-detect/internal/jobs"
 )
 
 type queryChecker struct {
 	wg *sync.WaitGroup
 }
 
-func (qc queryChecker) Query(ip string) (string, error) {
+func (qc queryChecker) Query(ctx context.Context, ip string) (string, error) {
 	qc.wg.Done()
 	return "foo", nil
 }
@@ -24,7 +29,7 @@ type adderChecker struct {
 	wg         *sync.WaitGroup
 }
 
-func (d *adderChecker) AddIPDetails(m model.IPDetails) error {
+func (d *adderChecker) AddIPDetails(ctx context.Context, tenant string, m model.IPDetails) error {
 	d.repository <- m
 	d.wg.Done()
 	return nil
@@ -43,6 +48,7 @@ func TestEnqueue(t *testing.T) {
 	sut := Resolver{
 		Adder: &ac,
 		DNSBL: &queryChecker{wg: &queryWg},
+		Jobs:  jobs.NewTracker(),
 	}
 
 	ctx := context.Background()
@@ -55,6 +61,9 @@ func TestEnqueue(t *testing.T) {
 	if err != nil {
 		t.Errorf("error calling Enqueue(): %s", err.Error())
 	}
+	if res.JobID == "" {
+		t.Error("expected Enqueue to return a job id")
+	}
 
 	queryWg.Wait()
 	adderWg.Wait()
@@ -68,7 +77,7 @@ type mockGetter struct {
 	getFunc func(string) (model.IPDetails, error)
 }
 
-func (mg mockGetter) GetIPDetails(ip string) (model.IPDetails, error) {
+func (mg mockGetter) GetIPDetails(ctx context.Context, tenant string, ip string) (model.IPDetails, error) {
 	return mg.getFunc(ip)
 }
 
@@ -118,3 +127,352 @@ func TestGetIPDetailsReturnsError(t *testing.T) {
 		t.Error("expected an error result from GetIPDetails")
 	}
 }
+
+type mockStats struct {
+	stats model.Stats
+	since time.Time
+}
+
+func (ms *mockStats) GetStats(ctx context.Context, tenant string, since time.Time) (model.Stats, error) {
+	ms.since = since
+	return ms.stats, nil
+}
+
+func TestStatsDecodesListings(t *testing.T) {
+	ms := &mockStats{stats: model.Stats{
+		Total:  10,
+		Listed: 4,
+		Clean:  6,
+		ByListing: []*model.ListingCount{
+			{Listing: "127.0.0.2", Count: 1},
+			{Listing: "127.0.0.4", Count: 2},
+			{Listing: "127.0.0.5", Count: 2},
+		},
+	}}
+
+	sut := Resolver{Stats: ms}
+
+	since := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	res, err := sut.Query().Stats(context.Background(), &since)
+	if err != nil {
+		t.Fatalf("Stats returned unexpected error: %s", err.Error())
+	}
+	if !ms.since.Equal(since) {
+		t.Errorf("expected since %s to be passed through, got %s", since, ms.since)
+	}
+	if len(res.ByListing) != 2 {
+		t.Fatalf("expected 2 decoded listings, found %d", len(res.ByListing))
+	}
+	if res.ByListing[0].Listing != "XBL" || res.ByListing[0].Count != 4 {
+		t.Errorf("expected XBL codes to be merged, got %s: %d", res.ByListing[0].Listing, res.ByListing[0].Count)
+	}
+	if res.ByListing[1].Listing != "SBL" || res.ByListing[1].Count != 1 {
+		t.Errorf("expected SBL listing, got %s: %d", res.ByListing[1].Listing, res.ByListing[1].Count)
+	}
+}
+
+func TestJob(t *testing.T) {
+	tracker := jobs.NewTracker()
+	id := tracker.Start(auth.DefaultTenant, []string{"1.2.3.4"})
+
+	sut := Resolver{Jobs: tracker}
+
+	ctx := context.Background()
+	res, err := sut.Query().Job(ctx, id)
+	if err != nil {
+		t.Fatalf("Job returned unexpected error: %s", err.Error())
+	}
+	if res.ID != id || res.Status != model.JobStatusPending {
+		t.Errorf("unexpected job returned: %+v", res)
+	}
+
+	_, err = sut.Query().Job(ctx, "missing")
+	if ErrorCode(err) != CodeNotFound {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	other := auth.ContextWithPrincipal(ctx, auth.Principal{Name: "mallory", Tenant: "other"})
+	_, err = sut.Query().Job(other, id)
+	if ErrorCode(err) != CodeNotFound {
+		t.Errorf("expected another tenant's job not to be found, got %v", err)
+	}
+}
+
+type mockShared struct {
+	code string
+	ok   bool
+}
+
+func (ms mockShared) SharedResult(ctx context.Context, addr string, since time.Time) (string, bool, error) {
+	return ms.code, ms.ok, nil
+}
+
+type countingDNSBL struct {
+	queries int
+}
+
+func (c *countingDNSBL) Query(ctx context.Context, ip string) (string, error) {
+	c.queries++
+	return "127.0.0.2", nil
+}
+
+func TestCheckIsScopedToTenant(t *testing.T) {
+	testCases := []struct {
+		name            string
+		shared          mockShared
+		expectedCode    string
+		expectedQueries int
+	}{
+		{"no shared result", mockShared{}, "127.0.0.2", 1},
+		{"shared result reused", mockShared{code: "127.0.0.4", ok: true}, "127.0.0.4", 0},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			store := db.NewMemoryStore()
+			dnsbl := &countingDNSBL{}
+			sut := Resolver{Adder: store, Getter: store, Shared: test.shared, DNSBL: dnsbl}
+
+			red := auth.ContextWithPrincipal(context.Background(), auth.Principal{Name: "alice", Tenant: "red"})
+			res, err := sut.Check(red, "1.2.3.4")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if res.ResponseCode != test.expectedCode || dnsbl.queries != test.expectedQueries {
+				t.Errorf("expected %s after %d queries, got %s after %d", test.expectedCode, test.expectedQueries, res.ResponseCode, dnsbl.queries)
+			}
+
+			if _, err := store.GetIPDetails(context.Background(), "red", "1.2.3.4"); err != nil {
+				t.Errorf("expected the result to be stored for the red tenant, got %s", err.Error())
+			}
+
+			blue := auth.ContextWithPrincipal(context.Background(), auth.Principal{Name: "bob", Tenant: "blue"})
+			if _, err := sut.Query().GetIPDetails(blue, "1.2.3.4"); err == nil {
+				t.Error("expected another tenant's result not to be visible")
+			}
+		})
+	}
+}
+
+func TestIPDetailsInNetwork(t *testing.T) {
+	store := db.NewMemoryStore()
+	for _, addr := range []string{"203.0.113.1", "203.0.113.2", "198.51.100.1"} {
+		if err := store.AddIPDetails(context.Background(), "red", model.IPDetails{IPAddress: addr}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	sut := Resolver{Networks: store}
+	red := auth.ContextWithPrincipal(context.Background(), auth.Principal{Name: "alice", Tenant: "red"})
+
+	zero, tooMany, one := 0, maxNetworkLimit+1, 1
+	testCases := []struct {
+		name     string
+		cidr     string
+		filter   *model.NetworkFilter
+		expected int
+		errKey   string
+	}{
+		{"no filter", "203.0.113.0/24", nil, 2, ""},
+		{"limit", "203.0.113.0/24", &model.NetworkFilter{Limit: &one}, 1, ""},
+		{"not a network", "203.0.113.1", nil, 0, "not a CIDR network"},
+		{"limit too small", "203.0.113.0/24", &model.NetworkFilter{Limit: &zero}, 0, "limit must be"},
+		{"limit too large", "203.0.113.0/24", &model.NetworkFilter{Limit: &tooMany}, 0, "limit must be"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := sut.Query().IPDetailsInNetwork(red, test.cidr, test.filter)
+			if test.errKey != "" {
+				var invalid InputError
+				if !errors.As(err, &invalid) || !strings.Contains(err.Error(), test.errKey) {
+					t.Errorf("expected an input error containing %q, got %v", test.errKey, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if len(res) != test.expected {
+				t.Errorf("expected %d records, got %d", test.expected, len(res))
+			}
+		})
+	}
+}
+
+func TestExportIPDetails(t *testing.T) {
+	store := db.NewMemoryStore()
+	for _, addr := range []string{"203.0.113.1", "198.51.100.1"} {
+		if err := store.AddIPDetails(context.Background(), "red", model.IPDetails{IPAddress: addr}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	sut := Resolver{Bulk: store}
+	red := auth.ContextWithPrincipal(context.Background(), auth.Principal{Name: "alice", Tenant: "red"})
+
+	zero, tooMany, bogus, network := 0, maxExportLimit+1, "203.0.113.1", "203.0.113.0/24"
+	testCases := []struct {
+		name     string
+		format   model.DataFormat
+		filter   *model.ExportFilter
+		expected []string
+		errKey   string
+	}{
+		{"csv", model.DataFormatCsv, nil, []string{"ip_address,", "198.51.100.1,", "203.0.113.1,"}, ""},
+		{"json lines", model.DataFormatJSONLines, nil, []string{`{"uuid":`, `{"uuid":`}, ""},
+		{"network", model.DataFormatCsv, &model.ExportFilter{Cidr: &network}, []string{"ip_address,", "203.0.113.1,"}, ""},
+		{"not a network", model.DataFormatCsv, &model.ExportFilter{Cidr: &bogus}, nil, "not a CIDR network"},
+		{"limit too small", model.DataFormatCsv, &model.ExportFilter{Limit: &zero}, nil, "limit must be"},
+		{"limit too large", model.DataFormatCsv, &model.ExportFilter{Limit: &tooMany}, nil, "limit must be"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := sut.Query().ExportIPDetails(red, test.format, test.filter)
+			if test.errKey != "" {
+				var invalid InputError
+				if !errors.As(err, &invalid) || !strings.Contains(err.Error(), test.errKey) {
+					t.Errorf("expected an input error containing %q, got %v", test.errKey, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			lines := strings.Split(strings.TrimSpace(res), "\n")
+			if len(lines) != len(test.expected) {
+				t.Fatalf("expected %d lines, got %q", len(test.expected), lines)
+			}
+			for i, prefix := range test.expected {
+				if !strings.HasPrefix(lines[i], prefix) {
+					t.Errorf("expected line %d to start with %q, got %q", i, prefix, lines[i])
+				}
+			}
+		})
+	}
+}
+
+func TestImportIPDetails(t *testing.T) {
+	store := db.NewMemoryStore()
+	if err := store.AddIPDetails(context.Background(), "red", model.IPDetails{IPAddress: "203.0.113.1"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	sut := Resolver{Bulk: store}
+	red := auth.ContextWithPrincipal(context.Background(), auth.Principal{Name: "alice", Tenant: "red"})
+
+	overwrite := model.ConflictPolicyOverwrite
+	testCases := []struct {
+		name     string
+		data     string
+		conflict *model.ConflictPolicy
+		expected model.ImportResult
+		errKey   string
+	}{
+		{"skips existing records by default", "ip_address,response_code\n203.0.113.1,127.0.0.2\n203.0.113.2,\n", nil, model.ImportResult{Imported: 1, Skipped: 1}, ""},
+		{"overwrite", "ip_address,response_code\n203.0.113.1,127.0.0.2\n", &overwrite, model.ImportResult{Imported: 1}, ""},
+		{"invalid record", "ip_address\n203.0.113.3\nbogus\n", nil, model.ImportResult{}, "record 2: bogus is not a valid IPv4 address"},
+		{"no header", "", nil, model.ImportResult{}, "no header"},
+		{"invalid response code", "ip_address,response_code\n203.0.113.3,clean\n", nil, model.ImportResult{}, "record 1: invalid response code"},
+		{"future time", "ip_address,updated_at\n203.0.113.3,2999-01-01T00:00:00Z\n", nil, model.ImportResult{}, "in the future"},
+		{"too large", "ip_address\n" + strings.Repeat("203.0.113.3\n", maxImportBytes/12+1), nil, model.ImportResult{}, "use the detect import command"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := sut.Mutation().ImportIPDetails(red, model.DataFormatCsv, test.data, test.conflict)
+			if test.errKey != "" {
+				if ErrorCode(err) != CodeInvalidInput || !strings.Contains(err.Error(), test.errKey) {
+					t.Errorf("expected an input error containing %q, got %v", test.errKey, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if *res != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, *res)
+			}
+		})
+	}
+
+	d, err := store.GetIPDetails(context.Background(), "red", "203.0.113.1")
+	if err != nil || d.ResponseCode != "127.0.0.2" {
+		t.Errorf("expected the overwritten result, got %+v and %v", d, err)
+	}
+}
+
+func TestAnnotationValidation(t *testing.T) {
+	sut := Resolver{Annotations: db.NewMemoryStore()}
+	red := auth.ContextWithPrincipal(context.Background(), auth.Principal{Name: "alice", Tenant: "red"})
+	long := strings.Repeat("x", maxTagLength+1)
+
+	testCases := []struct {
+		name   string
+		run    func() error
+		errKey string
+	}{
+		{"tag", func() error { _, err := sut.Mutation().TagIP(red, "1.2.3.4", []string{"relay"}); return err }, ""},
+		{"tag invalid address", func() error { _, err := sut.Mutation().TagIP(red, "1.2.3", []string{"relay"}); return err }, "not a valid IPv4 address"},
+		{"no tags", func() error { _, err := sut.Mutation().TagIP(red, "1.2.3.4", nil); return err }, "at least one tag"},
+		{"empty tag", func() error { _, err := sut.Mutation().TagIP(red, "1.2.3.4", []string{""}); return err }, "tags must be"},
+		{"padded tag", func() error { _, err := sut.Mutation().UntagIP(red, "1.2.3.4", []string{" relay"}); return err }, "tags must be"},
+		{"long tag", func() error { _, err := sut.Mutation().TagIP(red, "1.2.3.4", []string{long}); return err }, "tags must be"},
+		{"note", func() error { _, err := sut.Mutation().AddNote(red, "1.2.3.4", "known customer relay"); return err }, ""},
+		{"blank note", func() error { _, err := sut.Mutation().AddNote(red, "1.2.3.4", "  "); return err }, "notes must be"},
+		{"long note", func() error {
+			_, err := sut.Mutation().AddNote(red, "1.2.3.4", strings.Repeat("x", maxNoteLength+1))
+			return err
+		}, "notes must be"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.run()
+			if test.errKey == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err.Error())
+				}
+				return
+			}
+			if ErrorCode(err) != CodeInvalidInput || !strings.Contains(err.Error(), test.errKey) {
+				t.Errorf("expected an input error containing %q, got %v", test.errKey, err)
+			}
+		})
+	}
+}
+
+type mockBackups struct {
+	err error
+}
+
+func (mb mockBackups) Backup(ctx context.Context) (model.Backup, error) {
+	return model.Backup{Path: "/backups/detect.db"}, mb.err
+}
+
+func TestBackupDatabase(t *testing.T) {
+	testCases := []struct {
+		name    string
+		backups DatabaseBackups
+		code    string
+	}{
+		{"written", mockBackups{}, ""},
+		{"not configured", nil, CodeInvalidInput},
+		{"failed", mockBackups{err: fmt.Errorf("disk full")}, CodeInternal},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			sut := Resolver{Backups: test.backups}
+			b, err := sut.Mutation().BackupDatabase(context.Background())
+			if test.code == "" {
+				if err != nil || b.Path != "/backups/detect.db" {
+					t.Errorf("expected the backup to be returned, got %+v, %v", b, err)
+				}
+				return
+			}
+			if err == nil || ErrorCode(err) != test.code {
+				t.Errorf("expected an error with code %s, got %v", test.code, err)
+			}
+		})
+	}
+}
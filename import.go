@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/bulk"
+)
+
+const importUsage = `usage: detect import [-format csv|jsonl] [-tenant name] [-conflict policy] [file]
+
+Store results read from a file, or standard input, in the format "detect export" writes.
+Only the ip_address column or field is required.  The results are stored in batches,
+each in its own transaction; if a result can't be read, the batches before it are kept,
+and importing the corrected file again with -conflict skip stores only the rest.
+
+`
+
+// runImport implements the "import" subcommand.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), importUsage)
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "csv", "\"csv\" or \"jsonl\" (JSON lines)")
+	tenant := fs.String("tenant", auth.DefaultTenant, "tenant to store the results for")
+	conflict := fs.String("conflict", "skip", "for addresses already stored, \"skip\" the result, \"overwrite\" the stored one, or keep the \"newer\" of the two")
+	batchSize := fs.Int("batch-size", bulk.DefaultBatchSize, "number of results stored in each transaction")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("expected at most one file to import")
+	}
+
+	f, err := parseFormat(*format)
+	if err != nil {
+		return err
+	}
+	policy := model.ConflictPolicy(strings.ToUpper(*conflict))
+	if !policy.IsValid() {
+		return fmt.Errorf("-conflict: expected skip, overwrite or newer, got %q", *conflict)
+	}
+	if *batchSize < 1 {
+		return fmt.Errorf("-batch-size must be at least 1")
+	}
+
+	var r io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	cfg := envConfig()
+	if err := requireStoredDatabase(cfg.Database); err != nil {
+		return err
+	}
+	client, err := openDatabase(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer client.Close()
+
+	res, err := bulk.Import(context.Background(), client, *tenant, r, f, policy, *batchSize)
+	fmt.Fprintf(os.Stderr, "imported %d results and skipped %d\n", res.Imported, res.Skipped)
+	return err
+}
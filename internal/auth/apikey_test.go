@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %s", err.Error())
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		t.Errorf("expected key to start with %s, got %s", apiKeyPrefix, key)
+	}
+	if hash != HashAPIKey(key) || strings.Contains(hash, key) {
+		t.Error("expected returned hash to be the hash of the key")
+	}
+
+	other, _, _ := GenerateAPIKey()
+	if other == key {
+		t.Error("expected generated keys to be unique")
+	}
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	records := map[string]APIKeyRecord{
+		HashAPIKey("tdk_valid"):   {ID: "key-1", Name: "valid", Owner: "svc", Scopes: []string{ScopeRead}, ExpiresAt: &future},
+		HashAPIKey("tdk_expired"): {Name: "expired", Owner: "svc", ExpiresAt: &past},
+		HashAPIKey("tdk_revoked"): {Name: "revoked", Owner: "svc", RevokedAt: &past},
+	}
+	sut := APIKeyAuthenticator(func(ctx context.Context, hash string) (APIKeyRecord, error) {
+		record, ok := records[hash]
+		if !ok {
+			return record, fmt.Errorf("not found")
+		}
+		return record, nil
+	}, StaticRoles(RoleReader))
+
+	testCases := []struct {
+		name        string
+		header      string
+		value       string
+		expectedErr error
+	}{
+		{"header", "X-API-Key", "tdk_valid", nil},
+		{"bearer", "Authorization", "Bearer tdk_valid", nil},
+		{"expired", "X-API-Key", "tdk_expired", ErrUnauthenticated},
+		{"revoked", "X-API-Key", "tdk_revoked", ErrUnauthenticated},
+		{"unknown", "X-API-Key", "tdk_unknown", ErrUnauthenticated},
+		{"other bearer token", "Authorization", "Bearer eyJhbGciOi", ErrNoCredentials},
+		{"no key", "", "", ErrNoCredentials},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://testing.com", nil)
+			if test.header != "" {
+				req.Header.Set(test.header, test.value)
+			}
+
+			p, err := sut(req)
+			if err != test.expectedErr {
+				t.Errorf("expected error %v but got %v", test.expectedErr, err)
+			}
+			if err == nil && (p.Name != "svc" || p.KeyID != "key-1" || !p.HasRole(RoleReader) || p.HasRole(RoleAdmin)) {
+				t.Errorf("unexpected principal: %+v", p)
+			}
+		})
+	}
+}
+
+func TestAPIKeyFollowsOwnerRoles(t *testing.T) {
+	owners := map[string][]string{"alice": {RoleAdmin, RoleSubmitter, RoleReader}}
+	sut := APIKeyAuthenticator(func(ctx context.Context, hash string) (APIKeyRecord, error) {
+		return APIKeyRecord{ID: "key-1", Owner: "alice", Scopes: []string{ScopeAdmin, ScopeRead}}, nil
+	}, func(username string) []string { return owners[username] })
+
+	authenticate := func() (Principal, error) {
+		req := httptest.NewRequest("GET", "http://testing.com", nil)
+		req.Header.Set("X-API-Key", "tdk_alice")
+		return sut(req)
+	}
+
+	p, err := authenticate()
+	if err != nil || !p.HasRole(RoleAdmin) || !p.HasRole(RoleReader) {
+		t.Errorf("expected the key's scopes while alice is an admin, got %+v and %v", p, err)
+	}
+
+	owners["alice"] = []string{RoleReader}
+	p, err = authenticate()
+	if err != nil || p.HasRole(RoleAdmin) || !p.HasRole(RoleReader) {
+		t.Errorf("expected the key to lose admin once alice is demoted, got %+v and %v", p, err)
+	}
+
+	delete(owners, "alice")
+	if p, err := authenticate(); err != ErrUnauthenticated {
+		t.Errorf("expected the key to be rejected once alice has no roles, got %+v and %v", p, err)
+	}
+}
+
+func TestMiddlewareTriesEachAuthenticator(t *testing.T) {
+	var principal Principal
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+	})
+
+	sut := NewMiddleware(
+		BasicAuthenticator(NewMapValidator(map[string]string{"user": "pass"}), StaticRoles(RoleReader)),
+		APIKeyAuthenticator(func(ctx context.Context, hash string) (APIKeyRecord, error) {
+			return APIKeyRecord{Owner: "svc", Scopes: []string{ScopeRead}}, nil
+		}, StaticRoles(RoleReader)),
+	)(inner)
+
+	req := httptest.NewRequest("GET", "http://testing.com", nil)
+	req.Header.Set("X-API-Key", "tdk_anything")
+	recorder := httptest.NewRecorder()
+	sut.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK || principal.Name != "svc" {
+		t.Errorf("expected api key to be accepted, got %d %+v", recorder.Code, principal)
+	}
+
+	req = httptest.NewRequest("GET", "http://testing.com", nil)
+	req.SetBasicAuth("user", "wrong")
+	req.Header.Set("X-API-Key", "tdk_anything")
+	recorder = httptest.NewRecorder()
+	sut.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected bad basic credentials to be rejected, got %d", recorder.Code)
+	}
+}
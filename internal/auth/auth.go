@@ -3,31 +3,189 @@ package auth
 import (
 	"context"
 	"crypto/subtle"
+	"errors"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
+// ErrUnauthenticated is returned when an operation requires a principal but the request
+// didn't carry one, or carried invalid credentials.
+var ErrUnauthenticated = errors.New("authentication required")
+
+// ErrForbidden is returned when the authenticated principal isn't allowed to perform an
+// operation.
+var ErrForbidden = errors.New("operation not permitted")
+
+// ErrNoCredentials is returned by an Authenticator when the request doesn't carry the kind
+// of credentials it checks, so that the next Authenticator can be tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Roles determine what a principal may do.  They don't imply one another, so a principal
+// that should both read and submit needs both roles.
+const (
+	RoleReader    = "reader"
+	RoleSubmitter = "submitter"
+	RoleAdmin     = "admin"
+)
+
+var AllRoles = []string{RoleReader, RoleSubmitter, RoleAdmin}
+
+// Principal is the authenticated identity behind a request.
+type Principal struct {
+	Name  string
+	Roles []string
+	// Tenant owns the data the principal can see; empty means DefaultTenant.
+	Tenant string
+	// KeyID is the ID of the API key the principal authenticated with, if any.
+	KeyID string
+}
+
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 type ValidationFunc func(username, password string) bool
 
+// RoleFunc returns the roles granted to a Basic Authentication user.
+type RoleFunc func(username string) []string
+
+// Authenticator checks one kind of credential carried by a request.
+type Authenticator func(r *http.Request) (Principal, error)
+
 type AuthorizationCtx string
 
-func NewBasicAuth(validator ValidationFunc) func(http.Handler) http.Handler {
+// NewMiddleware authenticates requests with the first Authenticator that finds its kind
+// of credentials on the request, rejecting requests that none of them accept.
+func NewMiddleware(authenticators ...Authenticator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			username, password, ok := r.BasicAuth()
-
-			if !ok || !validator(username, password) {
+			p, err := Authenticate(r, authenticators...)
+			var throttled ErrThrottled
+			if errors.As(err, &throttled) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+				http.Error(w, throttled.Error(), http.StatusTooManyRequests)
+				return
+			}
+			if err != nil {
 				http.Error(w, "authorization failed", http.StatusUnauthorized)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), AuthorizationCtx("authorizedUser"), username)
-
-			r = r.WithContext(ctx)
+			ctx := ContextWithPrincipal(r.Context(), p)
+			r = r.WithContext(ContextWithClientIP(ctx, remoteIP(r.RemoteAddr)))
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// Authenticate runs the authenticators against r, returning ErrUnauthenticated if none
+// of them accept it, or ErrThrottled if the caller must wait before trying again.
+func Authenticate(r *http.Request, authenticators ...Authenticator) (Principal, error) {
+	for _, authenticate := range authenticators {
+		p, err := authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		var throttled ErrThrottled
+		if errors.As(err, &throttled) {
+			return Principal{}, throttled
+		}
+		if err != nil {
+			return Principal{}, ErrUnauthenticated
+		}
+		return p, nil
+	}
+	return Principal{}, ErrUnauthenticated
+}
+
+func NewBasicAuth(validator ValidationFunc, roles RoleFunc) func(http.Handler) http.Handler {
+	return NewMiddleware(BasicAuthenticator(validator, roles))
+}
+
+// BasicAuthenticator accepts HTTP Basic credentials that pass validator, granting the
+// user the roles returned by roles.
+func BasicAuthenticator(validator ValidationFunc, roles RoleFunc) Authenticator {
+	return func(r *http.Request) (Principal, error) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			return Principal{}, ErrNoCredentials
+		}
+
+		if !validator(username, password) {
+			return Principal{}, ErrUnauthenticated
+		}
+
+		return Principal{Name: username, Roles: roles(username)}, nil
+	}
+}
+
+// bearerToken returns the token in the request's "Authorization: Bearer" header, if any.
+func bearerToken(r *http.Request) string {
+	const bearer = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) > len(bearer) && strings.EqualFold(header[:len(bearer)], bearer) {
+		return header[len(bearer):]
+	}
+	return ""
+}
+
+// ContextWithPrincipal returns a copy of ctx that records p as the authorized principal.
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	ctx = context.WithValue(ctx, AuthorizationCtx("authorizedUser"), p.Name)
+	return context.WithValue(ctx, AuthorizationCtx("principal"), p)
+}
+
+// ContextWithClientIP returns a copy of ctx that records ip as the caller's address.
+func ContextWithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, AuthorizationCtx("clientIP"), ip)
+}
+
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(AuthorizationCtx("clientIP")).(string)
+	return ip
+}
+
+// remoteIP strips the port from a "host:port" address.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(AuthorizationCtx("principal")).(Principal)
+	return p, ok
+}
+
+// RequireRole returns an error unless ctx carries a principal with role.
+func RequireRole(ctx context.Context, role string) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if !p.HasRole(role) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// StaticRoles grants every user the same roles.
+func StaticRoles(roles ...string) RoleFunc {
+	return func(string) []string {
+		return roles
+	}
+}
+
 func NewMapValidator(credentials map[string]string) ValidationFunc {
 	return func(username, password string) bool {
 
@@ -23,21 +23,21 @@ func TestBasicAuth(t *testing.T) {
 	}{
 		{
 			"auth success",
-			NewBasicAuth(func(string, string) bool { return true })(innerHandler),
+			NewBasicAuth(func(string, string) bool { return true }, StaticRoles())(innerHandler),
 			200,
 			"username",
 			"password",
 		},
 		{
 			"auth fail",
-			NewBasicAuth(func(string, string) bool { return false })(innerHandler),
+			NewBasicAuth(func(string, string) bool { return false }, StaticRoles())(innerHandler),
 			401,
 			"username",
 			"password",
 		},
 		{
 			"auth missing",
-			NewBasicAuth(func(string, string) bool { return true })(innerHandler), // test should NOT call validator at all
+			NewBasicAuth(func(string, string) bool { return true }, StaticRoles())(innerHandler), // test should NOT call validator at all
 			401,
 			"",
 			"",
@@ -49,7 +49,7 @@ func TestBasicAuth(t *testing.T) {
 
 			req, err := http.NewRequest("POST", "http://testing.com", nil)
 			if err != nil {
-				t.Errorf("http.NewRequest returned an error: %w", err)
+				t.Errorf("http.NewRequest returned an error: %s", err)
 			}
 			if test.testPass != "" {
 				req.SetBasicAuth(test.testUser, test.testPass)
@@ -104,3 +104,34 @@ func TestMapValidator(t *testing.T) {
 		})
 	}
 }
+
+func TestRequireRole(t *testing.T) {
+	ctx := httptest.NewRequest("GET", "http://testing.com", nil).Context()
+	if err := RequireRole(ctx, RoleReader); err != ErrUnauthenticated {
+		t.Errorf("expected ErrUnauthenticated without a principal, got %v", err)
+	}
+
+	ctx = ContextWithPrincipal(ctx, Principal{Name: "reader", Roles: []string{RoleReader}})
+	if err := RequireRole(ctx, RoleReader); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := RequireRole(ctx, RoleAdmin); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+}
+
+func TestMiddlewareRecordsClientIP(t *testing.T) {
+	var clientIP string
+	sut := NewBasicAuth(func(string, string) bool { return true }, StaticRoles())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP = ClientIPFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "http://testing.com", nil)
+	req.RemoteAddr = "[2001:db8::1]:54321"
+	req.SetBasicAuth("user", "pass")
+	sut.ServeHTTP(httptest.NewRecorder(), req)
+
+	if clientIP != "2001:db8::1" {
+		t.Errorf("expected client ip 2001:db8::1, got %q", clientIP)
+	}
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Parts of a client certificate a CertRule can match.
+var certMatchers = map[string]func(cert *x509.Certificate, value string) bool{
+	"cn": func(cert *x509.Certificate, value string) bool {
+		return cert.Subject.CommonName == value
+	},
+	"dns": func(cert *x509.Certificate, value string) bool {
+		return containsFold(cert.DNSNames, value)
+	},
+	"email": func(cert *x509.Certificate, value string) bool {
+		return containsFold(cert.EmailAddresses, value)
+	},
+	"uri": func(cert *x509.Certificate, value string) bool {
+		for _, u := range cert.URIs {
+			if u.String() == value {
+				return true
+			}
+		}
+		return false
+	},
+	"ip": func(cert *x509.Certificate, value string) bool {
+		for _, ip := range cert.IPAddresses {
+			if ip.String() == value {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// CertRule maps client certificates whose subject common name or subject alternative
+// name matches to a principal.
+type CertRule struct {
+	Kind      string // "cn", "dns", "email", "uri" or "ip"
+	Value     string
+	Principal string
+	Roles     []string
+}
+
+// CertMapping is an ordered list of rules; the first rule matching a certificate wins.
+type CertMapping []CertRule
+
+// LoadCertMapping reads a mapping file with one rule per line, giving the part of the
+// certificate to match, the principal's name and its roles:
+//
+//	dns:collector-1.internal  collector-1  submitter reader
+//	cn:ops-laptop             ops          admin
+//
+// Blank lines and lines starting with '#' are ignored.
+func LoadCertMapping(path string) (CertMapping, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client certificate mapping: %w", err)
+	}
+
+	var mapping CertMapping
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("client certificate mapping line %d: expected \"kind:value principal role...\"", n)
+		}
+
+		i := strings.Index(fields[0], ":")
+		if i < 0 {
+			return nil, fmt.Errorf("client certificate mapping line %d: expected kind:value, got %q", n, fields[0])
+		}
+		rule := CertRule{Kind: fields[0][:i], Value: fields[0][i+1:], Principal: fields[1], Roles: fields[2:]}
+		if _, ok := certMatchers[rule.Kind]; !ok {
+			return nil, fmt.Errorf("client certificate mapping line %d: unknown kind %q", n, rule.Kind)
+		}
+		for _, role := range rule.Roles {
+			if !validRole(role) {
+				return nil, fmt.Errorf("client certificate mapping line %d: unknown role %q", n, role)
+			}
+		}
+
+		mapping = append(mapping, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading client certificate mapping: %w", err)
+	}
+
+	return mapping, nil
+}
+
+// Match returns the principal for cert.
+func (m CertMapping) Match(cert *x509.Certificate) (Principal, bool) {
+	for _, rule := range m {
+		if certMatchers[rule.Kind](cert, rule.Value) {
+			return Principal{Name: rule.Principal, Roles: rule.Roles}, true
+		}
+	}
+	return Principal{}, false
+}
+
+// CertAuthenticator accepts requests made with a client certificate that the TLS
+// server verified and that matches a rule in mapping.  Certificates that don't match
+// are treated as carrying no credentials, so that their holders can still log in
+// another way.
+func CertAuthenticator(mapping CertMapping) Authenticator {
+	return func(r *http.Request) (Principal, error) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return Principal{}, ErrNoCredentials
+		}
+
+		cert := r.TLS.VerifiedChains[0][0]
+		p, ok := mapping.Match(cert)
+		if !ok {
+			log.Printf("no principal mapped for client certificate %q", cert.Subject.String())
+			return Principal{}, ErrNoCredentials
+		}
+		return p, nil
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
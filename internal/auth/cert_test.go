@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating ca: %s", err.Error())
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return testCA{cert: cert, key: key}
+}
+
+func (ca testCA) issue(t *testing.T, cn string, dnsNames ...string) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("error issuing certificate: %s", err.Error())
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestLoadCertMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients")
+	contents := "# collectors\ndns:collector-1.internal  collector-1  submitter reader\n\ncn:ops-laptop ops admin\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mapping, err := LoadCertMapping(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := CertMapping{
+		{Kind: "dns", Value: "collector-1.internal", Principal: "collector-1", Roles: []string{RoleSubmitter, RoleReader}},
+		{Kind: "cn", Value: "ops-laptop", Principal: "ops", Roles: []string{RoleAdmin}},
+	}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("expected %+v, got %+v", expected, mapping)
+	}
+
+	for _, bad := range []string{"collector-1.internal collector-1", "serial:1 someone", "cn:x someone superuser"} {
+		if err := ioutil.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatal(err.Error())
+		}
+		if _, err := LoadCertMapping(path); err == nil {
+			t.Errorf("expected an error loading %q", bad)
+		}
+	}
+}
+
+func TestCertAuthenticator(t *testing.T) {
+	ca := newTestCA(t)
+	other := newTestCA(t)
+
+	mapping := CertMapping{
+		{Kind: "dns", Value: "collector-1.internal", Principal: "collector-1", Roles: []string{RoleSubmitter}},
+		{Kind: "cn", Value: "ops-laptop", Principal: "ops", Roles: []string{RoleAdmin}},
+	}
+
+	var principal Principal
+	srv := httptest.NewUnstartedServer(NewMiddleware(
+		CertAuthenticator(mapping),
+		BasicAuthenticator(NewMapValidator(map[string]string{"user": "pass"}), StaticRoles(RoleReader)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+	})))
+	srv.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: x509.NewCertPool()}
+	srv.TLS.ClientCAs.AddCert(ca.cert)
+	srv.StartTLS()
+	defer srv.Close()
+
+	testCases := []struct {
+		name         string
+		cert         *tls.Certificate
+		basicAuth    bool
+		expectedCode int
+		expected     Principal
+	}{
+		{"san", certPtr(ca.issue(t, "c1", "collector-1.internal")), false, http.StatusOK, Principal{Name: "collector-1", Roles: []string{RoleSubmitter}}},
+		{"cn", certPtr(ca.issue(t, "ops-laptop")), false, http.StatusOK, Principal{Name: "ops", Roles: []string{RoleAdmin}}},
+		{"unmapped certificate", certPtr(ca.issue(t, "stranger")), false, http.StatusUnauthorized, Principal{}},
+		{"unmapped certificate with password", certPtr(ca.issue(t, "stranger")), true, http.StatusOK, Principal{Name: "user", Roles: []string{RoleReader}}},
+		{"password only", nil, true, http.StatusOK, Principal{Name: "user", Roles: []string{RoleReader}}},
+		{"nothing", nil, false, http.StatusUnauthorized, Principal{}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			principal = Principal{}
+
+			transport := srv.Client().Transport.(*http.Transport).Clone()
+			if test.cert != nil {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{*test.cert}
+			}
+			client := &http.Client{Transport: transport}
+
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			if test.basicAuth {
+				req.SetBasicAuth("user", "pass")
+			}
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			res.Body.Close()
+
+			if res.StatusCode != test.expectedCode {
+				t.Errorf("expected status %d, got %d", test.expectedCode, res.StatusCode)
+			}
+			if !reflect.DeepEqual(principal, test.expected) {
+				t.Errorf("expected principal %+v, got %+v", test.expected, principal)
+			}
+		})
+	}
+
+	// a certificate from another CA is rejected during the handshake
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{other.issue(t, "ops-laptop")}
+	if res, err := (&http.Client{Transport: transport}).Get(srv.URL); err == nil {
+		res.Body.Close()
+		t.Error("expected a certificate from an unknown CA to be rejected")
+	}
+}
+
+func certPtr(c tls.Certificate) *tls.Certificate {
+	return &c
+}
@@ -0,0 +1,325 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A bcrypt hash of a random password, compared against when a username is unknown so
+// that failed logins take the same time whether or not the user exists.
+var dummyHash = []byte("$2a$10$R2r1kSso8NeJBVi6V0tz/.2hEFj0SpCFmfO7o9wNVI.Bt7I9nApqS")
+
+// CredentialsFile is an htpasswd-compatible file of "username:hash" lines.  Hashes may be
+// bcrypt ($2a$, $2b$, $2y$) or argon2 ($argon2id$, $argon2i$) in PHC string format.
+// Blank lines and lines starting with '#' are preserved when the file is rewritten.
+type CredentialsFile struct {
+	path  string
+	lines []string
+	users map[string]int // username -> index into lines
+}
+
+// LoadCredentialsFile reads and validates the file at path.  A missing file is treated
+// as empty so that the first user can be added to it.
+func LoadCredentialsFile(path string) (*CredentialsFile, error) {
+	cf := &CredentialsFile{path: path, users: map[string]int{}}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cf, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading credentials file: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		cf.lines = append(cf.lines, line)
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(trimmed, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected username:hash", path, lineNum)
+		}
+		username, hash := trimmed[:i], trimmed[i+1:]
+		if _, ok := cf.users[username]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate user %s", path, lineNum, username)
+		}
+		if !supportedHash(hash) {
+			return nil, fmt.Errorf("%s:%d: unsupported hash for user %s; use bcrypt or argon2", path, lineNum, username)
+		}
+		cf.users[username] = len(cf.lines) - 1
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading credentials file: %w", err)
+	}
+
+	return cf, nil
+}
+
+// Users returns the usernames in the file in sorted order.
+func (cf *CredentialsFile) Users() []string {
+	users := make([]string, 0, len(cf.users))
+	for username := range cf.users {
+		users = append(users, username)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Add adds a new user with the given password.
+func (cf *CredentialsFile) Add(username, password string) error {
+	if _, ok := cf.users[username]; ok {
+		return fmt.Errorf("user %s already exists", username)
+	}
+	line, err := credentialLine(username, password)
+	if err != nil {
+		return err
+	}
+	cf.lines = append(cf.lines, line)
+	cf.users[username] = len(cf.lines) - 1
+	return nil
+}
+
+// Rotate replaces the password of an existing user.
+func (cf *CredentialsFile) Rotate(username, password string) error {
+	i, ok := cf.users[username]
+	if !ok {
+		return fmt.Errorf("user %s does not exist", username)
+	}
+	line, err := credentialLine(username, password)
+	if err != nil {
+		return err
+	}
+	cf.lines[i] = line
+	return nil
+}
+
+// Remove deletes an existing user.
+func (cf *CredentialsFile) Remove(username string) error {
+	i, ok := cf.users[username]
+	if !ok {
+		return fmt.Errorf("user %s does not exist", username)
+	}
+	cf.lines = append(cf.lines[:i], cf.lines[i+1:]...)
+	delete(cf.users, username)
+	for u, j := range cf.users {
+		if j > i {
+			cf.users[u] = j - 1
+		}
+	}
+	return nil
+}
+
+// Save atomically replaces the file on disk.
+func (cf *CredentialsFile) Save() error {
+	var buf bytes.Buffer
+	for _, line := range cf.lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(cf.path), filepath.Base(cf.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temporary credentials file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing credentials file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing credentials file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return fmt.Errorf("error setting credentials file permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), cf.path); err != nil {
+		return fmt.Errorf("error replacing credentials file: %w", err)
+	}
+	return nil
+}
+
+func (cf *CredentialsFile) hashes() map[string]string {
+	hashes := make(map[string]string, len(cf.users))
+	for username, i := range cf.users {
+		line := strings.TrimSpace(cf.lines[i])
+		hashes[username] = line[len(username)+1:]
+	}
+	return hashes
+}
+
+func credentialLine(username, password string) (string, error) {
+	if username == "" || strings.ContainsAny(username, ": \t") {
+		return "", fmt.Errorf("username must be non-empty and may not contain ':' or whitespace")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("error hashing password: %w", err)
+	}
+	return username + ":" + string(hash), nil
+}
+
+func supportedHash(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$argon2id$", "$argon2i$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkHash(hash, password string) bool {
+	if strings.HasPrefix(hash, "$argon2") {
+		return checkArgon2(hash, password)
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// checkArgon2 verifies a PHC formatted hash like
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func checkArgon2(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+
+	var actual []byte
+	switch parts[1] {
+	case "argon2id":
+		actual = argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	case "argon2i":
+		actual = argon2.Key([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(actual, expected) == 1
+}
+
+// FileValidator validates credentials against a CredentialsFile, picking up changes
+// to the file when Reload is called or while Watch is running.
+type FileValidator struct {
+	path    string
+	mu      sync.RWMutex
+	hashes  map[string]string
+	modTime time.Time
+}
+
+func NewFileValidator(path string) (*FileValidator, error) {
+	fv := &FileValidator{path: path}
+	if err := fv.Reload(); err != nil {
+		return nil, err
+	}
+	return fv, nil
+}
+
+// Reload re-reads the credentials file.  If the file is invalid the previously loaded
+// credentials stay in effect.
+func (fv *FileValidator) Reload() error {
+	info, err := os.Stat(fv.path)
+	if err != nil {
+		return fmt.Errorf("error reading credentials file: %w", err)
+	}
+
+	cf, err := LoadCredentialsFile(fv.path)
+	if err != nil {
+		return err
+	}
+
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.hashes = cf.hashes()
+	fv.modTime = info.ModTime()
+	return nil
+}
+
+// Watch polls the credentials file every interval and reloads it when it changes,
+// until stop is closed.
+func (fv *FileValidator) Watch(interval time.Duration, stop <-chan struct{}) {
+	watchFile(fv.path, "credentials", interval, stop, func() time.Time {
+		fv.mu.RLock()
+		defer fv.mu.RUnlock()
+		return fv.modTime
+	}, fv.Reload)
+}
+
+// watchFile polls path every interval and calls reload when its modification time no
+// longer matches loaded(), until stop is closed.
+func watchFile(path, what string, interval time.Duration, stop <-chan struct{}, loaded func() time.Time, reload func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				log.Printf("error checking %s file: %s", what, err.Error())
+				continue
+			}
+
+			if !info.ModTime().Equal(loaded()) {
+				if err := reload(); err != nil {
+					log.Printf("error reloading %s file: %s", what, err.Error())
+				} else {
+					log.Printf("reloaded %s from %s", what, path)
+				}
+			}
+		}
+	}
+}
+
+// Validate is a ValidationFunc.
+func (fv *FileValidator) Validate(username, password string) bool {
+	fv.mu.RLock()
+	hash, ok := fv.hashes[username]
+	fv.mu.RUnlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return checkHash(hash, password)
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func bcryptHash(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return string(hash)
+}
+
+func argon2Hash(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 64*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func writeCredentials(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "users.htpasswd")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	return path
+}
+
+func TestFileValidator(t *testing.T) {
+	path := writeCredentials(t, fmt.Sprintf("# service accounts\nalice:%s\n\nbob:%s\n",
+		bcryptHash(t, "alicepass"), argon2Hash("bobpass")))
+
+	fv, err := NewFileValidator(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading credentials: %s", err.Error())
+	}
+
+	testCases := []struct {
+		name     string
+		user     string
+		pwd      string
+		expected bool
+	}{
+		{"bcrypt match", "alice", "alicepass", true},
+		{"bcrypt mismatch", "alice", "bobpass", false},
+		{"argon2 match", "bob", "bobpass", true},
+		{"argon2 mismatch", "bob", "alicepass", false},
+		{"unknown user", "carol", "alicepass", false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if fv.Validate(test.user, test.pwd) != test.expected {
+				t.Error("validator returned unexpected result")
+			}
+		})
+	}
+}
+
+func TestLoadCredentialsFileRejectsBadLines(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents string
+	}{
+		{"plaintext password", "alice:alicepass\n"},
+		{"apr1 hash", "alice:$apr1$salt$hash\n"},
+		{"missing separator", "alice\n"},
+		{"duplicate user", fmt.Sprintf("alice:%s\nalice:%s\n", argon2Hash("a"), argon2Hash("b"))},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := LoadCredentialsFile(writeCredentials(t, test.contents)); err == nil {
+				t.Error("expected an error loading credentials")
+			}
+		})
+	}
+}
+
+func TestCredentialsFileEdits(t *testing.T) {
+	path := writeCredentials(t, fmt.Sprintf("# keep me\nalice:%s\nbob:%s\n", argon2Hash("alicepass"), argon2Hash("bobpass")))
+
+	cf, err := LoadCredentialsFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading credentials: %s", err.Error())
+	}
+
+	if err := cf.Add("alice", "x"); err == nil {
+		t.Error("expected an error adding an existing user")
+	}
+	if err := cf.Rotate("carol", "x"); err == nil {
+		t.Error("expected an error rotating a missing user")
+	}
+	if err := cf.Add("bad:name", "x"); err == nil {
+		t.Error("expected an error adding a user with ':' in the name")
+	}
+
+	if err := cf.Add("carol", "carolpass"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cf.Rotate("alice", "newpass"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cf.Remove("bob"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cf.Save(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	contents, _ := ioutil.ReadFile(path)
+	if !strings.HasPrefix(string(contents), "# keep me\n") {
+		t.Errorf("expected comments to be preserved, got %q", string(contents))
+	}
+
+	fv, err := NewFileValidator(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading saved credentials: %s", err.Error())
+	}
+	if !fv.Validate("alice", "newpass") || fv.Validate("alice", "alicepass") {
+		t.Error("expected alice's password to be rotated")
+	}
+	if fv.Validate("bob", "bobpass") {
+		t.Error("expected bob to be removed")
+	}
+	if !fv.Validate("carol", "carolpass") {
+		t.Error("expected carol to be added")
+	}
+	if users := cf.Users(); len(users) != 2 || users[0] != "alice" || users[1] != "carol" {
+		t.Errorf("unexpected users: %v", users)
+	}
+}
+
+func TestFileValidatorWatch(t *testing.T) {
+	path := writeCredentials(t, "alice:"+argon2Hash("alicepass")+"\n")
+
+	fv, err := NewFileValidator(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading credentials: %s", err.Error())
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go fv.Watch(10*time.Millisecond, stop)
+
+	if err := ioutil.WriteFile(path, []byte("alice:"+argon2Hash("newpass")+"\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	// make sure the modification time changes even on filesystems with coarse timestamps
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !fv.Validate("alice", "newpass") {
+		if time.Now().After(deadline) {
+			t.Fatal("credentials file change was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFileValidatorKeepsCredentialsOnBadReload(t *testing.T) {
+	path := writeCredentials(t, "alice:"+argon2Hash("alicepass")+"\n")
+
+	fv, err := NewFileValidator(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading credentials: %s", err.Error())
+	}
+
+	if err := ioutil.WriteFile(path, []byte("alice:plaintext\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := fv.Reload(); err == nil {
+		t.Error("expected an error reloading an invalid file")
+	}
+	if !fv.Validate("alice", "alicepass") {
+		t.Error("expected previous credentials to remain in effect")
+	}
+}
@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms accepted for bearer tokens.  Symmetric algorithms are excluded so
+// that a public key can never be used as an HMAC secret.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// How often a JWKS URL may be re-fetched when a token names an unknown key.
+const jwksRefreshInterval = time.Minute
+
+// Allowance for clock skew between the token issuer and this service.
+const jwtLeeway = 30 * time.Second
+
+// JWKS is a set of public keys used to verify bearer tokens, read from a file or fetched
+// from a URL.  Keys fetched from a URL are refreshed when a token is signed with a key
+// that isn't in the set, to pick up key rotation.
+type JWKS struct {
+	source string
+	client *http.Client
+
+	mu   sync.RWMutex
+	keys map[string]crypto.PublicKey
+	// When the key set was last read, or a refresh for an unknown key was last tried.
+	attempted time.Time
+}
+
+// LoadJWKS reads the key set from source, which is either a file path or an http(s) URL.
+func LoadJWKS(source string) (*JWKS, error) {
+	ks := &JWKS{source: source, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := ks.Refresh(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *JWKS) remote() bool {
+	return strings.HasPrefix(ks.source, "http://") || strings.HasPrefix(ks.source, "https://")
+}
+
+// Refresh re-reads the key set.  If it can't be read the previous keys stay in effect.
+func (ks *JWKS) Refresh() error {
+	contents, err := ks.read()
+	if err != nil {
+		return fmt.Errorf("error reading jwks: %w", err)
+	}
+
+	keys, err := parseJWKS(contents)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.attempted = time.Now()
+	return nil
+}
+
+func (ks *JWKS) read() ([]byte, error) {
+	if !ks.remote() {
+		return ioutil.ReadFile(ks.source)
+	}
+
+	res, err := ks.client.Get(ks.source)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// key returns the key with the given id, refreshing a remote key set at most once per
+// jwksRefreshInterval if it isn't known.  The attempt is recorded before fetching, so a
+// failing endpoint isn't fetched again for every token until it recovers.
+func (ks *JWKS) key(kid string) (crypto.PublicKey, error) {
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+	if ks.remote() && ks.tryRefresh() {
+		if err := ks.Refresh(); err != nil {
+			return nil, err
+		}
+		ks.mu.RLock()
+		key, ok = ks.keys[kid]
+		ks.mu.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// tryRefresh reports whether a refresh for an unknown key may be made now, and if so
+// records the attempt.
+func (ks *JWKS) tryRefresh() bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if time.Since(ks.attempted) <= jwksRefreshInterval {
+		return false
+	}
+	ks.attempted = time.Now()
+	return true
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS decodes the RSA and EC signing keys in a JSON Web Key Set, skipping keys of
+// other types.
+func parseJWKS(contents []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(contents, &set); err != nil {
+		return nil, fmt.Errorf("error decoding jwks: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding jwk %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("jwks contains no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("rsa exponent too large")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("point is not on curve")
+	}
+	return key, nil
+}
+
+// JWTConfig describes the tokens a JWTAuthenticator accepts.
+type JWTConfig struct {
+	Issuer   string
+	Audience string
+
+	// NameClaim names the claim used as the principal's name, "sub" by default.
+	NameClaim string
+
+	// RolesClaim names the claim listing the principal's roles, "roles" by default.  It
+	// may be an array or a space separated string.  Unknown roles are ignored.
+	RolesClaim string
+
+	// TenantClaim, if set, names the claim giving the principal's tenant.  Tokens
+	// without it fall back to the usual tenant assignment.
+	TenantClaim string
+}
+
+// JWTAuthenticator accepts bearer tokens signed by a key in keys that were issued by
+// cfg.Issuer for cfg.Audience and haven't expired.
+func JWTAuthenticator(keys *JWKS, cfg JWTConfig) Authenticator {
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = "sub"
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	parser := jwt.NewParser(opts...)
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys.key(kid)
+	}
+
+	return func(r *http.Request) (Principal, error) {
+		raw := bearerToken(r)
+		if raw == "" || strings.HasPrefix(raw, apiKeyPrefix) {
+			return Principal{}, ErrNoCredentials
+		}
+
+		claims := jwt.MapClaims{}
+		if _, err := parser.ParseWithClaims(raw, claims, keyFunc); err != nil {
+			return Principal{}, ErrUnauthenticated
+		}
+
+		name, _ := claims[cfg.NameClaim].(string)
+		if name == "" {
+			return Principal{}, ErrUnauthenticated
+		}
+
+		p := Principal{Name: name, Roles: claimRoles(claims[cfg.RolesClaim])}
+		if cfg.TenantClaim != "" {
+			p.Tenant, _ = claims[cfg.TenantClaim].(string)
+			if p.Tenant != "" && !ValidTenant(p.Tenant) {
+				return Principal{}, ErrUnauthenticated
+			}
+		}
+		return p, nil
+	}
+}
+
+// claimRoles returns the known roles listed in a roles claim.
+func claimRoles(claim interface{}) []string {
+	var names []string
+	switch c := claim.(type) {
+	case string:
+		names = strings.Fields(c)
+	case []interface{}:
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+
+	var roles []string
+	for _, name := range names {
+		if validRole(name) {
+			roles = append(roles, name)
+		}
+	}
+	return roles
+}
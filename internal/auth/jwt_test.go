@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://sso.example.com"
+	testAudience = "threat-detect"
+)
+
+// jwksServer stands in for an identity provider's JWKS endpoint.
+type jwksServer struct {
+	mu       sync.Mutex
+	keys     []map[string]string
+	down     bool
+	requests int
+}
+
+func (s *jwksServer) add(kid string, key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enc := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		s.keys = append(s.keys, map[string]string{
+			"kid": kid, "kty": "RSA", "use": "sig", "n": enc(k.N), "e": enc(big.NewInt(int64(k.E))),
+		})
+	case *ecdsa.PublicKey:
+		s.keys = append(s.keys, map[string]string{
+			"kid": kid, "kty": "EC", "crv": "P-256", "x": enc(k.X), "y": enc(k.Y),
+		})
+	}
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("error signing token: %s", err.Error())
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"sub":   "alice",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"reader", "submitter", "superuser"},
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	jwks := &jwksServer{}
+	jwks.add("rsa", &rsaKey.PublicKey)
+	jwks.add("ec", &ecKey.PublicKey)
+	srv := httptest.NewServer(jwks)
+	defer srv.Close()
+
+	keys, err := LoadJWKS(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error loading jwks: %s", err.Error())
+	}
+	sut := JWTAuthenticator(keys, JWTConfig{Issuer: testIssuer, Audience: testAudience})
+
+	with := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		claims := validClaims()
+		change(claims)
+		return claims
+	}
+
+	testCases := []struct {
+		name        string
+		token       string
+		expectedErr error
+	}{
+		{"rsa", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims()), nil},
+		{"ec", signToken(t, jwt.SigningMethodES256, "ec", ecKey, validClaims()), nil},
+		{"wrong key", signToken(t, jwt.SigningMethodRS256, "rsa", otherKey, validClaims()), ErrUnauthenticated},
+		{"unknown kid", signToken(t, jwt.SigningMethodRS256, "other", otherKey, validClaims()), ErrUnauthenticated},
+		{"hmac", signToken(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), validClaims()), ErrUnauthenticated},
+		{"wrong issuer", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" })), ErrUnauthenticated},
+		{"wrong audience", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["aud"] = "other-service" })), ErrUnauthenticated},
+		{"expired", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), ErrUnauthenticated},
+		{"no expiry", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { delete(c, "exp") })), ErrUnauthenticated},
+		{"no subject", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { delete(c, "sub") })), ErrUnauthenticated},
+		{"api key", "tdk_something", ErrNoCredentials},
+		{"no token", "", ErrNoCredentials},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://testing.com", nil)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			p, err := sut(req)
+			if err != test.expectedErr {
+				t.Fatalf("expected error %v but got %v", test.expectedErr, err)
+			}
+			if err == nil {
+				expected := Principal{Name: "alice", Roles: []string{RoleReader, RoleSubmitter}}
+				if !reflect.DeepEqual(p, expected) {
+					t.Errorf("expected principal %+v, got %+v", expected, p)
+				}
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorClaimMapping(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwks := &jwksServer{}
+	jwks.add("rsa", &key.PublicKey)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	recorder := httptest.NewRecorder()
+	jwks.ServeHTTP(recorder, nil)
+	if err := ioutil.WriteFile(path, recorder.Body.Bytes(), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	keys, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading jwks file: %s", err.Error())
+	}
+	sut := JWTAuthenticator(keys, JWTConfig{
+		Issuer:      testIssuer,
+		Audience:    testAudience,
+		NameClaim:   "email",
+		RolesClaim:  "scope",
+		TenantClaim: "org",
+	})
+
+	claims := validClaims()
+	claims["email"] = "alice@example.com"
+	claims["scope"] = "openid admin"
+	claims["org"] = "red-team"
+
+	req := httptest.NewRequest("GET", "http://testing.com", nil)
+	req.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "rsa", key, claims))
+
+	p, err := sut(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := Principal{Name: "alice@example.com", Roles: []string{RoleAdmin}, Tenant: "red-team"}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("expected principal %+v, got %+v", expected, p)
+	}
+
+	claims["org"] = "red team"
+	req.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "rsa", key, claims))
+	if _, err := sut(req); err != ErrUnauthenticated {
+		t.Errorf("expected an invalid tenant to be rejected, got %v", err)
+	}
+}
+
+func TestJWKSRefreshesOnUnknownKey(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	jwks := &jwksServer{}
+	jwks.add("old", &oldKey.PublicKey)
+	srv := httptest.NewServer(jwks)
+	defer srv.Close()
+
+	keys, err := LoadJWKS(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error loading jwks: %s", err.Error())
+	}
+	sut := JWTAuthenticator(keys, JWTConfig{Issuer: testIssuer, Audience: testAudience})
+
+	jwks.add("new", &newKey.PublicKey)
+	req := httptest.NewRequest("GET", "http://testing.com", nil)
+	req.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "new", newKey, validClaims()))
+
+	if _, err := sut(req); err != ErrUnauthenticated {
+		t.Errorf("expected the key set not to be refetched immediately, got %v", err)
+	}
+
+	keys.mu.Lock()
+	keys.attempted = time.Now().Add(-2 * jwksRefreshInterval)
+	keys.mu.Unlock()
+
+	if _, err := sut(req); err != nil {
+		t.Errorf("expected rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestJWKSLimitsFailedRefreshes(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	jwks := &jwksServer{}
+	jwks.add("old", &key.PublicKey)
+	srv := httptest.NewServer(jwks)
+	defer srv.Close()
+
+	keys, err := LoadJWKS(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error loading jwks: %s", err.Error())
+	}
+	sut := JWTAuthenticator(keys, JWTConfig{Issuer: testIssuer, Audience: testAudience})
+
+	jwks.mu.Lock()
+	jwks.down = true
+	jwks.requests = 0
+	jwks.mu.Unlock()
+
+	keys.mu.Lock()
+	keys.attempted = time.Now().Add(-2 * jwksRefreshInterval)
+	keys.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "http://testing.com", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "unknown", key, validClaims()))
+			if _, err := sut(req); err != ErrUnauthenticated {
+				t.Errorf("expected an unknown key to be rejected, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	jwks.mu.Lock()
+	defer jwks.mu.Unlock()
+	if jwks.requests != 1 {
+		t.Errorf("expected one refresh while the endpoint is down, got %d", jwks.requests)
+	}
+}
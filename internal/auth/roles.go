@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RoleFile grants roles to Basic Authentication users from a file in the format of
+// Apache's AuthGroupFile, where each line names a role followed by its members:
+//
+//	admin: alice
+//	submitter: alice bob
+//	reader: alice bob carol
+//
+// Users that aren't listed in the file are given the default roles.  A missing file is
+// treated as empty.
+type RoleFile struct {
+	*groupFile
+	defaults []string
+}
+
+func NewRoleFile(path string, defaults ...string) (*RoleFile, error) {
+	rf := &RoleFile{
+		groupFile: &groupFile{path: path, what: "role", validGroup: validRole},
+		defaults:  defaults,
+	}
+	if err := rf.Reload(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Roles is a RoleFunc.
+func (rf *RoleFile) Roles(username string) []string {
+	if roles, ok := rf.groups(username); ok {
+		return roles
+	}
+	return rf.defaults
+}
+
+// groupFile is a reloadable file in AuthGroupFile format, mapping each listed user to
+// the groups they appear in.
+type groupFile struct {
+	path       string
+	what       string
+	validGroup func(group string) bool
+	// validate, if set, checks the parsed file as a whole.
+	validate func(members map[string][]string) error
+
+	mu      sync.RWMutex
+	members map[string][]string
+	modTime time.Time
+}
+
+// Reload re-reads the file.  If the file is invalid the previously loaded groups stay
+// in effect.
+func (gf *groupFile) Reload() error {
+	var modTime time.Time
+	contents, err := ioutil.ReadFile(gf.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading %s file: %w", gf.what, err)
+	}
+	if err == nil {
+		info, err := os.Stat(gf.path)
+		if err != nil {
+			return fmt.Errorf("error reading %s file: %w", gf.what, err)
+		}
+		modTime = info.ModTime()
+	}
+
+	members, err := gf.parse(contents)
+	if err != nil {
+		return err
+	}
+	if gf.validate != nil {
+		if err := gf.validate(members); err != nil {
+			return err
+		}
+	}
+
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+	gf.members = members
+	gf.modTime = modTime
+	return nil
+}
+
+// Watch polls the file every interval and reloads it when it changes, until stop is
+// closed.
+func (gf *groupFile) Watch(interval time.Duration, stop <-chan struct{}) {
+	watchFile(gf.path, gf.what+"s", interval, stop, func() time.Time {
+		gf.mu.RLock()
+		defer gf.mu.RUnlock()
+		return gf.modTime
+	}, gf.Reload)
+}
+
+func (gf *groupFile) groups(username string) ([]string, bool) {
+	gf.mu.RLock()
+	defer gf.mu.RUnlock()
+
+	groups, ok := gf.members[username]
+	return groups, ok
+}
+
+// parse maps each user listed in contents to their groups.
+func (gf *groupFile) parse(contents []byte) (map[string][]string, error) {
+	members := map[string][]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("%s file line %d: expected \"%s: user...\"", gf.what, n, gf.what)
+		}
+
+		group := strings.TrimSpace(line[:i])
+		if !gf.validGroup(group) {
+			return nil, fmt.Errorf("%s file line %d: invalid %s %q", gf.what, n, gf.what, group)
+		}
+
+		for _, user := range strings.Fields(line[i+1:]) {
+			members[user] = append(members[user], group)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s file: %w", gf.what, err)
+	}
+
+	return members, nil
+}
+
+func validRole(role string) bool {
+	for _, r := range AllRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRoleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.roles")
+
+	rf, err := NewRoleFile(path, RoleReader)
+	if err != nil {
+		t.Fatalf("unexpected error loading missing role file: %s", err.Error())
+	}
+	if roles := rf.Roles("alice"); !reflect.DeepEqual(roles, []string{RoleReader}) {
+		t.Errorf("expected default roles for unlisted user, got %v", roles)
+	}
+
+	contents := "# roles\nadmin: alice\nsubmitter: alice  bob\n\nreader: alice bob\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := rf.Reload(); err != nil {
+		t.Fatalf("unexpected error reloading role file: %s", err.Error())
+	}
+
+	testCases := []struct {
+		user     string
+		expected []string
+	}{
+		{"alice", []string{RoleAdmin, RoleSubmitter, RoleReader}},
+		{"bob", []string{RoleSubmitter, RoleReader}},
+		{"carol", []string{RoleReader}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.user, func(t *testing.T) {
+			if roles := rf.Roles(test.user); !reflect.DeepEqual(roles, test.expected) {
+				t.Errorf("expected roles %v, got %v", test.expected, roles)
+			}
+		})
+	}
+}
+
+func TestRoleFileRejectsUnknownRole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.roles")
+	if err := ioutil.WriteFile(path, []byte("superuser: alice\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := NewRoleFile(path); err == nil {
+		t.Error("expected an error loading a file with an unknown role")
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultTenant owns the data of principals that haven't been assigned a tenant.
+const DefaultTenant = "default"
+
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidTenant reports whether name can be used as a tenant ID.
+func ValidTenant(name string) bool {
+	return len(name) <= 64 && tenantPattern.MatchString(name)
+}
+
+// TenantFunc returns the tenant a principal belongs to, given the principal's name.
+type TenantFunc func(name string) string
+
+// TenantFile assigns principals to tenants from a file in the same format as a
+// RoleFile, where each line names a tenant followed by its members:
+//
+//	red-team: alice bob
+//	blue-team: carol
+//
+// A principal may only belong to one tenant.  Principals that aren't listed belong to
+// the default tenant.  A missing file is treated as empty.
+type TenantFile struct {
+	*groupFile
+}
+
+func NewTenantFile(path string) (*TenantFile, error) {
+	tf := &TenantFile{groupFile: &groupFile{
+		path:       path,
+		what:       "tenant",
+		validGroup: ValidTenant,
+		validate:   validateTenants,
+	}}
+	if err := tf.Reload(); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// Tenant is a TenantFunc.
+func (tf *TenantFile) Tenant(name string) string {
+	if tenants, ok := tf.groups(name); ok {
+		return tenants[0]
+	}
+	return DefaultTenant
+}
+
+func validateTenants(members map[string][]string) error {
+	var dupes []string
+	for user, tenants := range members {
+		if len(tenants) > 1 {
+			dupes = append(dupes, fmt.Sprintf("%s (%s)", user, strings.Join(tenants, ", ")))
+		}
+	}
+	if len(dupes) > 0 {
+		sort.Strings(dupes)
+		return fmt.Errorf("tenant file lists users in more than one tenant: %s", strings.Join(dupes, "; "))
+	}
+	return nil
+}
+
+// WithTenants wraps authenticators so that the principals they return belong to the
+// tenant given by tenants, unless the credentials already named one.
+func WithTenants(tenants TenantFunc, authenticators ...Authenticator) []Authenticator {
+	wrapped := make([]Authenticator, 0, len(authenticators))
+	for _, authenticate := range authenticators {
+		authenticate := authenticate
+		wrapped = append(wrapped, func(r *http.Request) (Principal, error) {
+			p, err := authenticate(r)
+			if err == nil && p.Tenant == "" {
+				p.Tenant = tenants(p.Name)
+			}
+			return p, err
+		})
+	}
+	return wrapped
+}
+
+// TenantFromContext returns the tenant whose data the principal in ctx may see.
+func TenantFromContext(ctx context.Context) string {
+	if p, ok := PrincipalFromContext(ctx); ok && p.Tenant != "" {
+		return p.Tenant
+	}
+	return DefaultTenant
+}
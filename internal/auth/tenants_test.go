@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestTenantFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.tenants")
+
+	tf, err := NewTenantFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading missing tenant file: %s", err.Error())
+	}
+	if tenant := tf.Tenant("alice"); tenant != DefaultTenant {
+		t.Errorf("expected default tenant for unlisted user, got %q", tenant)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("red-team: alice bob\nblue-team: carol\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := tf.Reload(); err != nil {
+		t.Fatalf("unexpected error reloading tenant file: %s", err.Error())
+	}
+	for user, expected := range map[string]string{"alice": "red-team", "carol": "blue-team", "dave": DefaultTenant} {
+		if tenant := tf.Tenant(user); tenant != expected {
+			t.Errorf("expected %s in %q, got %q", user, expected, tenant)
+		}
+	}
+
+	for _, bad := range []string{"red team: alice\n", "red: alice\nblue: alice\n"} {
+		if err := ioutil.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := tf.Reload(); err == nil {
+			t.Errorf("expected an error loading %q", bad)
+		}
+	}
+	if tenant := tf.Tenant("alice"); tenant != "red-team" {
+		t.Errorf("expected previous tenants to stay in effect, got %q", tenant)
+	}
+}
+
+func TestWithTenants(t *testing.T) {
+	tenants := func(name string) string { return name + "-tenant" }
+	authenticators := WithTenants(tenants,
+		func(r *http.Request) (Principal, error) {
+			if r.Header.Get("X-Named") == "" {
+				return Principal{}, ErrNoCredentials
+			}
+			return Principal{Name: "alice", Tenant: "named"}, nil
+		},
+		BasicAuthenticator(NewMapValidator(map[string]string{"bob": "pass"}), StaticRoles()),
+	)
+
+	req := httptest.NewRequest("GET", "http://testing.com", nil)
+	req.SetBasicAuth("bob", "pass")
+	if p, err := Authenticate(req, authenticators...); err != nil || p.Tenant != "bob-tenant" {
+		t.Errorf("expected tenant to be assigned, got %+v %v", p, err)
+	}
+
+	req.Header.Set("X-Named", "1")
+	if p, err := Authenticate(req, authenticators...); err != nil || p.Tenant != "named" {
+		t.Errorf("expected tenant from credentials to be kept, got %+v %v", p, err)
+	}
+}
+
+func TestTenantFromContext(t *testing.T) {
+	if tenant := TenantFromContext(context.Background()); tenant != DefaultTenant {
+		t.Errorf("expected default tenant without a principal, got %q", tenant)
+	}
+	ctx := ContextWithPrincipal(context.Background(), Principal{Name: "alice", Tenant: "red"})
+	if tenant := TenantFromContext(ctx); tenant != "red" {
+		t.Errorf("expected principal's tenant, got %q", tenant)
+	}
+}
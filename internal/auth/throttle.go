@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Counters published at /debug/vars.
+var (
+	failedLogins    = expvar.NewInt("auth_failed_logins")
+	throttledLogins = expvar.NewInt("auth_throttled_logins")
+	loginLockouts   = expvar.NewInt("auth_lockouts")
+)
+
+// ErrThrottled is returned instead of checking credentials while a username or source
+// address is being made to wait after failed logins.
+type ErrThrottled struct {
+	RetryAfter time.Duration
+}
+
+func (e ErrThrottled) Error() string {
+	return fmt.Sprintf("too many failed logins, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// ThrottleConfig controls how a LoginThrottle responds to failed logins.
+type ThrottleConfig struct {
+	// FreeAttempts failures are allowed before any delay is imposed.  After that each
+	// failure doubles the wait before the next attempt, from BaseDelay up to MaxDelay.
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+
+	// A username or address is locked out for LockoutDuration once it has this many
+	// failures.  Addresses get a higher threshold as they may be shared by many users.
+	UserLockoutAfter    int
+	AddressLockoutAfter int
+	LockoutDuration     time.Duration
+
+	// Failures are forgotten after ResetAfter without another one.
+	ResetAfter time.Duration
+}
+
+var DefaultThrottleConfig = ThrottleConfig{
+	FreeAttempts:        3,
+	BaseDelay:           time.Second,
+	MaxDelay:            30 * time.Second,
+	UserLockoutAfter:    10,
+	AddressLockoutAfter: 50,
+	LockoutDuration:     15 * time.Minute,
+	ResetAfter:          15 * time.Minute,
+}
+
+// How often records that no longer affect anything are dropped.
+const throttlePruneInterval = time.Minute
+
+type throttleKey struct {
+	kind  string // "user" or "address"
+	value string
+}
+
+type failureRecord struct {
+	failures     int
+	last         time.Time
+	blockedUntil time.Time
+	// inFlight counts attempts whose outcome isn't known yet.
+	inFlight int
+}
+
+// LoginThrottle tracks failed Basic Authentication attempts per username and per
+// source address, slowing down and then locking out repeated failures.
+type LoginThrottle struct {
+	cfg ThrottleConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	records   map[throttleKey]*failureRecord
+	lastPrune time.Time
+}
+
+func NewLoginThrottle(cfg ThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{
+		cfg:     cfg,
+		now:     time.Now,
+		records: map[throttleKey]*failureRecord{},
+	}
+}
+
+// Authenticator wraps next, refusing Basic Authentication attempts from usernames and
+// addresses that must wait, and recording the outcome of the others.  Requests without
+// Basic credentials are passed straight through.
+func (lt *LoginThrottle) Authenticator(next Authenticator) Authenticator {
+	return func(r *http.Request) (Principal, error) {
+		username, _, ok := r.BasicAuth()
+		if !ok {
+			return next(r)
+		}
+
+		user := throttleKey{kind: "user", value: username}
+		addr := throttleKey{kind: "address", value: remoteIP(r.RemoteAddr)}
+
+		if wait, ok := lt.begin(user, addr); !ok {
+			throttledLogins.Add(1)
+			return Principal{}, ErrThrottled{RetryAfter: wait}
+		}
+
+		p, err := next(r)
+		if errors.Is(err, ErrUnauthenticated) {
+			failedLogins.Add(1)
+		}
+		lt.finish(err, user, addr)
+		return p, err
+	}
+}
+
+// begin reserves an attempt to log in for each of keys, or returns how long until one
+// may be made.  Once a key has failed, its attempts beyond the free ones are made one at
+// a time, so that guesses sent concurrently can't all be checked before the first of
+// them fails.  Keys without failures aren't limited, so that concurrent valid logins
+// aren't refused.
+func (lt *LoginThrottle) begin(keys ...throttleKey) (time.Duration, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	now := lt.now()
+	lt.prune(now)
+
+	var wait time.Duration
+	busy := false
+	for _, key := range keys {
+		rec := lt.record(key, now)
+		switch {
+		case rec.blockedUntil.After(now):
+			wait = maxDuration(wait, rec.blockedUntil.Sub(now))
+		case rec.failures > 0 && rec.inFlight > 0 && rec.failures+rec.inFlight >= lt.concurrentAttempts(key):
+			// wait for as long as the attempt in flight would impose if it fails
+			busy = true
+			wait = maxDuration(wait, lt.delay(rec.failures+rec.inFlight+1-lt.cfg.FreeAttempts))
+		}
+	}
+	if wait > 0 || busy {
+		return wait, false
+	}
+
+	for _, key := range keys {
+		lt.records[key].inFlight++
+	}
+	return 0, true
+}
+
+// finish records the outcome err of an attempt reserved by begin.
+func (lt *LoginThrottle) finish(err error, user, addr throttleKey) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	now := lt.now()
+	for _, key := range []throttleKey{user, addr} {
+		lt.record(key, now).inFlight--
+	}
+
+	switch {
+	case err == nil:
+		// the address isn't forgiven, or one valid account could be used to keep
+		// resetting it between guesses at others
+		rec := lt.records[user]
+		rec.failures = 0
+		rec.blockedUntil = time.Time{}
+	case errors.Is(err, ErrUnauthenticated):
+		lt.fail(now, user, addr)
+	}
+}
+
+// record returns key's record, creating it or resetting its failures if they're old
+// enough to be forgotten.
+func (lt *LoginThrottle) record(key throttleKey, now time.Time) *failureRecord {
+	rec, ok := lt.records[key]
+	if !ok {
+		rec = &failureRecord{}
+		lt.records[key] = rec
+	}
+	if rec.failures > 0 && now.Sub(rec.last) > lt.cfg.ResetAfter {
+		rec.failures = 0
+	}
+	return rec
+}
+
+func (lt *LoginThrottle) lockoutAfter(key throttleKey) int {
+	if key.kind == "address" {
+		return lt.cfg.AddressLockoutAfter
+	}
+	return lt.cfg.UserLockoutAfter
+}
+
+// concurrentAttempts returns how many of key's attempts may be in flight at once while
+// none of them could be delayed or locked out if the others fail.
+func (lt *LoginThrottle) concurrentAttempts(key throttleKey) int {
+	n := lt.cfg.FreeAttempts
+	if lockoutAfter := lt.lockoutAfter(key); lockoutAfter > 0 && lockoutAfter-1 < n {
+		n = lockoutAfter - 1
+	}
+	return n
+}
+
+// fail records a failed attempt by each of keys.  lt.mu must be held.
+func (lt *LoginThrottle) fail(now time.Time, keys ...throttleKey) {
+	for _, key := range keys {
+		rec := lt.record(key, now)
+		rec.failures++
+		rec.last = now
+
+		lockoutAfter := lt.lockoutAfter(key)
+		switch {
+		case lockoutAfter > 0 && rec.failures >= lockoutAfter:
+			rec.blockedUntil = now.Add(lt.cfg.LockoutDuration)
+			loginLockouts.Add(1)
+			log.Printf("locked out %s %q for %s after %d failed logins", key.kind, key.value, lt.cfg.LockoutDuration, rec.failures)
+		case rec.failures > lt.cfg.FreeAttempts:
+			rec.blockedUntil = now.Add(lt.delay(rec.failures - lt.cfg.FreeAttempts))
+		}
+	}
+}
+
+// delay returns the wait imposed after the nth failure beyond the free attempts.
+func (lt *LoginThrottle) delay(n int) time.Duration {
+	d := lt.cfg.BaseDelay
+	for i := 1; i < n && d < lt.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > lt.cfg.MaxDelay {
+		d = lt.cfg.MaxDelay
+	}
+	return d
+}
+
+// prune drops records that have been reset and aren't blocking anything, so that
+// guessing at many usernames doesn't grow the map forever.
+func (lt *LoginThrottle) prune(now time.Time) {
+	if now.Sub(lt.lastPrune) < throttlePruneInterval {
+		return
+	}
+	lt.lastPrune = now
+
+	for key, rec := range lt.records {
+		if rec.inFlight == 0 && now.Sub(rec.last) > lt.cfg.ResetAfter && !rec.blockedUntil.After(now) {
+			delete(lt.records, key)
+		}
+	}
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
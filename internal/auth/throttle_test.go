@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestThrottle() (*LoginThrottle, *time.Time) {
+	now := time.Now()
+	lt := NewLoginThrottle(ThrottleConfig{
+		FreeAttempts:        2,
+		BaseDelay:           time.Second,
+		MaxDelay:            4 * time.Second,
+		UserLockoutAfter:    6,
+		AddressLockoutAfter: 8,
+		LockoutDuration:     time.Hour,
+		ResetAfter:          time.Hour,
+	})
+	lt.now = func() time.Time { return now }
+	return lt, &now
+}
+
+func loginRequest(user, pass, addr string) *http.Request {
+	req := httptest.NewRequest("GET", "http://testing.com", nil)
+	req.SetBasicAuth(user, pass)
+	req.RemoteAddr = addr + ":1234"
+	return req
+}
+
+// blocked returns how long key must wait before its next attempt.
+func blocked(lt *LoginThrottle, key throttleKey) time.Duration {
+	rec, ok := lt.records[key]
+	if !ok || !rec.blockedUntil.After(lt.now()) {
+		return 0
+	}
+	return rec.blockedUntil.Sub(lt.now())
+}
+
+func TestLoginThrottleDelays(t *testing.T) {
+	lt, now := newTestThrottle()
+	sut := lt.Authenticator(BasicAuthenticator(NewMapValidator(map[string]string{"alice": "secret"}), StaticRoles()))
+
+	// free attempts, then doubling up to the maximum
+	expectedWaits := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+	for i, expected := range expectedWaits {
+		if _, err := sut(loginRequest("alice", "wrong", "10.0.0.1")); err != ErrUnauthenticated {
+			t.Fatalf("attempt %d: expected ErrUnauthenticated, got %v", i, err)
+		}
+
+		_, err := sut(loginRequest("alice", "secret", "10.0.0.1"))
+		if expected == 0 {
+			if err != nil {
+				t.Fatalf("attempt %d: expected no wait, got %v", i, err)
+			}
+			continue
+		}
+
+		throttled, ok := err.(ErrThrottled)
+		if !ok || throttled.RetryAfter != expected {
+			t.Fatalf("attempt %d: expected to wait %s, got %v", i, expected, err)
+		}
+		*now = now.Add(expected)
+	}
+
+	// a successful login forgives the username, but not the address
+	if _, err := sut(loginRequest("alice", "secret", "10.0.0.2")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if wait := blocked(lt, throttleKey{kind: "user", value: "alice"}); wait != 0 {
+		t.Errorf("expected username to be forgiven, got wait %s", wait)
+	}
+	sut(loginRequest("bob", "wrong", "10.0.0.1"))
+	if wait := blocked(lt, throttleKey{kind: "address", value: "10.0.0.1"}); wait != 4*time.Second {
+		t.Errorf("expected address to keep its failures, got wait %s", wait)
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	lt, now := newTestThrottle()
+	lt.cfg.FreeAttempts = 100
+	sut := lt.Authenticator(BasicAuthenticator(NewMapValidator(map[string]string{"alice": "secret", "bob": "secret"}), StaticRoles()))
+
+	for i := 0; i < 6; i++ {
+		sut(loginRequest("alice", "wrong", "10.0.0.1"))
+	}
+
+	if _, err := sut(loginRequest("alice", "secret", "10.0.0.2")); err == nil {
+		t.Error("expected locked out username to be refused from any address")
+	}
+	if _, err := sut(loginRequest("bob", "secret", "10.0.0.1")); err != nil {
+		t.Errorf("expected other users at the address to be allowed, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		sut(loginRequest("bob", "wrong", "10.0.0.1"))
+	}
+	if _, err := sut(loginRequest("carol", "secret", "10.0.0.1")); err == nil {
+		t.Error("expected locked out address to be refused for any username")
+	}
+
+	*now = now.Add(time.Hour)
+	if _, err := sut(loginRequest("alice", "secret", "10.0.0.3")); err != nil {
+		t.Errorf("expected lockout to expire, got %v", err)
+	}
+}
+
+func TestLoginThrottleConcurrentGuesses(t *testing.T) {
+	lt, _ := newTestThrottle()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	sut := lt.Authenticator(func(r *http.Request) (Principal, error) {
+		entered <- struct{}{}
+		<-release
+		return Principal{}, ErrUnauthenticated
+	})
+
+	// guess makes n attempts at once, returning how many were checked
+	guess := func(n int) int {
+		release = make(chan struct{})
+		results := make(chan error, n)
+		for i := 0; i < n; i++ {
+			go func() {
+				_, err := sut(loginRequest("alice", "wrong", "10.0.0.1"))
+				results <- err
+			}()
+		}
+
+		checked, throttled := 0, 0
+		for checked+throttled < n {
+			select {
+			case <-entered:
+				checked++
+			case err := <-results:
+				if _, ok := err.(ErrThrottled); !ok {
+					t.Fatalf("expected ErrThrottled, got %v", err)
+				}
+				throttled++
+			}
+		}
+		close(release)
+		for i := 0; i < checked; i++ {
+			if err := <-results; err != ErrUnauthenticated {
+				t.Fatalf("expected ErrUnauthenticated, got %v", err)
+			}
+		}
+		return checked
+	}
+
+	// once an attempt has failed, the remaining free attempt may be made alongside
+	// another, but after that they're made one at a time
+	if checked := guess(1); checked != 1 {
+		t.Errorf("expected the first attempt to be checked, got %d", checked)
+	}
+	if checked := guess(10); checked != 1 {
+		t.Errorf("expected the last free attempt to be checked, got %d", checked)
+	}
+	if checked := guess(10); checked != 1 {
+		t.Errorf("expected 1 attempt to be checked, got %d", checked)
+	}
+	if checked := guess(10); checked != 0 {
+		t.Errorf("expected every attempt to wait after the last failure, got %d", checked)
+	}
+	if rec := lt.records[throttleKey{kind: "user", value: "alice"}]; rec.failures != 3 || rec.inFlight != 0 {
+		t.Errorf("expected 3 failures and none in flight, got %+v", rec)
+	}
+}
+
+func TestLoginThrottleConcurrentLogins(t *testing.T) {
+	lt, _ := newTestThrottle()
+	validator := NewMapValidator(map[string]string{"alice": "secret"})
+	sut := lt.Authenticator(BasicAuthenticator(func(user, pass string) bool {
+		time.Sleep(20 * time.Millisecond)
+		return validator(user, pass)
+	}, StaticRoles()))
+
+	const n = 6
+	results := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := sut(loginRequest("alice", "secret", "10.0.0.1"))
+			results <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("expected every concurrent valid login to succeed, got %v", err)
+		}
+	}
+}
+
+func TestLoginThrottleIgnoresOtherCredentials(t *testing.T) {
+	lt, _ := newTestThrottle()
+	sut := lt.Authenticator(func(r *http.Request) (Principal, error) {
+		return Principal{}, ErrUnauthenticated
+	})
+
+	req := httptest.NewRequest("GET", "http://testing.com", nil)
+	for i := 0; i < 20; i++ {
+		sut(req)
+	}
+	if len(lt.records) != 0 {
+		t.Errorf("expected requests without basic credentials not to be tracked, got %v", lt.records)
+	}
+}
+
+func TestMiddlewareReportsThrottling(t *testing.T) {
+	sut := NewMiddleware(func(r *http.Request) (Principal, error) {
+		return Principal{}, ErrThrottled{RetryAfter: 1500 * time.Millisecond}
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	recorder := httptest.NewRecorder()
+	sut.ServeHTTP(recorder, httptest.NewRequest("GET", "http://testing.com", nil))
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected 429 with Retry-After 2, got %d %q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,162 @@
+// Package backup writes online backups of the database to a directory, on demand and on
+// a schedule, keeping a limited number of them.
+package backup
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph/model"
+)
+
+// Counters published at /debug/vars.
+var (
+	backupsWritten = expvar.NewInt("backups_written")
+	backupErrors   = expvar.NewInt("backup_errors")
+	lastBackup     = expvar.NewInt("backup_last_unix")
+)
+
+// Backups are named for the time they were started, so that they sort in that order.
+const (
+	filePrefix = "detect-"
+	fileSuffix = ".db"
+	timeFormat = "20060102T150405.000Z"
+)
+
+// Store is implemented by db.Client and db.MemoryStore.
+type Store interface {
+	Backup(ctx context.Context, path string) error
+}
+
+// Config controls where backups are written and how often.
+type Config struct {
+	Dir string
+	// Interval is how often backups are written on a schedule; zero only writes them on
+	// demand.
+	Interval time.Duration
+	// Keep is how many backups to keep, removing the oldest; zero keeps them all.
+	Keep int
+}
+
+// Scheduler writes backups of a Store.  Only one backup is written at a time.
+type Scheduler struct {
+	store Store
+	cfg   Config
+	now   func() time.Time
+
+	mu sync.Mutex
+}
+
+func NewScheduler(store Store, cfg Config) *Scheduler {
+	return &Scheduler{store: store, cfg: cfg, now: time.Now}
+}
+
+// Run writes a backup every interval until stop is closed.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if b, err := s.Backup(context.Background()); err != nil {
+				log.Printf("error writing scheduled backup: %s", err.Error())
+			} else {
+				log.Printf("wrote backup %s (%d bytes)", b.Path, b.Size)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Backup writes a new backup to the directory and removes the oldest ones beyond the
+// number to keep.
+func (s *Scheduler) Backup(ctx context.Context) (model.Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := s.write(ctx)
+	if err != nil {
+		backupErrors.Add(1)
+		return b, err
+	}
+	backupsWritten.Add(1)
+	lastBackup.Set(b.CreatedAt.Unix())
+
+	if err := s.rotate(); err != nil {
+		log.Printf("error removing old backups: %s", err.Error())
+	}
+	return b, nil
+}
+
+// write backs the store up to a temporary file, which is renamed once it's complete so
+// that a partial backup is never mistaken for one.
+func (s *Scheduler) write(ctx context.Context) (model.Backup, error) {
+	if err := os.MkdirAll(s.cfg.Dir, 0700); err != nil {
+		return model.Backup{}, fmt.Errorf("error creating backup directory: %w", err)
+	}
+
+	now := s.now().UTC()
+	path := filepath.Join(s.cfg.Dir, filePrefix+now.Format(timeFormat)+fileSuffix)
+	tmp := path + ".tmp"
+	if err := s.store.Backup(ctx, tmp); err != nil {
+		os.Remove(tmp)
+		return model.Backup{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return model.Backup{}, fmt.Errorf("error naming backup: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return model.Backup{}, fmt.Errorf("error reading backup: %w", err)
+	}
+	return model.Backup{Path: path, Size: int(info.Size()), CreatedAt: now}, nil
+}
+
+// rotate removes all but the newest backups to keep.
+func (s *Scheduler) rotate() error {
+	if s.cfg.Keep <= 0 {
+		return nil
+	}
+
+	backups, err := List(s.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	for len(backups) > s.cfg.Keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// List returns the paths of the backups in dir, oldest first.
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing backups: %w", err)
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
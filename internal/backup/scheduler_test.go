@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fileStore backs up by writing a small file, or fails with err.
+type fileStore struct {
+	err error
+}
+
+func (fs fileStore) Backup(ctx context.Context, path string) error {
+	if fs.err != nil {
+		ioutil.WriteFile(path, []byte("partial"), 0600)
+		return fs.err
+	}
+	return ioutil.WriteFile(path, []byte("backup"), 0600)
+}
+
+// newTestScheduler returns a Scheduler whose clock advances a second on each backup.
+func newTestScheduler(store Store, cfg Config) *Scheduler {
+	s := NewScheduler(store, cfg)
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return s
+}
+
+func TestBackup(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	sut := newTestScheduler(fileStore{}, Config{Dir: dir})
+
+	b, err := sut.Backup(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if b.Path != filepath.Join(dir, "detect-20210401T120001.000Z.db") || b.Size != len("backup") {
+		t.Errorf("unexpected backup %+v", b)
+	}
+	if _, err := os.Stat(b.Path); err != nil {
+		t.Errorf("expected the backup to be written: %s", err.Error())
+	}
+}
+
+func TestBackupFailureLeavesNothing(t *testing.T) {
+	dir := t.TempDir()
+	sut := newTestScheduler(fileStore{err: fmt.Errorf("some error")}, Config{Dir: dir})
+
+	errors := backupErrors.Value()
+	if _, err := sut.Backup(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if backupErrors.Value()-errors != 1 {
+		t.Error("expected the error to be counted")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("expected the partial backup to be removed, found %d files", len(entries))
+	}
+}
+
+func TestBackupKeepsNewest(t *testing.T) {
+	testCases := []struct {
+		name     string
+		keep     int
+		expected int
+	}{
+		{"keep two", 2, 2},
+		{"keep all", 0, 4},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// files that aren't backups are left alone
+			if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0600); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			sut := newTestScheduler(fileStore{}, Config{Dir: dir, Keep: test.keep})
+			var last string
+			for i := 0; i < 4; i++ {
+				b, err := sut.Backup(context.Background())
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err.Error())
+				}
+				last = b.Path
+			}
+
+			backups, err := List(dir)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if len(backups) != test.expected || backups[len(backups)-1] != last {
+				t.Errorf("expected the newest %d backups, got %v", test.expected, backups)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+				t.Error("expected other files to be kept")
+			}
+		})
+	}
+}
+
+func TestRunStopsWhenClosed(t *testing.T) {
+	sut := NewScheduler(fileStore{}, Config{Dir: t.TempDir(), Interval: time.Hour})
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		sut.Run(stop)
+		close(done)
+	}()
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to return once stop was closed")
+	}
+}
@@ -0,0 +1,271 @@
+// Package bulk exports a tenant's IP details as a CSV document or JSON lines, and imports
+// them back in the same formats.
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/dnsbl"
+)
+
+// DefaultBatchSize is the number of records imported in each transaction by default.
+const DefaultBatchSize = 1000
+
+// maxClockSkew is how far in the future an imported record's times may be, to allow for
+// data exported from a server whose clock is slightly ahead.
+const maxClockSkew = time.Minute
+
+// columns heads an exported CSV document.  Only ip_address is required on import, and
+// the uuid column is ignored since imported records are given new ids.
+var columns = []string{"ip_address", "response_code", "created_at", "updated_at", "uuid"}
+
+// Exporter is implemented by db.Client and db.MemoryStore.
+type Exporter interface {
+	EachIPDetails(ctx context.Context, tenant string, filter model.ExportFilter, fn func(*model.IPDetails) error) error
+}
+
+// Importer is implemented by db.Client and db.MemoryStore.
+type Importer interface {
+	ImportIPDetails(ctx context.Context, tenant string, records []model.IPDetails, policy model.ConflictPolicy) (model.ImportResult, error)
+}
+
+// RecordError reports a record that couldn't be read.  Records are numbered from 1, and a
+// CSV document's header is record 0.
+type RecordError struct {
+	Record int
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	if e.Record == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("record %d: %s", e.Record, e.Err.Error())
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Export writes tenant's records matching filter to w in format, returning how many were
+// written.
+func Export(ctx context.Context, w io.Writer, store Exporter, tenant string, format model.DataFormat, filter model.ExportFilter) (int, error) {
+	buf := bufio.NewWriter(w)
+	var write func(d *model.IPDetails) error
+	var flush func() error
+	switch format {
+	case model.DataFormatCsv:
+		cw := csv.NewWriter(buf)
+		if err := cw.Write(columns); err != nil {
+			return 0, err
+		}
+		write = func(d *model.IPDetails) error {
+			return cw.Write([]string{d.IPAddress, d.ResponseCode, formatTime(d.CreatedAt), formatTime(d.UpdatedAt), d.UUID})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case model.DataFormatJSONLines:
+		// Encode ends each value with a newline
+		enc := json.NewEncoder(buf)
+		write = func(d *model.IPDetails) error { return enc.Encode(d) }
+		flush = func() error { return nil }
+	default:
+		return 0, fmt.Errorf("unknown format %q", format)
+	}
+
+	n := 0
+	err := store.EachIPDetails(ctx, tenant, filter, func(d *model.IPDetails) error {
+		n++
+		return write(d)
+	})
+	if err != nil {
+		return n, err
+	}
+	if err := flush(); err != nil {
+		return n, fmt.Errorf("error writing export: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return n, fmt.Errorf("error writing export: %w", err)
+	}
+	return n, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Import reads records in format from r and stores them for tenant, batchSize at a time
+// in a transaction each.  Reading stops at the first record that can't be read, but the
+// batches stored before it are kept; since records are matched by address, importing the
+// corrected data again with ConflictPolicySkip stores only the remainder.
+func Import(ctx context.Context, store Importer, tenant string, r io.Reader, format model.DataFormat, policy model.ConflictPolicy, batchSize int) (model.ImportResult, error) {
+	var res model.ImportResult
+	if batchSize < 1 {
+		batchSize = DefaultBatchSize
+	}
+
+	reader, err := NewReader(r, format)
+	if err != nil {
+		return res, err
+	}
+
+	batch := make([]model.IPDetails, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		stored, err := store.ImportIPDetails(ctx, tenant, batch, policy)
+		if err != nil {
+			return err
+		}
+		res.Imported += stored.Imported
+		res.Skipped += stored.Skipped
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		d, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+
+		batch = append(batch, d)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, flush()
+}
+
+// Reader reads records from a CSV document or JSON lines, checking that each has a valid
+// IPv4 address, a response code of the form the DNSBL client returns, and no times in the
+// future.
+type Reader struct {
+	record int
+
+	csv     *csv.Reader
+	columns map[string]int
+
+	json *json.Decoder
+}
+
+// NewReader returns a Reader for records in format.  A CSV document's header is read
+// immediately.
+func NewReader(r io.Reader, format model.DataFormat) (*Reader, error) {
+	switch format {
+	case model.DataFormatCsv:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, &RecordError{Err: errors.New("CSV document has no header")}
+		}
+		if err != nil {
+			return nil, &RecordError{Err: fmt.Errorf("error reading CSV header: %w", err)}
+		}
+
+		index := map[string]int{}
+		for i, name := range header {
+			index[name] = i
+		}
+		if _, ok := index["ip_address"]; !ok {
+			return nil, &RecordError{Err: errors.New("CSV header has no ip_address column")}
+		}
+		return &Reader{csv: cr, columns: index}, nil
+	case model.DataFormatJSONLines:
+		return &Reader{json: json.NewDecoder(r)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Read returns the next record, or io.EOF when there are no more.  Other errors are
+// *RecordErrors.
+func (r *Reader) Read() (model.IPDetails, error) {
+	var d model.IPDetails
+	var err error
+	if r.csv != nil {
+		d, err = r.readCSV()
+	} else {
+		err = r.json.Decode(&d)
+	}
+	if errors.Is(err, io.EOF) {
+		return d, io.EOF
+	}
+
+	r.record++
+	if err == nil {
+		err = validate(d)
+	}
+	if err != nil {
+		return d, &RecordError{Record: r.record, Err: err}
+	}
+	return d, nil
+}
+
+func validate(d model.IPDetails) error {
+	if err := dnsbl.ValidateIPv4(d.IPAddress); err != nil {
+		return err
+	}
+	if err := dnsbl.ValidateResponseCode(d.ResponseCode); err != nil {
+		return err
+	}
+	latest := time.Now().Add(maxClockSkew)
+	if d.CreatedAt.After(latest) {
+		return fmt.Errorf("created_at %s is in the future", formatTime(d.CreatedAt))
+	}
+	if d.UpdatedAt.After(latest) {
+		return fmt.Errorf("updated_at %s is in the future", formatTime(d.UpdatedAt))
+	}
+	return nil
+}
+
+func (r *Reader) readCSV() (model.IPDetails, error) {
+	var d model.IPDetails
+	fields, err := r.csv.Read()
+	if err != nil {
+		return d, err
+	}
+
+	field := func(name string) string {
+		if i, ok := r.columns[name]; ok {
+			return fields[i]
+		}
+		return ""
+	}
+	d.IPAddress = field("ip_address")
+	d.ResponseCode = field("response_code")
+	if d.CreatedAt, err = parseTime(field("created_at")); err != nil {
+		return d, fmt.Errorf("invalid created_at: %w", err)
+	}
+	if d.UpdatedAt, err = parseTime(field("updated_at")); err != nil {
+		return d, fmt.Errorf("invalid updated_at: %w", err)
+	}
+	return d, nil
+}
+
+// parseTime parses an RFC 3339 time, or returns the zero time for an empty field.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
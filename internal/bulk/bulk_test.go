@@ -0,0 +1,218 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/db"
+)
+
+var (
+	jan = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb = time.Date(2021, 2, 1, 12, 30, 0, 500, time.UTC)
+)
+
+func seed(t *testing.T) *db.MemoryStore {
+	t.Helper()
+	s := db.NewMemoryStore()
+	_, err := s.ImportIPDetails(context.Background(), "red", []model.IPDetails{
+		{IPAddress: "203.0.113.20", CreatedAt: jan, UpdatedAt: feb},
+		{IPAddress: "203.0.113.3", ResponseCode: "127.0.0.2,bl.spamcop.net:127.0.0.2", CreatedAt: jan, UpdatedAt: jan},
+	}, model.ConflictPolicySkip)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return s
+}
+
+func TestExportCSV(t *testing.T) {
+	var b bytes.Buffer
+	n, err := Export(context.Background(), &b, seed(t), "red", model.DataFormatCsv, model.ExportFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error exporting: %s", err.Error())
+	}
+	if n != 2 {
+		t.Errorf("expected 2 records to be exported, got %d", n)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	expected := []string{
+		"ip_address,response_code,created_at,updated_at,uuid",
+		`203.0.113.3,"127.0.0.2,bl.spamcop.net:127.0.0.2",2021-01-01T00:00:00Z,2021-01-01T00:00:00Z,`,
+		"203.0.113.20,,2021-01-01T00:00:00Z,2021-02-01T12:30:00.0000005Z,",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %q", len(expected), lines)
+	}
+	for i, line := range lines {
+		// the uuid is assigned on import, so only check that there is one
+		if i > 0 && strings.HasSuffix(line, ",") {
+			t.Errorf("expected line %d to end with a uuid, got %q", i, line)
+		}
+		if !strings.HasPrefix(line, expected[i]) {
+			t.Errorf("expected line %d to start with %q, got %q", i, expected[i], line)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range model.AllDataFormat {
+		t.Run(format.String(), func(t *testing.T) {
+			ctx := context.Background()
+			src := seed(t)
+			var b bytes.Buffer
+			if _, err := Export(ctx, &b, src, "red", format, model.ExportFilter{}); err != nil {
+				t.Fatalf("unexpected error exporting: %s", err.Error())
+			}
+
+			dst := db.NewMemoryStore()
+			res, err := Import(ctx, dst, "blue", &b, format, model.ConflictPolicySkip, 1)
+			if err != nil {
+				t.Fatalf("unexpected error importing: %s", err.Error())
+			}
+			if res != (model.ImportResult{Imported: 2}) {
+				t.Errorf("expected 2 records to be imported, got %+v", res)
+			}
+
+			if exported, imported := records(t, src, "red"), records(t, dst, "blue"); !reflect.DeepEqual(exported, imported) {
+				t.Errorf("expected %+v to be imported, got %+v", exported, imported)
+			}
+		})
+	}
+}
+
+// records returns tenant's records without their ids, which differ between stores.
+func records(t *testing.T, s Exporter, tenant string) []model.IPDetails {
+	t.Helper()
+	var res []model.IPDetails
+	err := s.EachIPDetails(context.Background(), tenant, model.ExportFilter{}, func(d *model.IPDetails) error {
+		d.UUID = ""
+		res = append(res, *d)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return res
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   model.DataFormat
+		data     string
+		expected []model.IPDetails
+		errKey   string
+	}{
+		{
+			"csv with only addresses",
+			model.DataFormatCsv,
+			"ip_address\n1.2.3.4\n1.2.3.5\n",
+			[]model.IPDetails{{IPAddress: "1.2.3.4"}, {IPAddress: "1.2.3.5"}},
+			"",
+		},
+		{
+			"csv columns in any order",
+			model.DataFormatCsv,
+			"updated_at,note,ip_address,response_code\n2021-01-01T00:00:00Z,x,1.2.3.4,127.0.0.2\n",
+			[]model.IPDetails{{IPAddress: "1.2.3.4", ResponseCode: "127.0.0.2", UpdatedAt: jan}},
+			"",
+		},
+		{"empty csv", model.DataFormatCsv, "", nil, "no header"},
+		{"csv without addresses", model.DataFormatCsv, "response_code\n127.0.0.2\n", nil, "no ip_address column"},
+		{"invalid address", model.DataFormatCsv, "ip_address\n1.2.3.4\n1.2.3\n", []model.IPDetails{{IPAddress: "1.2.3.4"}}, "record 2: 1.2.3 is not a valid IPv4 address"},
+		{"invalid time", model.DataFormatCsv, "ip_address,updated_at\n1.2.3.4,yesterday\n", nil, "record 1: invalid updated_at"},
+		{"wrong number of fields", model.DataFormatCsv, "ip_address,response_code\n1.2.3.4\n", nil, "record 1:"},
+		{"invalid response code", model.DataFormatCsv, "ip_address,response_code\n1.2.3.4,clean\n", nil, "record 1: invalid response code"},
+		{"future update", model.DataFormatCsv, "ip_address,updated_at\n1.2.3.4,2999-01-01T00:00:00Z\n", nil, "record 1: updated_at 2999-01-01T00:00:00Z is in the future"},
+		{"future creation", model.DataFormatJSONLines, `{"ip_address":"1.2.3.4","created_at":"2999-01-01T00:00:00Z"}`, nil, "record 1: created_at"},
+		{
+			"json lines",
+			model.DataFormatJSONLines,
+			`{"ip_address":"1.2.3.4","updated_at":"2021-01-01T00:00:00Z"}` + "\n" + `{"ip_address":"1.2.3.5","response_code":"127.0.0.2"}` + "\n",
+			[]model.IPDetails{{IPAddress: "1.2.3.4", UpdatedAt: jan}, {IPAddress: "1.2.3.5", ResponseCode: "127.0.0.2"}},
+			"",
+		},
+		{"empty json lines", model.DataFormatJSONLines, "", nil, ""},
+		{"invalid json", model.DataFormatJSONLines, `{"ip_address":"1.2.3.4"}` + "\n{\n", []model.IPDetails{{IPAddress: "1.2.3.4"}}, "record 2:"},
+		{"json without an address", model.DataFormatJSONLines, `{"response_code":"127.0.0.2"}`, nil, "record 1: "},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var read []model.IPDetails
+			r, err := NewReader(strings.NewReader(test.data), test.format)
+			for err == nil {
+				var d model.IPDetails
+				if d, err = r.Read(); err == nil {
+					read = append(read, d)
+				}
+			}
+
+			if test.errKey == "" && !errors.Is(err, io.EOF) {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+			if test.errKey != "" && !strings.Contains(err.Error(), test.errKey) {
+				t.Errorf("expected error containing %q, got %q", test.errKey, err.Error())
+			}
+			if !reflect.DeepEqual(read, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, read)
+			}
+		})
+	}
+}
+
+type batchRecorder struct {
+	batches []int
+	err     error
+}
+
+func (b *batchRecorder) ImportIPDetails(ctx context.Context, tenant string, records []model.IPDetails, policy model.ConflictPolicy) (model.ImportResult, error) {
+	if b.err != nil {
+		return model.ImportResult{}, b.err
+	}
+	b.batches = append(b.batches, len(records))
+	return model.ImportResult{Imported: len(records)}, nil
+}
+
+func TestImportBatches(t *testing.T) {
+	data := "ip_address\n1.2.3.1\n1.2.3.2\n1.2.3.3\n1.2.3.4\n1.2.3.5\n"
+
+	t.Run("in batches", func(t *testing.T) {
+		b := &batchRecorder{}
+		res, err := Import(context.Background(), b, "red", strings.NewReader(data), model.DataFormatCsv, model.ConflictPolicySkip, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if !reflect.DeepEqual(b.batches, []int{2, 2, 1}) || res.Imported != 5 {
+			t.Errorf("expected batches of 2, 2 and 1, got %v and %+v", b.batches, res)
+		}
+	})
+
+	t.Run("keeps batches before a bad record", func(t *testing.T) {
+		b := &batchRecorder{}
+		res, err := Import(context.Background(), b, "red", strings.NewReader(data+"bogus\n"), model.DataFormatCsv, model.ConflictPolicySkip, 2)
+		var badRecord *RecordError
+		if !errors.As(err, &badRecord) || badRecord.Record != 6 {
+			t.Fatalf("expected record 6 to be rejected, got %v", err)
+		}
+		if !reflect.DeepEqual(b.batches, []int{2, 2}) || res.Imported != 4 {
+			t.Errorf("expected the first two batches to be imported, got %v and %+v", b.batches, res)
+		}
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		b := &batchRecorder{err: errors.New("disk full")}
+		_, err := Import(context.Background(), b, "red", strings.NewReader(data), model.DataFormatCsv, model.ConflictPolicySkip, 2)
+		if err == nil || err.Error() != "disk full" {
+			t.Errorf("expected the store's error, got %v", err)
+		}
+	})
+}
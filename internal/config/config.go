@@ -0,0 +1,268 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds every setting the server needs to start.  It is built from the defaults,
+// then a YAML file, then environment variables and finally command line flags, each
+// overriding the last.
+type Config struct {
+	HTTP      ListenConfig    `yaml:"http"`
+	GRPC      ListenConfig    `yaml:"grpc"`
+	TLS       TLSConfig       `yaml:"tls"`
+	Database  DatabaseConfig  `yaml:"database"`
+	DNSBL     DNSBLConfig     `yaml:"dnsbl"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Query     QueryConfig     `yaml:"query"`
+	Retention RetentionConfig `yaml:"retention"`
+	Backup    BackupConfig    `yaml:"backup"`
+}
+
+type ListenConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuth is "optional" or "require".
+	ClientAuth        string `yaml:"client_auth"`
+	ClientCertMapping string `yaml:"client_cert_mapping"`
+}
+
+// Enabled reports whether the servers should use TLS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+type DatabaseConfig struct {
+	// Driver is "sqlite", using the file at Path, or "postgres", using the server at URL.
+	Driver string `yaml:"driver"`
+	Path   string `yaml:"path"`
+	URL    string `yaml:"url"`
+}
+
+type DNSBLConfig struct {
+	// Zones are the DNSBLs each address is checked against.
+	Zones []string `yaml:"zones"`
+	// Resolvers are the "host:port" addresses of DNS servers to query, or empty for the
+	// system resolver.
+	Resolvers []string      `yaml:"resolvers"`
+	Timeout   time.Duration `yaml:"timeout"`
+	// Workers limits how many lookups run at once.
+	Workers int `yaml:"workers"`
+}
+
+type AuthConfig struct {
+	CredentialsFile string    `yaml:"credentials_file"`
+	RolesFile       string    `yaml:"roles_file"`
+	TenantsFile     string    `yaml:"tenants_file"`
+	RateLimitsFile  string    `yaml:"rate_limits_file"`
+	JWT             JWTConfig `yaml:"jwt"`
+}
+
+type JWTConfig struct {
+	// JWKS is the URL or path of the key set; SSO tokens are only accepted when it's set.
+	JWKS        string `yaml:"jwks"`
+	Issuer      string `yaml:"issuer"`
+	Audience    string `yaml:"audience"`
+	NameClaim   string `yaml:"name_claim"`
+	RolesClaim  string `yaml:"roles_claim"`
+	TenantClaim string `yaml:"tenant_claim"`
+}
+
+type QueryConfig struct {
+	ComplexityLimit  int    `yaml:"complexity_limit"`
+	DepthLimit       int    `yaml:"depth_limit"`
+	PersistedQueries string `yaml:"persisted_queries"`
+}
+
+type RetentionConfig struct {
+	// Jobs is how long finished jobs can be checked on.
+	Jobs time.Duration `yaml:"jobs"`
+	// Clean and Listed are how long results are kept after they were last updated,
+	// AuditLog how long audit entries are kept, and History how long lookups are kept in
+	// the lookup history.  Zero keeps them forever.
+	Clean    time.Duration `yaml:"clean"`
+	Listed   time.Duration `yaml:"listed"`
+	AuditLog time.Duration `yaml:"audit_log"`
+	History  time.Duration `yaml:"history"`
+	// PruneInterval is how often expired records are removed, and CompactInterval how
+	// often a SQLite database is vacuumed afterwards; zero never vacuums it.
+	PruneInterval   time.Duration `yaml:"prune_interval"`
+	CompactInterval time.Duration `yaml:"compact_interval"`
+	// DryRun logs what would be removed instead of removing it.
+	DryRun bool `yaml:"dry_run"`
+}
+
+// Prunes reports whether any records expire.
+func (c RetentionConfig) Prunes() bool {
+	return c.Clean > 0 || c.Listed > 0 || c.AuditLog > 0 || c.History > 0
+}
+
+type BackupConfig struct {
+	// Dir is where backups are written; backups are disabled unless it's set.
+	Dir string `yaml:"dir"`
+	// Interval is how often backups are written on a schedule; zero only writes them
+	// when an admin asks.
+	Interval time.Duration `yaml:"interval"`
+	// Keep is how many backups to keep; zero keeps them all.
+	Keep int `yaml:"keep"`
+}
+
+// Default returns the configuration used when nothing else is given.
+func Default() Config {
+	return Config{
+		HTTP:     ListenConfig{Addr: ":8080"},
+		GRPC:     ListenConfig{Addr: ":9090"},
+		TLS:      TLSConfig{ClientAuth: "optional"},
+		Database: DatabaseConfig{Driver: "sqlite", Path: "./data.db"},
+		DNSBL: DNSBLConfig{
+			Zones:     []string{"zen.spamhaus.org"},
+			Resolvers: []string{},
+			Timeout:   5 * time.Second,
+			Workers:   16,
+		},
+		Auth: AuthConfig{
+			CredentialsFile: "./users.htpasswd",
+			RolesFile:       "./users.roles",
+			TenantsFile:     "./users.tenants",
+		},
+		Query: QueryConfig{
+			ComplexityLimit: 1000,
+			DepthLimit:      10,
+		},
+		Retention: RetentionConfig{
+			Jobs:            24 * time.Hour,
+			PruneInterval:   time.Hour,
+			CompactInterval: 24 * time.Hour,
+		},
+		Backup: BackupConfig{Keep: 7},
+	}
+}
+
+// Load returns the defaults overridden by the YAML file at path, if path isn't empty,
+// and then by environment variables found with lookupEnv.  The result isn't validated.
+func Load(path string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("error reading config file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(contents, &cfg); err != nil {
+			return cfg, fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+	}
+
+	for _, s := range settings {
+		for _, env := range s.env {
+			if v, ok := lookupEnv(env); ok && v != "" {
+				if err := s.set(&cfg, v); err != nil {
+					return cfg, fmt.Errorf("%s: %w", env, err)
+				}
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration, reporting every problem found.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(validAddr(c.HTTP.Addr), "http.addr must be host:port, got %q", c.HTTP.Addr)
+	check(validAddr(c.GRPC.Addr), "grpc.addr must be host:port, got %q", c.GRPC.Addr)
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.cert_file and tls.key_file must be set together")
+	check(c.TLS.ClientCAFile == "" || c.TLS.Enabled(), "tls.client_ca_file requires tls.cert_file and tls.key_file")
+	check(c.TLS.ClientAuth == "optional" || c.TLS.ClientAuth == "require", "tls.client_auth must be \"optional\" or \"require\", got %q", c.TLS.ClientAuth)
+	check(c.TLS.ClientCertMapping == "" || c.TLS.ClientCAFile != "", "tls.client_cert_mapping requires tls.client_ca_file")
+
+	switch c.Database.Driver {
+	case "sqlite":
+		check(c.Database.Path != "", "database.path must be set")
+	case "postgres":
+		check(c.Database.URL != "", "database.url must be set when database.driver is \"postgres\"")
+	default:
+		check(false, "database.driver must be \"sqlite\" or \"postgres\", got %q", c.Database.Driver)
+	}
+
+	check(len(c.DNSBL.Zones) > 0, "dnsbl.zones must list at least one zone")
+	for _, zone := range c.DNSBL.Zones {
+		check(zone != "" && !strings.ContainsAny(zone, " /:") && !strings.HasPrefix(zone, "."), "dnsbl.zones: invalid zone %q", zone)
+	}
+	for _, resolver := range c.DNSBL.Resolvers {
+		check(validAddr(resolver), "dnsbl.resolvers must be host:port, got %q", resolver)
+	}
+	check(c.DNSBL.Timeout > 0, "dnsbl.timeout must be positive")
+	check(c.DNSBL.Workers > 0, "dnsbl.workers must be positive")
+
+	check(c.Auth.CredentialsFile != "", "auth.credentials_file must be set")
+	check(c.Auth.RolesFile != "", "auth.roles_file must be set")
+	check(c.Auth.TenantsFile != "", "auth.tenants_file must be set")
+	if c.Auth.JWT.JWKS != "" {
+		check(c.Auth.JWT.Issuer != "" && c.Auth.JWT.Audience != "", "auth.jwt.issuer and auth.jwt.audience must be set when auth.jwt.jwks is")
+	}
+
+	check(c.Query.ComplexityLimit > 0, "query.complexity_limit must be positive")
+	check(c.Query.DepthLimit > 0, "query.depth_limit must be positive")
+
+	check(c.Retention.Jobs > 0, "retention.jobs must be positive")
+	check(c.Retention.Clean >= 0 && c.Retention.Listed >= 0 && c.Retention.AuditLog >= 0 && c.Retention.History >= 0,
+		"retention.clean, retention.listed, retention.audit_log and retention.history must not be negative")
+	check(c.Retention.PruneInterval > 0, "retention.prune_interval must be positive")
+	check(c.Retention.CompactInterval >= 0, "retention.compact_interval must not be negative")
+
+	check(c.Backup.Dir == "" || c.Database.Driver == "sqlite", "backup.dir requires database.driver \"sqlite\"")
+	// db.MemoryPath, which isn't imported so that config stays free of storage packages
+	check(c.Backup.Dir == "" || c.Database.Path != ":memory:", "backup.dir can't be used with the in-memory store (database.path \":memory:\")")
+	check(c.Backup.Interval >= 0, "backup.interval must not be negative")
+	check(c.Backup.Interval == 0 || c.Backup.Dir != "", "backup.interval requires backup.dir")
+	check(c.Backup.Keep >= 0, "backup.keep must not be negative")
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// YAML returns the configuration in the format of a config file, with any password in
+// database.url hidden so that the output can be shared.
+func (c Config) YAML() ([]byte, error) {
+	c.Database.URL = redactPassword(c.Database.URL)
+	return yaml.Marshal(c)
+}
+
+// dsnPassword matches a password given as a "key=value" connection parameter, either in
+// a URL's query or a PostgreSQL connection string, where it may be quoted.
+var dsnPassword = regexp.MustCompile(`(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|[^\s&']+)`)
+
+// redactPassword replaces the password in a connection URL or string with "xxxxx".
+func redactPassword(s string) string {
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		s = u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(s, "${1}xxxxx")
+}
+
+func validAddr(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port != ""
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func envMap(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "detect.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("expected the defaults to be valid, got %s", err.Error())
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+http:
+  addr: ":8000"
+grpc:
+  addr: ":9000"
+database:
+  path: /var/lib/detect/data.db
+dnsbl:
+  zones: [zen.spamhaus.org, bl.spamcop.net]
+  timeout: 2s
+`)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := NewFlags(fs)
+	if err := fs.Parse([]string{"-config", path, "-grpc-addr", "127.0.0.1:9999", "-dnsbl-workers", "4"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cfg, err := flags.Config(envMap(map[string]string{
+		"PORT":          "8081",
+		"GRPC_ADDR":     ":9001",
+		"DNSBL_TIMEOUT": "3s",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	testCases := []struct {
+		name     string
+		actual   interface{}
+		expected interface{}
+	}{
+		{"env overrides file", cfg.HTTP.Addr, ":8081"},
+		{"flag overrides env", cfg.GRPC.Addr, "127.0.0.1:9999"},
+		{"file overrides default", cfg.Database.Path, "/var/lib/detect/data.db"},
+		{"file lists", cfg.DNSBL.Zones, []string{"zen.spamhaus.org", "bl.spamcop.net"}},
+		{"env duration", cfg.DNSBL.Timeout, 3 * time.Second},
+		{"flag int", cfg.DNSBL.Workers, 4},
+		{"default kept", cfg.Query.DepthLimit, 10},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if !reflect.DeepEqual(test.actual, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, test.actual)
+			}
+		})
+	}
+}
+
+func TestConfigFileFromEnv(t *testing.T) {
+	path := writeConfig(t, "database:\n  path: from-file.db\n")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := NewFlags(fs)
+	fs.Parse(nil)
+
+	cfg, err := flags.Config(envMap(map[string]string{"CONFIG_FILE": path}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if cfg.Database.Path != "from-file.db" {
+		t.Errorf("expected CONFIG_FILE to be loaded, got %q", cfg.Database.Path)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		file string
+		env  map[string]string
+	}{
+		{"unknown key", "databse:\n  path: x.db\n", nil},
+		{"wrong type", "dnsbl:\n  workers: lots\n", nil},
+		{"bad env int", "", map[string]string{"QUERY_DEPTH_LIMIT": "deep"}},
+		{"bad env duration", "", map[string]string{"JOB_RETENTION": "1 day"}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			path := ""
+			if test.file != "" {
+				path = writeConfig(t, test.file)
+			}
+			if _, err := Load(path, envMap(test.env)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		modify  func(c *Config)
+		problem string
+	}{
+		{"bad address", func(c *Config) { c.HTTP.Addr = "8080" }, "http.addr"},
+		{"unknown driver", func(c *Config) { c.Database.Driver = "mysql" }, "database.driver"},
+		{"postgres without url", func(c *Config) { c.Database.Driver = "postgres" }, "database.url"},
+		{"cert without key", func(c *Config) { c.TLS.CertFile = "cert.pem" }, "tls.cert_file"},
+		{"client auth mode", func(c *Config) { c.TLS.ClientAuth = "sometimes" }, "tls.client_auth"},
+		{"mapping without ca", func(c *Config) { c.TLS.ClientCertMapping = "clients" }, "tls.client_cert_mapping"},
+		{"no zones", func(c *Config) { c.DNSBL.Zones = nil }, "dnsbl.zones"},
+		{"bad resolver", func(c *Config) { c.DNSBL.Resolvers = []string{"1.1.1.1"} }, "dnsbl.resolvers"},
+		{"jwks without issuer", func(c *Config) { c.Auth.JWT.JWKS = "https://sso/jwks" }, "auth.jwt.issuer"},
+		{"no retention", func(c *Config) { c.Retention.Jobs = 0 }, "retention.jobs"},
+		{"negative retention", func(c *Config) { c.Retention.Listed = -time.Hour }, "retention.listed"},
+		{"no prune interval", func(c *Config) { c.Retention.PruneInterval = 0 }, "retention.prune_interval"},
+		{"backup interval without dir", func(c *Config) { c.Backup.Interval = time.Hour }, "backup.interval"},
+		{"backup of postgres", func(c *Config) {
+			c.Database.Driver, c.Database.URL, c.Backup.Dir = "postgres", "postgres://db", "/backups"
+		}, "backup.dir"},
+		{"backup of memory", func(c *Config) { c.Database.Path, c.Backup.Dir = ":memory:", "/backups" }, "backup.dir can't be used with the in-memory store"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Default()
+			test.modify(&cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), test.problem) {
+				t.Errorf("expected a problem with %s, got %v", test.problem, err)
+			}
+		})
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	cfg := Default()
+	cfg.DNSBL.Resolvers = []string{"1.1.1.1:53"}
+	cfg.Retention.Jobs = 90 * time.Minute
+
+	out, err := cfg.YAML()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	loaded, err := Load(writeConfig(t, string(out)), envMap(nil))
+	if err != nil {
+		t.Fatalf("unexpected error loading printed config: %s", err.Error())
+	}
+	if !reflect.DeepEqual(loaded, cfg) {
+		t.Errorf("expected %+v, got %+v", cfg, loaded)
+	}
+}
+
+func TestYAMLRedactsPasswords(t *testing.T) {
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{"postgres://detect:s3cret@db:5432/detect?sslmode=require", "postgres://detect:xxxxx@db:5432/detect?sslmode=require"},
+		{"postgres://detect@db/detect?password=s3cret&sslmode=require", "postgres://detect@db/detect?password=xxxxx&sslmode=require"},
+		{"host=db user=detect password='s3 cret' dbname=detect", "host=db user=detect password=xxxxx dbname=detect"},
+		{"host=db password=s3cret", "host=db password=xxxxx"},
+		{"postgres://db/detect", "postgres://db/detect"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.url, func(t *testing.T) {
+			cfg := Default()
+			cfg.Database.Driver, cfg.Database.URL = "postgres", test.url
+
+			out, err := cfg.YAML()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			var printed Config
+			if err := yaml.Unmarshal(out, &printed); err != nil {
+				t.Fatalf("unexpected error reading printed config: %s", err.Error())
+			}
+			if printed.Database.URL != test.expected {
+				t.Errorf("expected url %s, got %s", test.expected, printed.Database.URL)
+			}
+			if cfg.Database.URL != test.url {
+				t.Error("expected the config itself to keep the password")
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a value that can be overridden by environment variables and a flag.
+type setting struct {
+	name  string   // the key in the config file, which also names the flag
+	env   []string // later variables take precedence
+	usage string
+	set   func(c *Config, v string) error
+}
+
+// flagName turns a setting's name into a flag, e.g. "auth.jwt.name_claim" into
+// "auth-jwt-name-claim".
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.name)
+}
+
+var settings = []setting{
+	{"http.addr", []string{"PORT", "HTTP_ADDR"}, "address to serve GraphQL and REST on (PORT sets the port alone)", setAddr(func(c *Config) *string { return &c.HTTP.Addr })},
+	{"grpc.addr", []string{"GRPC_PORT", "GRPC_ADDR"}, "address to serve gRPC on (GRPC_PORT sets the port alone)", setAddr(func(c *Config) *string { return &c.GRPC.Addr })},
+
+	{"tls.cert_file", []string{"TLS_CERT_FILE"}, "PEM certificate to serve TLS with", setString(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"tls.key_file", []string{"TLS_KEY_FILE"}, "PEM private key for tls.cert_file", setString(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"tls.client_ca_file", []string{"TLS_CLIENT_CA_FILE"}, "PEM bundle of CAs issuing client certificates", setString(func(c *Config) *string { return &c.TLS.ClientCAFile })},
+	{"tls.client_auth", []string{"TLS_CLIENT_AUTH"}, "whether client certificates are \"optional\" or \"require\"d", setString(func(c *Config) *string { return &c.TLS.ClientAuth })},
+	{"tls.client_cert_mapping", []string{"CLIENT_CERT_MAPPING"}, "file mapping client certificates to principals", setString(func(c *Config) *string { return &c.TLS.ClientCertMapping })},
+
+	{"database.driver", []string{"DB_DRIVER"}, "\"sqlite\" or \"postgres\"", setString(func(c *Config) *string { return &c.Database.Driver })},
+	{"database.path", []string{"DB_PATH"}, "SQLite database file, or :memory: to keep data in memory", setString(func(c *Config) *string { return &c.Database.Path })},
+	{"database.url", []string{"DATABASE_URL"}, "PostgreSQL connection URL", setString(func(c *Config) *string { return &c.Database.URL })},
+
+	{"dnsbl.zones", []string{"DNSBL_ZONES"}, "comma separated DNSBL zones to check addresses against", setList(func(c *Config) *[]string { return &c.DNSBL.Zones })},
+	{"dnsbl.resolvers", []string{"DNSBL_RESOLVERS"}, "comma separated host:port DNS servers, or empty for the system resolver", setList(func(c *Config) *[]string { return &c.DNSBL.Resolvers })},
+	{"dnsbl.timeout", []string{"DNSBL_TIMEOUT"}, "timeout for each DNSBL query", setDuration(func(c *Config) *time.Duration { return &c.DNSBL.Timeout })},
+	{"dnsbl.workers", []string{"DNSBL_WORKERS"}, "maximum number of lookups to run at once", setInt(func(c *Config) *int { return &c.DNSBL.Workers })},
+
+	{"auth.credentials_file", []string{"CREDENTIALS_FILE"}, "htpasswd file of Basic Authentication users", setString(func(c *Config) *string { return &c.Auth.CredentialsFile })},
+	{"auth.roles_file", []string{"ROLES_FILE"}, "file granting roles to users", setString(func(c *Config) *string { return &c.Auth.RolesFile })},
+	{"auth.tenants_file", []string{"TENANTS_FILE"}, "file assigning principals to tenants", setString(func(c *Config) *string { return &c.Auth.TenantsFile })},
+	{"auth.rate_limits_file", []string{"RATE_LIMITS"}, "JSON file of rate limit policies", setString(func(c *Config) *string { return &c.Auth.RateLimitsFile })},
+	{"auth.jwt.jwks", []string{"JWT_JWKS"}, "URL or file of the JWKS to verify SSO tokens with", setString(func(c *Config) *string { return &c.Auth.JWT.JWKS })},
+	{"auth.jwt.issuer", []string{"JWT_ISSUER"}, "required issuer of SSO tokens", setString(func(c *Config) *string { return &c.Auth.JWT.Issuer })},
+	{"auth.jwt.audience", []string{"JWT_AUDIENCE"}, "required audience of SSO tokens", setString(func(c *Config) *string { return &c.Auth.JWT.Audience })},
+	{"auth.jwt.name_claim", []string{"JWT_NAME_CLAIM"}, "claim naming the principal (default \"sub\")", setString(func(c *Config) *string { return &c.Auth.JWT.NameClaim })},
+	{"auth.jwt.roles_claim", []string{"JWT_ROLES_CLAIM"}, "claim listing the principal's roles (default \"roles\")", setString(func(c *Config) *string { return &c.Auth.JWT.RolesClaim })},
+	{"auth.jwt.tenant_claim", []string{"JWT_TENANT_CLAIM"}, "claim naming the principal's tenant", setString(func(c *Config) *string { return &c.Auth.JWT.TenantClaim })},
+
+	{"query.complexity_limit", []string{"QUERY_COMPLEXITY_LIMIT"}, "maximum GraphQL query complexity", setInt(func(c *Config) *int { return &c.Query.ComplexityLimit })},
+	{"query.depth_limit", []string{"QUERY_DEPTH_LIMIT"}, "maximum GraphQL query depth", setInt(func(c *Config) *int { return &c.Query.DepthLimit })},
+	{"query.persisted_queries", []string{"PERSISTED_QUERIES"}, "JSON file of the only GraphQL queries to allow", setString(func(c *Config) *string { return &c.Query.PersistedQueries })},
+
+	{"retention.jobs", []string{"JOB_RETENTION"}, "how long finished jobs can be checked on", setDuration(func(c *Config) *time.Duration { return &c.Retention.Jobs })},
+	{"retention.clean", []string{"CLEAN_RETENTION"}, "how long clean results are kept, or 0 to keep them forever", setDuration(func(c *Config) *time.Duration { return &c.Retention.Clean })},
+	{"retention.listed", []string{"LISTED_RETENTION"}, "how long listed results are kept, or 0 to keep them forever", setDuration(func(c *Config) *time.Duration { return &c.Retention.Listed })},
+	{"retention.audit_log", []string{"AUDIT_LOG_RETENTION"}, "how long audit entries are kept, or 0 to keep them forever", setDuration(func(c *Config) *time.Duration { return &c.Retention.AuditLog })},
+	{"retention.history", []string{"HISTORY_RETENTION"}, "how long the lookup history is kept, or 0 to keep it forever", setDuration(func(c *Config) *time.Duration { return &c.Retention.History })},
+	{"retention.prune_interval", []string{"PRUNE_INTERVAL"}, "how often expired records are removed", setDuration(func(c *Config) *time.Duration { return &c.Retention.PruneInterval })},
+	{"retention.compact_interval", []string{"COMPACT_INTERVAL"}, "how often a SQLite database is vacuumed after pruning, or 0 never to", setDuration(func(c *Config) *time.Duration { return &c.Retention.CompactInterval })},
+	{"retention.dry_run", []string{"RETENTION_DRY_RUN"}, "log the records that would be pruned instead of removing them", setBool(func(c *Config) *bool { return &c.Retention.DryRun })},
+
+	{"backup.dir", []string{"BACKUP_DIR"}, "directory to write SQLite backups to", setString(func(c *Config) *string { return &c.Backup.Dir })},
+	{"backup.interval", []string{"BACKUP_INTERVAL"}, "how often to write a backup, or 0 only when an admin asks", setDuration(func(c *Config) *time.Duration { return &c.Backup.Interval })},
+	{"backup.keep", []string{"BACKUP_KEEP"}, "how many backups to keep, or 0 to keep them all", setInt(func(c *Config) *int { return &c.Backup.Keep })},
+}
+
+func setString(field func(c *Config) *string) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+// setAddr accepts either a full address or a bare port.
+func setAddr(field func(c *Config) *string) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		if _, err := strconv.Atoi(v); err == nil {
+			v = ":" + v
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func setInt(field func(c *Config) *int) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", v)
+		}
+		*field(c) = i
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *time.Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("expected a duration such as \"30s\", got %q", v)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func setBool(field func(c *Config) *bool) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func setList(field func(c *Config) *[]string) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		list := []string{}
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+// Flags registers the -config and -print-config flags, along with a flag overriding each
+// setting, on a FlagSet.
+type Flags struct {
+	fs          *flag.FlagSet
+	path        *string
+	printConfig *bool
+	values      map[string]*string
+}
+
+func NewFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{
+		fs:          fs,
+		path:        fs.String("config", "", "YAML config file (or CONFIG_FILE)"),
+		printConfig: fs.Bool("print-config", false, "print the effective configuration and exit"),
+		values:      map[string]*string{},
+	}
+	for _, s := range settings {
+		usage := s.usage
+		if len(s.env) > 0 {
+			usage += fmt.Sprintf(" (%s)", strings.Join(s.env, ", "))
+		}
+		f.values[s.flagName()] = fs.String(s.flagName(), "", usage)
+	}
+	return f
+}
+
+// PrintConfig reports whether -print-config was given.
+func (f *Flags) PrintConfig() bool {
+	return *f.printConfig
+}
+
+// Config loads the configuration from the file named by -config or CONFIG_FILE and the
+// environment, applies the flags that were set and validates the result.  It must be
+// called after the FlagSet has been parsed.
+func (f *Flags) Config(lookupEnv func(string) (string, bool)) (Config, error) {
+	path := *f.path
+	if path == "" {
+		path, _ = lookupEnv("CONFIG_FILE")
+	}
+
+	cfg, err := Load(path, lookupEnv)
+	if err != nil {
+		return cfg, err
+	}
+
+	set := map[string]bool{}
+	f.fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	for _, s := range settings {
+		if !set[s.flagName()] {
+			continue
+		}
+		if err := s.set(&cfg, *f.values[s.flagName()]); err != nil {
+			return cfg, fmt.Errorf("-%s: %w", s.flagName(), err)
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// TagIP adds tags to tenant's address addr on behalf of author, and returns all of the
+// address's tags by name.  Tags the address already has keep their author and time.
+func (c *Client) TagIP(ctx context.Context, tenant string, addr string, tags []string, author string) ([]*model.Tag, error) {
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning to tag %s: %w", addr, err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, tag := range tags {
+		_, err := tx.ExecContext(ctx, tx.Rebind(`INSERT INTO ip_tag(tenant, ip_address, tag, author, created_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(tenant, ip_address, tag) DO NOTHING`), tenant, addr, tag, author, now)
+		if err != nil {
+			return nil, fmt.Errorf("error tagging %s: %w", addr, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error tagging %s: %w", addr, err)
+	}
+	return c.tagsOn(ctx, tenant, addr)
+}
+
+// UntagIP removes tags from tenant's address addr, and returns the tags it has left.
+func (c *Client) UntagIP(ctx context.Context, tenant string, addr string, tags []string) ([]*model.Tag, error) {
+	if len(tags) > 0 {
+		query, args, err := sqlx.In("DELETE FROM ip_tag WHERE tenant = ? AND ip_address = ? AND tag IN (?)", tenant, addr, tags)
+		if err != nil {
+			return nil, fmt.Errorf("error building untag: %w", err)
+		}
+		if _, err := c.db.ExecContext(ctx, c.db.Rebind(query), args...); err != nil {
+			return nil, fmt.Errorf("error untagging %s: %w", addr, err)
+		}
+	}
+	return c.tagsOn(ctx, tenant, addr)
+}
+
+// Tags returns the tags on each of tenant's addresses addrs by name, keyed by address.
+// Addresses without tags are left out.
+func (c *Client) Tags(ctx context.Context, tenant string, addrs []string) (map[string][]*model.Tag, error) {
+	res := map[string][]*model.Tag{}
+	if len(addrs) == 0 {
+		return res, nil
+	}
+
+	query, args, err := sqlx.In("SELECT * FROM ip_tag WHERE tenant = ? AND ip_address IN (?) ORDER BY ip_address, tag", tenant, addrs)
+	if err != nil {
+		return nil, fmt.Errorf("error building tags query: %w", err)
+	}
+	var tags []Tag
+	if err := c.db.SelectContext(ctx, &tags, c.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("error getting tags: %w", err)
+	}
+
+	for _, t := range tags {
+		converted := dbTagToGraphQL(t)
+		res[t.IPAddress] = append(res[t.IPAddress], &converted)
+	}
+	return res, nil
+}
+
+// tagsOn returns the tags on tenant's address addr by name.
+func (c *Client) tagsOn(ctx context.Context, tenant string, addr string) ([]*model.Tag, error) {
+	tags, err := c.Tags(ctx, tenant, []string{addr})
+	if err != nil {
+		return nil, err
+	}
+	if tags[addr] == nil {
+		return []*model.Tag{}, nil
+	}
+	return tags[addr], nil
+}
+
+// AddNote stores a note on tenant's address note.IPAddress.  The ID and time are assigned
+// here.
+func (c *Client) AddNote(ctx context.Context, tenant string, note model.Note) (model.Note, error) {
+	n := newNote(tenant, note)
+	_, err := c.db.NamedExecContext(ctx,
+		`INSERT INTO ip_note(id, tenant, ip_address, body, author, created_at)
+		VALUES (:id, :tenant, :ip_address, :body, :author, :created_at)`,
+		n,
+	)
+	if err != nil {
+		return model.Note{}, fmt.Errorf("error adding note on %s: %w", note.IPAddress, err)
+	}
+	return dbNoteToGraphQL(n), nil
+}
+
+func newNote(tenant string, note model.Note) Note {
+	return Note{
+		ID:        uuid.New().String(),
+		Tenant:    tenant,
+		IPAddress: note.IPAddress,
+		Body:      note.Body,
+		Author:    note.Author,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Notes returns the notes on each of tenant's addresses addrs, oldest first, keyed by
+// address.  Addresses without notes are left out.
+func (c *Client) Notes(ctx context.Context, tenant string, addrs []string) (map[string][]*model.Note, error) {
+	res := map[string][]*model.Note{}
+	if len(addrs) == 0 {
+		return res, nil
+	}
+
+	query, args, err := sqlx.In("SELECT * FROM ip_note WHERE tenant = ? AND ip_address IN (?) ORDER BY created_at, id", tenant, addrs)
+	if err != nil {
+		return nil, fmt.Errorf("error building notes query: %w", err)
+	}
+	var notes []Note
+	if err := c.db.SelectContext(ctx, &notes, c.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("error getting notes: %w", err)
+	}
+
+	for _, n := range notes {
+		converted := dbNoteToGraphQL(n)
+		res[n.IPAddress] = append(res[n.IPAddress], &converted)
+	}
+	return res, nil
+}
+
+// annotationConds returns the conditions on the detail table selecting the addresses
+// with tag, and with a note containing note, for whichever of them are set.
+func (c *Client) annotationConds(tag, note *string) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if tag != nil {
+		conds = append(conds, `EXISTS (SELECT 1 FROM ip_tag
+			WHERE ip_tag.tenant = detail.tenant AND ip_tag.ip_address = detail.ip_address AND ip_tag.tag = ?)`)
+		args = append(args, *tag)
+	}
+	if note != nil {
+		conds = append(conds, `EXISTS (SELECT 1 FROM ip_note
+			WHERE ip_note.tenant = detail.tenant AND ip_note.ip_address = detail.ip_address AND `+
+			c.dialect.position("ip_note.body", "?")+" > 0)")
+		args = append(args, *note)
+	}
+	return conds, args
+}
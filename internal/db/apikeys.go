@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+)
+
+// CreateAPIKey stores a new key for tenant.  Only the hash of the key is stored; the ID
+// and creation time are assigned here and returned in the stored record.
+func (c *Client) CreateAPIKey(ctx context.Context, tenant string, key model.APIKey, keyHash string) (model.APIKey, error) {
+	stored := newAPIKey(tenant, key, keyHash)
+	_, err := c.db.NamedExecContext(ctx,
+		`INSERT INTO api_key(id, name, owner, key_hash, scopes, created_at, expires_at, tenant)
+		VALUES (:id, :name, :owner, :key_hash, :scopes, :created_at, :expires_at, :tenant)`,
+		stored,
+	)
+	if err != nil {
+		return model.APIKey{}, fmt.Errorf("error inserting api key: %w", err)
+	}
+
+	return dbAPIKeyToGraphQL(stored), nil
+}
+
+// newAPIKey assigns a new key its ID and creation time.
+func newAPIKey(tenant string, key model.APIKey, keyHash string) APIKey {
+	scopes := make([]string, 0, len(key.Scopes))
+	for _, scope := range key.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	return APIKey{
+		ID:        uuid.New().String(),
+		Name:      key.Name,
+		Owner:     key.Owner,
+		KeyHash:   keyHash,
+		Scopes:    strings.Join(scopes, ","),
+		CreatedAt: time.Now(),
+		ExpiresAt: key.ExpiresAt,
+		Tenant:    tenant,
+	}
+}
+
+func (c *Client) ListAPIKeys(ctx context.Context, tenant string) ([]*model.APIKey, error) {
+	var keys []APIKey
+	if err := c.db.SelectContext(ctx, &keys, c.db.Rebind("SELECT * FROM api_key WHERE tenant = ? ORDER BY created_at"), tenant); err != nil {
+		return nil, fmt.Errorf("error listing api keys: %w", err)
+	}
+
+	res := make([]*model.APIKey, 0, len(keys))
+	for _, k := range keys {
+		converted := dbAPIKeyToGraphQL(k)
+		res = append(res, &converted)
+	}
+	return res, nil
+}
+
+// RevokeAPIKey marks one of tenant's keys as revoked.  Revoking an already revoked key
+// leaves the original revocation time in place.
+func (c *Client) RevokeAPIKey(ctx context.Context, tenant string, id string) (model.APIKey, error) {
+	_, err := c.db.ExecContext(ctx, c.db.Rebind("UPDATE api_key SET revoked_at = ? WHERE tenant = ? AND id = ? AND revoked_at IS NULL"), time.Now(), tenant, id)
+	if err != nil {
+		return model.APIKey{}, fmt.Errorf("error revoking api key: %w", err)
+	}
+
+	var key APIKey
+	if err := c.db.GetContext(ctx, &key, c.db.Rebind("SELECT * FROM api_key WHERE tenant = ? AND id = ?"), tenant, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.APIKey{}, newErrAPIKeyNotFound(id, err)
+		}
+		return model.APIKey{}, fmt.Errorf("error reading api key: %w", err)
+	}
+
+	return dbAPIKeyToGraphQL(key), nil
+}
+
+// LookupAPIKey is an auth.APIKeyLookup backed by the api_key table.
+func (c *Client) LookupAPIKey(ctx context.Context, keyHash string) (auth.APIKeyRecord, error) {
+	var key APIKey
+	if err := c.db.GetContext(ctx, &key, c.db.Rebind("SELECT * FROM api_key WHERE key_hash = ?"), keyHash); err != nil {
+		return auth.APIKeyRecord{}, err
+	}
+
+	return apiKeyRecord(key), nil
+}
+
+func apiKeyRecord(key APIKey) auth.APIKeyRecord {
+	record := auth.APIKeyRecord{
+		ID:        key.ID,
+		Name:      key.Name,
+		Owner:     key.Owner,
+		Tenant:    key.Tenant,
+		ExpiresAt: key.ExpiresAt,
+		RevokedAt: key.RevokedAt,
+	}
+	for _, scope := range strings.Split(key.Scopes, ",") {
+		if scope != "" {
+			record.Scopes = append(record.Scopes, strings.ToLower(scope))
+		}
+	}
+	return record
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jmoiron/sqlx"
+)
+
+func newMockClient(t *testing.T) (*Client, sqlmock.Sqlmock) {
+	mockDb, myMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Errorf("unexpected error creating mock db: %s", err.Error())
+	}
+
+	// insert mock db into package
+	sqliteDbOpener = func(dataSource string) (*sqlx.DB, error) {
+		return sqlx.NewDb(mockDb, "sqlmock"), nil
+	}
+
+	myMock.ExpectPing()
+	expectInit(myMock)
+
+	db, err := NewClient("somefile.db")
+	if err != nil {
+		t.Errorf("unexpected error creating sqlite client: %s", err.Error())
+	}
+
+	return db, myMock
+}
+
+func closeMockClient(t *testing.T, db *Client, myMock sqlmock.Sqlmock) {
+	myMock.ExpectClose()
+
+	if err := db.Close(); err != nil {
+		t.Error(err.Error())
+	}
+
+	if err := myMock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+var apiKeyColumns = []string{"id", "name", "owner", "key_hash", "scopes", "created_at", "expires_at", "revoked_at", "tenant"}
+
+func TestSqliteCreateAPIKey(t *testing.T) {
+	db, myMock := newMockClient(t)
+
+	myMock.ExpectExec("INSERT INTO api_key").WithArgs(sqlmock.AnyArg(), "ci", "build-team", "somehash", "READ,ENQUEUE", sqlmock.AnyArg(), nil, "red").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	key, err := db.CreateAPIKey(context.Background(), "red", model.APIKey{
+		Name:   "ci",
+		Owner:  "build-team",
+		Scopes: []model.APIKeyScope{model.APIKeyScopeRead, model.APIKeyScopeEnqueue},
+	}, "somehash")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if key.ID == "" || key.CreatedAt.IsZero() || len(key.Scopes) != 2 {
+		t.Errorf("unexpected stored key: %+v", key)
+	}
+
+	closeMockClient(t, db, myMock)
+}
+
+func TestSqliteRevokeAPIKeyNotFound(t *testing.T) {
+	db, myMock := newMockClient(t)
+
+	myMock.ExpectExec("UPDATE api_key SET revoked_at").WithArgs(sqlmock.AnyArg(), "red", "missing").WillReturnResult(sqlmock.NewResult(0, 0))
+	myMock.ExpectQuery("SELECT \\* FROM api_key WHERE tenant = \\? AND id").WithArgs("red", "missing").WillReturnRows(sqlmock.NewRows(apiKeyColumns))
+
+	_, err := db.RevokeAPIKey(context.Background(), "red", "missing")
+	if _, ok := err.(ErrNotFound); !ok || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected an ErrNotFound, got %v", err)
+	}
+
+	closeMockClient(t, db, myMock)
+}
+
+func TestSqliteLookupAPIKey(t *testing.T) {
+	db, myMock := newMockClient(t)
+
+	expires := time.Now().Add(time.Hour)
+	myMock.ExpectQuery("SELECT \\* FROM api_key WHERE key_hash").WithArgs("somehash").WillReturnRows(
+		sqlmock.NewRows(apiKeyColumns).AddRow("id", "ci", "build-team", "somehash", "READ,ADMIN", time.Now(), expires, nil, "red"),
+	)
+
+	record, err := db.LookupAPIKey(context.Background(), "somehash")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if record.ID != "id" || record.Owner != "build-team" || record.Tenant != "red" || len(record.Scopes) != 2 || record.Scopes[1] != "admin" {
+		t.Errorf("unexpected record: %+v", record)
+	}
+	if record.ExpiresAt == nil || !record.ExpiresAt.Equal(expires) || record.RevokedAt != nil {
+		t.Errorf("unexpected expiry/revocation: %+v", record)
+	}
+
+	closeMockClient(t, db, myMock)
+}
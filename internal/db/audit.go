@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jdharms/threat-detect/graph/model"
+)
+
+// AddAuditEntry records an operation performed in tenant.  The ID and time are assigned
+// here; times are stored in UTC so that entries sort chronologically.
+func (c *Client) AddAuditEntry(ctx context.Context, tenant string, entry model.AuditEntry) error {
+	_, err := c.db.NamedExecContext(ctx,
+		`INSERT INTO audit_log(id, created_at, principal, api_key_id, client_ip, operation, arguments, tenant)
+		VALUES (:id, :created_at, :principal, :api_key_id, :client_ip, :operation, :arguments, :tenant)`,
+		newAuditEntry(tenant, entry),
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting audit entry: %w", err)
+	}
+	return nil
+}
+
+func newAuditEntry(tenant string, entry model.AuditEntry) AuditEntry {
+	return AuditEntry{
+		ID:        uuid.New().String(),
+		CreatedAt: time.Now().UTC(),
+		Principal: entry.Principal,
+		APIKeyID:  entry.APIKeyID,
+		ClientIP:  entry.ClientIP,
+		Operation: string(entry.Operation),
+		Arguments: entry.Arguments,
+		Tenant:    tenant,
+	}
+}
+
+// EachAuditEntry calls fn with each of tenant's entries matching filter, newest first,
+// without loading them all into memory.
+func (c *Client) EachAuditEntry(ctx context.Context, tenant string, filter model.AuditLogFilter, fn func(*model.AuditEntry) error) error {
+	conds := []string{"tenant = ?"}
+	args := []interface{}{tenant}
+	if filter.Principal != nil {
+		conds = append(conds, "principal = ?")
+		args = append(args, *filter.Principal)
+	}
+	if filter.Operation != nil {
+		conds = append(conds, "operation = ?")
+		args = append(args, string(*filter.Operation))
+	}
+	if filter.IP != nil {
+		// arguments is a JSON object, so the quoted address only matches whole values
+		quoted, _ := json.Marshal(*filter.IP)
+		conds = append(conds, c.dialect.position("arguments", "?")+" > 0")
+		args = append(args, string(quoted))
+	}
+	if filter.Since != nil {
+		conds = append(conds, c.dialect.timestamp("created_at")+" >= "+c.dialect.timestamp("?"))
+		args = append(args, filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		conds = append(conds, c.dialect.timestamp("created_at")+" < "+c.dialect.timestamp("?"))
+		args = append(args, filter.Until.UTC())
+	}
+
+	query := "SELECT * FROM audit_log WHERE " + strings.Join(conds, " AND ")
+	query += " ORDER BY created_at DESC"
+	if filter.Limit != nil {
+		query += " LIMIT ?"
+		args = append(args, *filter.Limit)
+	}
+
+	rows, err := c.db.QueryxContext(ctx, c.db.Rebind(query), args...)
+	if err != nil {
+		return fmt.Errorf("error querying audit log: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.StructScan(&entry); err != nil {
+			return fmt.Errorf("error reading audit entry: %w", err)
+		}
+
+		converted := dbAuditEntryToGraphQL(entry)
+		if err := fn(&converted); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading audit log: %w", err)
+	}
+	return nil
+}
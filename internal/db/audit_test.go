@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jdharms/threat-detect/graph/model"
+)
+
+var auditColumns = []string{"id", "created_at", "principal", "api_key_id", "client_ip", "operation", "arguments", "tenant"}
+
+func TestSqliteAddAuditEntry(t *testing.T) {
+	db, myMock := newMockClient(t)
+
+	myMock.ExpectExec("INSERT INTO audit_log").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "alice", nil, "10.0.0.1", "ENQUEUE", `{"ip":["1.2.3.4"]}`, "red").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := db.AddAuditEntry(context.Background(), "red", model.AuditEntry{
+		Principal: "alice",
+		ClientIP:  "10.0.0.1",
+		Operation: model.AuditOperationEnqueue,
+		Arguments: `{"ip":["1.2.3.4"]}`,
+	})
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	closeMockClient(t, db, myMock)
+}
+
+func TestSqliteEachAuditEntry(t *testing.T) {
+	since := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	principal := "alice"
+	op := model.AuditOperationGetIPDetails
+	ip := "1.2.3.4"
+	limit := 10
+
+	testCases := []struct {
+		name          string
+		filter        model.AuditLogFilter
+		expectedQuery string
+		expectedArgs  []interface{}
+	}{
+		{
+			"no filter",
+			model.AuditLogFilter{},
+			"SELECT \\* FROM audit_log WHERE tenant = \\? ORDER BY created_at DESC$",
+			[]interface{}{"red"},
+		},
+		{
+			"all filters",
+			model.AuditLogFilter{Principal: &principal, Operation: &op, IP: &ip, Since: &since, Limit: &limit},
+			"SELECT \\* FROM audit_log WHERE tenant = \\? AND principal = \\? AND operation = \\? AND instr\\(arguments, \\?\\) > 0 AND julianday\\(created_at\\) >= julianday\\(\\?\\) ORDER BY created_at DESC LIMIT \\?",
+			[]interface{}{"red", "alice", "GET_IP_DETAILS", `"1.2.3.4"`, since, 10},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			db, myMock := newMockClient(t)
+
+			rows := sqlmock.NewRows(auditColumns).
+				AddRow("2", time.Now(), "alice", nil, "10.0.0.1", "GET_IP_DETAILS", `{"ip":["1.2.3.4"]}`, "red").
+				AddRow("1", time.Now(), "alice", "key-1", "10.0.0.1", "GET_IP_DETAILS", `{"ip":["1.2.3.4"]}`, "red")
+			query := myMock.ExpectQuery(test.expectedQuery)
+			if test.expectedArgs != nil {
+				args := make([]driver.Value, len(test.expectedArgs))
+				for i, a := range test.expectedArgs {
+					args[i] = a
+				}
+				query.WithArgs(args...)
+			}
+			query.WillReturnRows(rows)
+
+			var ids []string
+			err := db.EachAuditEntry(context.Background(), "red", test.filter, func(e *model.AuditEntry) error {
+				ids = append(ids, e.ID)
+				return nil
+			})
+			if err != nil {
+				t.Error(err.Error())
+			}
+			if len(ids) != 2 || ids[0] != "2" {
+				t.Errorf("unexpected entries: %v", ids)
+			}
+
+			closeMockClient(t, db, myMock)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Backup writes a consistent copy of the database to path, which must not exist, while
+// the database stays in use.
+func (c *Client) Backup(ctx context.Context, path string) error {
+	if c.dialect.backupStmt == "" {
+		return fmt.Errorf("%s databases can't be backed up by the server; use the database's own tools", c.dialect.name)
+	}
+	if _, err := c.db.ExecContext(ctx, c.dialect.backupStmt, path); err != nil {
+		return fmt.Errorf("error backing up database to %s: %w", path, err)
+	}
+	return nil
+}
+
+// VerifyBackup checks that the SQLite database at path is intact and could be used by
+// this build, returning its schema version.
+func VerifyBackup(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, fmt.Errorf("error reading backup: %w", err)
+	}
+	backup, err := sqliteDbOpener(fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return 0, err
+	}
+	defer backup.Close()
+
+	var problems []string
+	if err := backup.Select(&problems, "PRAGMA integrity_check"); err != nil {
+		return 0, fmt.Errorf("error checking backup: %w", err)
+	}
+	if len(problems) != 1 || problems[0] != "ok" {
+		return 0, fmt.Errorf("backup is corrupt: %s", strings.Join(problems, "; "))
+	}
+
+	var version int
+	if err := backup.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version"); err != nil {
+		return 0, fmt.Errorf("error reading schema version of backup: %w", err)
+	}
+	migrations, err := loadMigrations(sqliteDialect)
+	if err != nil {
+		return 0, err
+	}
+	if version == 0 || version > len(migrations) {
+		return 0, fmt.Errorf("backup schema version %d isn't supported by this build (1 to %d)", version, len(migrations))
+	}
+	return version, nil
+}
+
+// RestoreSuffix is appended to the name of the database a restore replaces.
+const RestoreSuffix = ".pre-restore"
+
+// Restore replaces the SQLite database at path with a copy of the backup at backupPath,
+// after verifying it, and returns the backup's schema version.  A database already at
+// path is kept, along with its write-ahead log, by appending RestoreSuffix to their
+// names.  Nothing may be using the database.
+func Restore(backupPath, path string) (int, error) {
+	version, err := VerifyBackup(backupPath)
+	if err != nil {
+		return 0, err
+	}
+
+	restoring := path + ".restoring"
+	if err := copyFile(backupPath, restoring); err != nil {
+		os.Remove(restoring)
+		return 0, fmt.Errorf("error copying backup: %w", err)
+	}
+
+	// a log left by an earlier restore mustn't be paired with this one's database
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + RestoreSuffix + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(restoring)
+			return 0, fmt.Errorf("error removing an earlier restore's log: %w", err)
+		}
+	}
+	var kept []string
+	// putBack returns the kept files to their places, so that a failed restore leaves
+	// the previous database in use
+	putBack := func() {
+		os.Remove(restoring)
+		for _, suffix := range kept {
+			if err := rename(path+RestoreSuffix+suffix, path+suffix); err != nil {
+				log.Printf("error putting %s back after a failed restore: %s", path+suffix, err.Error())
+			}
+		}
+	}
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := rename(path+suffix, path+RestoreSuffix+suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			putBack()
+			return 0, fmt.Errorf("error keeping the previous database: %w", err)
+		}
+		kept = append(kept, suffix)
+	}
+
+	if err := rename(restoring, path); err != nil {
+		putBack()
+		return 0, fmt.Errorf("error replacing database: %w", err)
+	}
+	return version, nil
+}
+
+// rename is os.Rename, replaced in tests to make a restore fail part way through.
+var rename = os.Rename
+
+// copyFile copies src to dst with the same permissions, syncing dst to disk.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
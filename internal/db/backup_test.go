@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph/model"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	path := openTestDatabase(t)
+	c, err := NewClient(path)
+	if err != nil {
+		t.Fatalf("unexpected error creating database: %s", err.Error())
+	}
+	defer c.Close()
+	if err := c.AddIPDetails(context.Background(), "red", model.IPDetails{IPAddress: "1.2.3.4", ResponseCode: "127.0.0.2"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	backup := filepath.Join(t.TempDir(), "backup.db")
+	if err := c.Backup(context.Background(), backup); err != nil {
+		t.Fatalf("unexpected error backing up: %s", err.Error())
+	}
+	if err := c.Backup(context.Background(), backup); err == nil {
+		t.Error("expected an error backing up over an existing file")
+	}
+
+	// changes made after the backup are lost by restoring it
+	if err := c.AddIPDetails(context.Background(), "red", model.IPDetails{IPAddress: "5.6.7.8"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	c.Close()
+
+	migrations, _ := loadMigrations(sqliteDialect)
+	version, err := Restore(backup, path)
+	if err != nil {
+		t.Fatalf("unexpected error restoring: %s", err.Error())
+	}
+	if version != len(migrations) {
+		t.Errorf("expected schema version %d, got %d", len(migrations), version)
+	}
+	if _, err := os.Stat(path + RestoreSuffix); err != nil {
+		t.Errorf("expected the replaced database to be kept: %s", err.Error())
+	}
+
+	restored, err := NewClient(path)
+	if err != nil {
+		t.Fatalf("unexpected error opening restored database: %s", err.Error())
+	}
+	defer restored.Close()
+	if _, err := restored.GetIPDetails(context.Background(), "red", "1.2.3.4"); err != nil {
+		t.Errorf("expected the backed up details to be restored: %s", err.Error())
+	}
+	if _, err := restored.GetIPDetails(context.Background(), "red", "5.6.7.8"); err == nil {
+		t.Error("expected details added after the backup not to be restored")
+	}
+}
+
+func TestVerifyBackupRejects(t *testing.T) {
+	dir := t.TempDir()
+	openTestDatabase(t)
+
+	notDatabase := filepath.Join(dir, "not.db")
+	if err := ioutil.WriteFile(notDatabase, []byte(strings.Repeat("not a database ", 100)), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	newer := filepath.Join(dir, "newer.db")
+	c, err := NewClient(newer)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = c.db.Exec("INSERT INTO schema_version(version, name, applied_at) VALUES (9999, 'future', ?)", time.Now().UTC())
+	c.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	testCases := []struct {
+		name   string
+		path   string
+		errKey string
+	}{
+		{"missing", filepath.Join(dir, "missing.db"), "error reading backup"},
+		{"not a database", notDatabase, "error checking backup"},
+		{"newer schema", newer, "isn't supported"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := VerifyBackup(test.path)
+			if err == nil || !strings.Contains(err.Error(), test.errKey) {
+				t.Errorf("expected an error containing %q, got %v", test.errKey, err)
+			}
+
+			target := filepath.Join(t.TempDir(), "data.db")
+			if _, err := Restore(test.path, target); err == nil {
+				t.Error("expected the backup not to be restored")
+			}
+			if _, err := os.Stat(target + ".restoring"); !os.IsNotExist(err) {
+				t.Error("expected nothing to be left behind")
+			}
+		})
+	}
+}
+
+func TestRestoreFailurePutsDatabaseBack(t *testing.T) {
+	path := openTestDatabase(t)
+	c, err := NewClient(path)
+	if err != nil {
+		t.Fatalf("unexpected error creating database: %s", err.Error())
+	}
+	backup := filepath.Join(t.TempDir(), "backup.db")
+	if err := c.Backup(context.Background(), backup); err != nil {
+		t.Fatalf("unexpected error backing up: %s", err.Error())
+	}
+	c.Close()
+
+	// stand-ins for the log of a database that wasn't shut down cleanly
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := ioutil.WriteFile(path+suffix, []byte(suffix), 0600); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	rename = func(oldpath, newpath string) error {
+		if strings.HasSuffix(oldpath, ".restoring") {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	defer func() { rename = os.Rename }()
+
+	_, err = Restore(backup, path)
+	if err == nil || !strings.Contains(err.Error(), "error replacing database") {
+		t.Fatalf("expected the restore to fail, got %v", err)
+	}
+
+	rename = os.Rename
+	if contents, err := ioutil.ReadFile(path); err != nil || string(contents) != string(original) {
+		t.Errorf("expected the previous database to be put back, got %v", err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if contents, err := ioutil.ReadFile(path + suffix); err != nil || string(contents) != suffix {
+			t.Errorf("expected %s to be put back, got %q, %v", suffix, contents, err)
+		}
+	}
+	for _, name := range []string{path + RestoreSuffix, path + RestoreSuffix + "-wal", path + ".restoring"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be left behind", name)
+		}
+	}
+}
+
+func TestPostgresBackupUnsupported(t *testing.T) {
+	c := &Client{dialect: postgresDialect}
+	if err := c.Backup(context.Background(), "backup.db"); err == nil {
+		t.Error("expected backing up a PostgreSQL database to fail")
+	}
+}
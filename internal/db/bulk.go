@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/dnsbl"
+)
+
+// EachIPDetails calls fn with each of tenant's records matching filter in address order,
+// without loading them all into memory.
+func (c *Client) EachIPDetails(ctx context.Context, tenant string, filter model.ExportFilter, fn func(*model.IPDetails) error) error {
+	conds := []string{"tenant = ?"}
+	args := []interface{}{tenant}
+	if filter.Cidr != nil {
+		_, network, err := net.ParseCIDR(*filter.Cidr)
+		if err != nil {
+			return fmt.Errorf("%s is not a CIDR network", *filter.Cidr)
+		}
+		start, end := networkRange(network)
+		conds = append(conds, "ip_bytes BETWEEN ? AND ?")
+		args = append(args, start, end)
+	}
+	if filter.Listed != nil {
+		if *filter.Listed {
+			conds = append(conds, "response_code != ''")
+		} else {
+			conds = append(conds, "COALESCE(response_code, '') = ''")
+		}
+	}
+	annotationConds, annotationArgs := c.annotationConds(filter.Tag, filter.Note)
+	conds = append(conds, annotationConds...)
+	args = append(args, annotationArgs...)
+	if filter.Since != nil {
+		conds = append(conds, c.dialect.timestamp("updated_at")+" >= "+c.dialect.timestamp("?"))
+		args = append(args, filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		conds = append(conds, c.dialect.timestamp("updated_at")+" < "+c.dialect.timestamp("?"))
+		args = append(args, filter.Until.UTC())
+	}
+
+	query := "SELECT * FROM detail WHERE " + strings.Join(conds, " AND ")
+	query += " ORDER BY ip_bytes, ip_address"
+	if filter.Limit != nil {
+		query += " LIMIT ?"
+		args = append(args, *filter.Limit)
+	}
+
+	rows, err := c.db.QueryxContext(ctx, c.db.Rebind(query), args...)
+	if err != nil {
+		return fmt.Errorf("error querying ip details: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var details IPDetails
+		if err := rows.StructScan(&details); err != nil {
+			return fmt.Errorf("error reading ip details: %w", err)
+		}
+
+		converted := dbModelToGraphQL(details)
+		if err := fn(&converted); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading ip details: %w", err)
+	}
+	return nil
+}
+
+// importStmt stores one imported record, resolving a conflict with tenant's existing
+// record for the address by policy.  No row is affected when the record is skipped.
+func (c *Client) importStmt(policy model.ConflictPolicy) string {
+	stmt := `INSERT INTO detail(id, created_at, updated_at, response_code, ip_address, tenant, ip_bytes)
+	VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT(tenant, ip_address) `
+	switch policy {
+	case model.ConflictPolicyOverwrite:
+		return stmt + "DO UPDATE SET updated_at = excluded.updated_at, response_code = excluded.response_code"
+	case model.ConflictPolicyNewer:
+		return stmt + "DO UPDATE SET updated_at = excluded.updated_at, response_code = excluded.response_code WHERE " +
+			c.dialect.timestamp("excluded.updated_at") + " > " + c.dialect.timestamp("detail.updated_at")
+	default:
+		return stmt + "DO NOTHING"
+	}
+}
+
+// ImportIPDetails stores records for tenant in a single transaction, so that either all
+// of them are stored or none are.  Records keep their times, though new ids are
+// assigned; see importTimes for records missing them or dated in the future.  A record
+// whose response code couldn't have come from the DNSBL client fails the import.
+func (c *Client) ImportIPDetails(ctx context.Context, tenant string, records []model.IPDetails, policy model.ConflictPolicy) (model.ImportResult, error) {
+	var res model.ImportResult
+	if !policy.IsValid() {
+		return res, fmt.Errorf("unknown conflict policy %q", policy)
+	}
+
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return res, fmt.Errorf("error beginning import: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PreparexContext(ctx, tx.Rebind(c.importStmt(policy)))
+	if err != nil {
+		return res, fmt.Errorf("error preparing import: %w", err)
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, d := range records {
+		key, err := ipKey(d.IPAddress)
+		if err != nil {
+			return model.ImportResult{}, err
+		}
+		if err := dnsbl.ValidateResponseCode(d.ResponseCode); err != nil {
+			return model.ImportResult{}, err
+		}
+		created, updated := importTimes(d, now)
+
+		r, err := stmt.ExecContext(ctx, uuid.New().String(), created, updated, d.ResponseCode, d.IPAddress, tenant, key)
+		if err != nil {
+			return model.ImportResult{}, fmt.Errorf("error importing %s: %w", d.IPAddress, err)
+		}
+		if n, err := r.RowsAffected(); err == nil && n == 0 {
+			res.Skipped++
+		} else {
+			res.Imported++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.ImportResult{}, fmt.Errorf("error committing import: %w", err)
+	}
+	return res, nil
+}
+
+// importTimes returns the times to store an imported record with.  A record that was
+// never updated is taken to be updated now, and one without a creation time to have
+// been created when it was last updated.  Times in the future are taken to be now, so an
+// imported record can't stay newer than every lookup.  Times are stored in UTC so that
+// they sort.
+func importTimes(d model.IPDetails, now time.Time) (created, updated time.Time) {
+	updated = d.UpdatedAt
+	if updated.IsZero() || updated.After(now) {
+		updated = now
+	}
+	created = d.CreatedAt
+	if created.IsZero() || created.After(updated) {
+		created = updated
+	}
+	return created.UTC(), updated.UTC()
+}
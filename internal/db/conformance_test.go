@@ -0,0 +1,76 @@
+package db_test
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/jdharms/threat-detect/internal/db"
+	"github.com/jdharms/threat-detect/internal/db/dbtest"
+)
+
+func TestSqliteConformance(t *testing.T) {
+	db.UseRealDatabases()
+
+	dbtest.Run(t, func(t *testing.T) dbtest.Store {
+		c, err := db.NewClient(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("unexpected error creating sqlite client: %s", err.Error())
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) dbtest.Store {
+		return db.NewMemoryStore()
+	})
+}
+
+// TestPostgresConformance runs against the server at TEST_POSTGRES_URL, such as the
+// throwaway container started by `make test` or the CI service container, giving each
+// test its own schema.  It is only skipped outside CI.
+func TestPostgresConformance(t *testing.T) {
+	base := os.Getenv("TEST_POSTGRES_URL")
+	if base == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("TEST_POSTGRES_URL must be set in CI")
+		}
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	admin, err := sqlx.Open("postgres", base)
+	if err != nil {
+		t.Fatalf("unexpected error connecting to postgres: %s", err.Error())
+	}
+	defer admin.Close()
+
+	dbtest.Run(t, func(t *testing.T) dbtest.Store {
+		schema := "conformance_" + uuid.New().String()[:8]
+		if _, err := admin.Exec(fmt.Sprintf("CREATE SCHEMA %s", schema)); err != nil {
+			t.Fatalf("unexpected error creating schema: %s", err.Error())
+		}
+		t.Cleanup(func() { admin.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", schema)) })
+
+		u, err := url.Parse(base)
+		if err != nil {
+			t.Fatalf("invalid TEST_POSTGRES_URL: %s", err.Error())
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+
+		c, err := db.NewPostgresClient(u.String())
+		if err != nil {
+			t.Fatalf("unexpected error creating postgres client: %s", err.Error())
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	})
+}
@@ -0,0 +1,869 @@
+// Package dbtest is a conformance suite for storage backends, so that every backend the
+// server can be configured with behaves the same way.
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/db"
+	"github.com/jdharms/threat-detect/internal/retention"
+)
+
+// Store is every storage interface the server uses.
+type Store interface {
+	graph.IPDetailsAdder
+	graph.IPDetailsGetter
+	graph.IPDetailsDeleter
+	graph.NetworkSearcher
+	graph.BulkDetails
+	graph.SharedResults
+	graph.StatsGetter
+	graph.APIKeyStore
+	graph.AuditLog
+	graph.Annotations
+	retention.Store
+	LookupAPIKey(ctx context.Context, keyHash string) (auth.APIKeyRecord, error)
+}
+
+// Run runs the suite, calling open for an empty store at the start of each test.
+func Run(t *testing.T, open func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s Store)
+	}{
+		{"AddAndGetIPDetails", testAddAndGetIPDetails},
+		{"UpsertIPDetails", testUpsertIPDetails},
+		{"ConcurrentUpserts", testConcurrentUpserts},
+		{"TenantIsolation", testTenantIsolation},
+		{"DeleteIPDetails", testDeleteIPDetails},
+		{"IPDetailsInNetwork", testIPDetailsInNetwork},
+		{"EachIPDetails", testEachIPDetails},
+		{"ImportIPDetails", testImportIPDetails},
+		{"Tags", testTags},
+		{"Notes", testNotes},
+		{"AnnotationFilters", testAnnotationFilters},
+		{"SharedResult", testSharedResult},
+		{"CancelledContext", testCancelledContext},
+		{"Stats", testStats},
+		{"APIKeys", testAPIKeys},
+		{"AuditLog", testAuditLog},
+		{"Prune", testPrune},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, open(t))
+		})
+	}
+}
+
+func add(t *testing.T, s Store, tenant, addr, code string) {
+	t.Helper()
+	if err := s.AddIPDetails(context.Background(), tenant, model.IPDetails{IPAddress: addr, ResponseCode: code}); err != nil {
+		t.Fatalf("unexpected error adding %s: %s", addr, err.Error())
+	}
+}
+
+func expectNotFound(t *testing.T, err error) {
+	t.Helper()
+	var notFound db.ErrNotFound
+	if !errors.As(err, &notFound) {
+		t.Errorf("expected db.ErrNotFound, got %v", err)
+	}
+}
+
+func testAddAndGetIPDetails(t *testing.T, s Store) {
+	before := time.Now().Add(-time.Second)
+	add(t, s, "red", "127.0.0.1", "127.0.0.2")
+
+	details, err := s.GetIPDetails(context.Background(), "red", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("unexpected error getting details: %s", err.Error())
+	}
+	if details.UUID == "" || details.IPAddress != "127.0.0.1" || details.ResponseCode != "127.0.0.2" {
+		t.Errorf("unexpected details: %+v", details)
+	}
+	if details.CreatedAt.Before(before) || !details.UpdatedAt.Equal(details.CreatedAt) {
+		t.Errorf("expected new details to be created and updated now, got %+v", details)
+	}
+
+	_, err = s.GetIPDetails(context.Background(), "red", "127.0.0.9")
+	expectNotFound(t, err)
+}
+
+func testUpsertIPDetails(t *testing.T, s Store) {
+	add(t, s, "red", "127.0.0.1", "127.0.0.2")
+	first, err := s.GetIPDetails(context.Background(), "red", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("unexpected error getting details: %s", err.Error())
+	}
+
+	add(t, s, "red", "127.0.0.1", "")
+	second, err := s.GetIPDetails(context.Background(), "red", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("unexpected error getting details: %s", err.Error())
+	}
+
+	if second.UUID != first.UUID || !second.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("expected id and created_at to be preserved, got %+v then %+v", first, second)
+	}
+	if second.ResponseCode != "" || second.UpdatedAt.Before(first.UpdatedAt) {
+		t.Errorf("expected response code and updated_at to be updated, got %+v", second)
+	}
+}
+
+func testConcurrentUpserts(t *testing.T, s Store) {
+	const workers = 10
+	const adds = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*adds)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				errs <- s.AddIPDetails(context.Background(), "red", model.IPDetails{IPAddress: "127.0.0.1", ResponseCode: fmt.Sprintf("127.0.0.%d", w+2)})
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error adding details concurrently: %s", err.Error())
+		}
+	}
+
+	stats, err := s.GetStats(context.Background(), "red", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error getting stats: %s", err.Error())
+	}
+	if stats.Total != 1 {
+		t.Errorf("expected exactly one record for the address, got %d", stats.Total)
+	}
+}
+
+func testTenantIsolation(t *testing.T, s Store) {
+	add(t, s, "red", "127.0.0.1", "127.0.0.2")
+
+	_, err := s.GetIPDetails(context.Background(), "blue", "127.0.0.1")
+	expectNotFound(t, err)
+
+	n, err := s.DeleteIPDetails(context.Background(), "blue", []string{"127.0.0.1"})
+	if err != nil || n != 0 {
+		t.Errorf("expected another tenant's delete to find nothing, got %d, %v", n, err)
+	}
+
+	stats, err := s.GetStats(context.Background(), "blue", time.Now().Add(-time.Hour))
+	if err != nil || stats.Total != 0 {
+		t.Errorf("expected another tenant's stats to be empty, got %+v, %v", stats, err)
+	}
+
+	if _, err := s.GetIPDetails(context.Background(), "red", "127.0.0.1"); err != nil {
+		t.Errorf("expected the owning tenant to still see its details, got %s", err.Error())
+	}
+}
+
+func testDeleteIPDetails(t *testing.T, s Store) {
+	add(t, s, "red", "127.0.0.1", "127.0.0.2")
+	add(t, s, "red", "127.0.0.3", "")
+
+	n, err := s.DeleteIPDetails(context.Background(), "red", []string{"127.0.0.1", "127.0.0.3", "127.0.0.9"})
+	if err != nil {
+		t.Fatalf("unexpected error deleting details: %s", err.Error())
+	}
+	if n != 2 {
+		t.Errorf("expected 2 records to be deleted, got %d", n)
+	}
+
+	_, err = s.GetIPDetails(context.Background(), "red", "127.0.0.1")
+	expectNotFound(t, err)
+
+	n, err = s.DeleteIPDetails(context.Background(), "red", nil)
+	if err != nil || n != 0 {
+		t.Errorf("expected deleting nothing to succeed, got %d, %v", n, err)
+	}
+}
+
+func testIPDetailsInNetwork(t *testing.T, s Store) {
+	add(t, s, "red", "203.0.113.20", "")
+	add(t, s, "red", "203.0.113.3", "127.0.0.2")
+	add(t, s, "red", "203.0.113.100", "127.0.0.4")
+	add(t, s, "red", "203.0.114.1", "127.0.0.2")
+	add(t, s, "red", "2001:db8::1", "127.0.0.2")
+	add(t, s, "red", "2001:db9::1", "")
+	add(t, s, "blue", "203.0.113.5", "127.0.0.2")
+
+	listed, clean, limit := true, false, 1
+	tests := []struct {
+		name     string
+		cidr     string
+		filter   model.NetworkFilter
+		expected []string
+	}{
+		{"ipv4 in address order", "203.0.113.0/24", model.NetworkFilter{}, []string{"203.0.113.3", "203.0.113.20", "203.0.113.100"}},
+		{"listed only", "203.0.113.0/24", model.NetworkFilter{Listed: &listed}, []string{"203.0.113.3", "203.0.113.100"}},
+		{"clean only", "203.0.113.0/24", model.NetworkFilter{Listed: &clean}, []string{"203.0.113.20"}},
+		{"limit", "203.0.113.0/24", model.NetworkFilter{Limit: &limit}, []string{"203.0.113.3"}},
+		{"wider network", "203.0.0.0/16", model.NetworkFilter{}, []string{"203.0.113.3", "203.0.113.20", "203.0.113.100", "203.0.114.1"}},
+		{"single address", "203.0.113.20/32", model.NetworkFilter{}, []string{"203.0.113.20"}},
+		{"ipv6", "2001:db8::/32", model.NetworkFilter{}, []string{"2001:db8::1"}},
+		{"empty", "198.51.100.0/24", model.NetworkFilter{}, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, network, err := net.ParseCIDR(test.cidr)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			found, err := s.IPDetailsInNetwork(context.Background(), "red", network, test.filter)
+			if err != nil {
+				t.Fatalf("unexpected error searching network: %s", err.Error())
+			}
+			addrs := []string{}
+			for _, d := range found {
+				addrs = append(addrs, d.IPAddress)
+			}
+			if !reflect.DeepEqual(addrs, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, addrs)
+			}
+		})
+	}
+}
+
+func importDetails(t *testing.T, s Store, tenant string, policy model.ConflictPolicy, records ...model.IPDetails) model.ImportResult {
+	t.Helper()
+	res, err := s.ImportIPDetails(context.Background(), tenant, records, policy)
+	if err != nil {
+		t.Fatalf("unexpected error importing: %s", err.Error())
+	}
+	return res
+}
+
+func testEachIPDetails(t *testing.T, s Store) {
+	jan := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	mar := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	importDetails(t, s, "red", model.ConflictPolicySkip,
+		model.IPDetails{IPAddress: "203.0.113.20", UpdatedAt: jan},
+		model.IPDetails{IPAddress: "203.0.113.3", ResponseCode: "127.0.0.2", UpdatedAt: feb},
+		model.IPDetails{IPAddress: "198.51.100.7", ResponseCode: "127.0.0.4", UpdatedAt: mar},
+	)
+	importDetails(t, s, "blue", model.ConflictPolicySkip, model.IPDetails{IPAddress: "203.0.113.5", UpdatedAt: jan})
+
+	listed, clean, limit, cidr := true, false, 1, "203.0.113.0/24"
+	tests := []struct {
+		name     string
+		filter   model.ExportFilter
+		expected []string
+	}{
+		{"all in address order", model.ExportFilter{}, []string{"198.51.100.7", "203.0.113.3", "203.0.113.20"}},
+		{"listed only", model.ExportFilter{Listed: &listed}, []string{"198.51.100.7", "203.0.113.3"}},
+		{"clean only", model.ExportFilter{Listed: &clean}, []string{"203.0.113.20"}},
+		{"network", model.ExportFilter{Cidr: &cidr}, []string{"203.0.113.3", "203.0.113.20"}},
+		{"since", model.ExportFilter{Since: &feb}, []string{"198.51.100.7", "203.0.113.3"}},
+		{"until", model.ExportFilter{Until: &feb}, []string{"203.0.113.20"}},
+		{"limit", model.ExportFilter{Limit: &limit}, []string{"198.51.100.7"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addrs := []string{}
+			err := s.EachIPDetails(context.Background(), "red", test.filter, func(d *model.IPDetails) error {
+				addrs = append(addrs, d.IPAddress)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error exporting: %s", err.Error())
+			}
+			if !reflect.DeepEqual(addrs, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, addrs)
+			}
+		})
+	}
+
+	t.Run("stops on error", func(t *testing.T) {
+		stop := errors.New("stop")
+		calls := 0
+		err := s.EachIPDetails(context.Background(), "red", model.ExportFilter{}, func(d *model.IPDetails) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("expected to stop after the first record with its error, got %d calls and %v", calls, err)
+		}
+	})
+}
+
+func testImportIPDetails(t *testing.T, s Store) {
+	ctx := context.Background()
+	old := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	older := old.Add(-24 * time.Hour)
+	recent := time.Now().Add(-time.Hour).Truncate(time.Second)
+	add(t, s, "red", "203.0.113.1", "")
+
+	expectCode := func(t *testing.T, addr, code string) model.IPDetails {
+		t.Helper()
+		d, err := s.GetIPDetails(ctx, "red", addr)
+		if err != nil {
+			t.Fatalf("unexpected error getting %s: %s", addr, err.Error())
+		}
+		if d.ResponseCode != code {
+			t.Errorf("expected %s to have response code %q, got %q", addr, code, d.ResponseCode)
+		}
+		return d
+	}
+
+	res := importDetails(t, s, "red", model.ConflictPolicySkip,
+		model.IPDetails{IPAddress: "203.0.113.1", ResponseCode: "127.0.0.2", UpdatedAt: old},
+		model.IPDetails{IPAddress: "203.0.113.2", ResponseCode: "127.0.0.4", CreatedAt: older, UpdatedAt: old},
+	)
+	if res != (model.ImportResult{Imported: 1, Skipped: 1}) {
+		t.Errorf("expected one record imported and one skipped, got %+v", res)
+	}
+	expectCode(t, "203.0.113.1", "")
+	d := expectCode(t, "203.0.113.2", "127.0.0.4")
+	if !d.CreatedAt.Equal(older) || !d.UpdatedAt.Equal(old) {
+		t.Errorf("expected the imported times to be kept, got %s and %s", d.CreatedAt, d.UpdatedAt)
+	}
+
+	res = importDetails(t, s, "red", model.ConflictPolicyNewer,
+		model.IPDetails{IPAddress: "203.0.113.1", ResponseCode: "127.0.0.2", UpdatedAt: old},
+		model.IPDetails{IPAddress: "203.0.113.2", ResponseCode: "127.0.0.10", UpdatedAt: recent},
+	)
+	if res != (model.ImportResult{Imported: 1, Skipped: 1}) {
+		t.Errorf("expected only the newer record to be imported, got %+v", res)
+	}
+	expectCode(t, "203.0.113.1", "")
+	d = expectCode(t, "203.0.113.2", "127.0.0.10")
+	if !d.CreatedAt.Equal(older) || !d.UpdatedAt.Equal(recent) {
+		t.Errorf("expected the original creation time and the newer update time, got %s and %s", d.CreatedAt, d.UpdatedAt)
+	}
+
+	res = importDetails(t, s, "red", model.ConflictPolicyOverwrite,
+		model.IPDetails{IPAddress: "203.0.113.1", ResponseCode: "127.0.0.2", UpdatedAt: old},
+	)
+	if res != (model.ImportResult{Imported: 1}) {
+		t.Errorf("expected the record to be imported, got %+v", res)
+	}
+	expectCode(t, "203.0.113.1", "127.0.0.2")
+
+	t.Run("missing times", func(t *testing.T) {
+		before := time.Now().Add(-time.Second)
+		importDetails(t, s, "red", model.ConflictPolicySkip, model.IPDetails{IPAddress: "203.0.113.3"})
+		d := expectCode(t, "203.0.113.3", "")
+		if d.UpdatedAt.Before(before) || !d.CreatedAt.Equal(d.UpdatedAt) {
+			t.Errorf("expected the record to be created and updated now, got %s and %s", d.CreatedAt, d.UpdatedAt)
+		}
+	})
+
+	t.Run("future times", func(t *testing.T) {
+		future := time.Now().Add(24 * time.Hour)
+		importDetails(t, s, "red", model.ConflictPolicySkip,
+			model.IPDetails{IPAddress: "203.0.113.4", CreatedAt: future, UpdatedAt: future})
+		d := expectCode(t, "203.0.113.4", "")
+		if !d.UpdatedAt.Before(future) || !d.CreatedAt.Equal(d.UpdatedAt) {
+			t.Errorf("expected future times to be taken as now, got %s and %s", d.CreatedAt, d.UpdatedAt)
+		}
+	})
+
+	t.Run("all or nothing", func(t *testing.T) {
+		_, err := s.ImportIPDetails(ctx, "red", []model.IPDetails{{IPAddress: "203.0.113.9"}, {IPAddress: "bogus"}}, model.ConflictPolicySkip)
+		if err == nil {
+			t.Fatal("expected an error importing an invalid address")
+		}
+		_, err = s.GetIPDetails(ctx, "red", "203.0.113.9")
+		expectNotFound(t, err)
+
+		_, err = s.ImportIPDetails(ctx, "red", []model.IPDetails{{IPAddress: "203.0.113.9"}, {IPAddress: "203.0.113.10", ResponseCode: "clean"}}, model.ConflictPolicySkip)
+		if err == nil {
+			t.Fatal("expected an error importing an invalid response code")
+		}
+		_, err = s.GetIPDetails(ctx, "red", "203.0.113.9")
+		expectNotFound(t, err)
+	})
+
+	t.Run("tenant isolation", func(t *testing.T) {
+		_, err := s.GetIPDetails(ctx, "blue", "203.0.113.2")
+		expectNotFound(t, err)
+	})
+}
+
+func tagNames(tags []*model.Tag) []string {
+	names := []string{}
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return names
+}
+
+func testTags(t *testing.T, s Store) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Second)
+
+	tags, err := s.TagIP(ctx, "red", "203.0.113.1", []string{"relay", "customer"}, "alice")
+	if err != nil {
+		t.Fatalf("unexpected error tagging: %s", err.Error())
+	}
+	if names := tagNames(tags); !reflect.DeepEqual(names, []string{"customer", "relay"}) {
+		t.Errorf("expected the tags by name, got %v", names)
+	}
+	if tags[0].Author != "alice" || tags[0].CreatedAt.Before(before) {
+		t.Errorf("expected the tag to be credited to alice now, got %+v", tags[0])
+	}
+
+	tags, err = s.TagIP(ctx, "red", "203.0.113.1", []string{"relay", "ticket-123"}, "bob")
+	if err != nil {
+		t.Fatalf("unexpected error tagging: %s", err.Error())
+	}
+	if names := tagNames(tags); !reflect.DeepEqual(names, []string{"customer", "relay", "ticket-123"}) {
+		t.Errorf("expected the new tag to be added, got %v", names)
+	}
+	if tags[1].Author != "alice" || tags[2].Author != "bob" {
+		t.Errorf("expected existing tags to keep their author, got %s and %s", tags[1].Author, tags[2].Author)
+	}
+
+	tags, err = s.UntagIP(ctx, "red", "203.0.113.1", []string{"relay", "unknown"})
+	if err != nil {
+		t.Fatalf("unexpected error untagging: %s", err.Error())
+	}
+	if names := tagNames(tags); !reflect.DeepEqual(names, []string{"customer", "ticket-123"}) {
+		t.Errorf("expected the tag to be removed, got %v", names)
+	}
+
+	if _, err := s.TagIP(ctx, "red", "203.0.113.2", []string{"relay"}, "bob"); err != nil {
+		t.Fatalf("unexpected error tagging: %s", err.Error())
+	}
+	if _, err := s.TagIP(ctx, "blue", "203.0.113.3", []string{"relay"}, "carol"); err != nil {
+		t.Fatalf("unexpected error tagging: %s", err.Error())
+	}
+
+	byAddr, err := s.Tags(ctx, "red", []string{"203.0.113.1", "203.0.113.2", "203.0.113.3"})
+	if err != nil {
+		t.Fatalf("unexpected error getting tags: %s", err.Error())
+	}
+	if len(byAddr) != 2 {
+		t.Errorf("expected tags on two addresses, got %d", len(byAddr))
+	}
+	if names := tagNames(byAddr["203.0.113.1"]); !reflect.DeepEqual(names, []string{"customer", "ticket-123"}) {
+		t.Errorf("expected the first address's tags by name, got %v", names)
+	}
+	if names := tagNames(byAddr["203.0.113.2"]); !reflect.DeepEqual(names, []string{"relay"}) {
+		t.Errorf("expected the second address's tags, got %v", names)
+	}
+
+	byAddr, err = s.Tags(ctx, "blue", []string{"203.0.113.1"})
+	if err != nil || len(byAddr) != 0 {
+		t.Errorf("expected no tags in another tenant, got %v and %v", byAddr, err)
+	}
+	byAddr, err = s.Tags(ctx, "red", nil)
+	if err != nil || len(byAddr) != 0 {
+		t.Errorf("expected no tags without addresses, got %v and %v", byAddr, err)
+	}
+}
+
+func testNotes(t *testing.T, s Store) {
+	ctx := context.Background()
+	first, err := s.AddNote(ctx, "red", model.Note{IPAddress: "203.0.113.1", Body: "known customer relay", Author: "alice"})
+	if err != nil {
+		t.Fatalf("unexpected error adding note: %s", err.Error())
+	}
+	if first.ID == "" || first.CreatedAt.IsZero() || first.Author != "alice" {
+		t.Errorf("expected the note to be given an id and time, got %+v", first)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := s.AddNote(ctx, "red", model.Note{IPAddress: "203.0.113.1", Body: "see ticket 123", Author: "bob"}); err != nil {
+		t.Fatalf("unexpected error adding note: %s", err.Error())
+	}
+
+	if _, err := s.AddNote(ctx, "red", model.Note{IPAddress: "203.0.113.2", Body: "scanner", Author: "bob"}); err != nil {
+		t.Fatalf("unexpected error adding note: %s", err.Error())
+	}
+
+	byAddr, err := s.Notes(ctx, "red", []string{"203.0.113.1", "203.0.113.2", "203.0.113.3"})
+	if err != nil {
+		t.Fatalf("unexpected error getting notes: %s", err.Error())
+	}
+	notes := byAddr["203.0.113.1"]
+	if len(notes) != 2 || notes[0].ID != first.ID || notes[1].Body != "see ticket 123" || notes[1].IPAddress != "203.0.113.1" {
+		t.Errorf("expected both notes, oldest first, got %+v", notes)
+	}
+	if notes := byAddr["203.0.113.2"]; len(notes) != 1 || notes[0].Body != "scanner" {
+		t.Errorf("expected the second address's note, got %+v", notes)
+	}
+	if len(byAddr) != 2 {
+		t.Errorf("expected notes on two addresses, got %d", len(byAddr))
+	}
+
+	byAddr, err = s.Notes(ctx, "blue", []string{"203.0.113.1"})
+	if err != nil || len(byAddr) != 0 {
+		t.Errorf("expected no notes in another tenant, got %+v and %v", byAddr, err)
+	}
+}
+
+func testAnnotationFilters(t *testing.T, s Store) {
+	ctx := context.Background()
+	add(t, s, "red", "203.0.113.1", "127.0.0.2")
+	add(t, s, "red", "203.0.113.2", "")
+	add(t, s, "red", "203.0.113.3", "")
+	add(t, s, "blue", "203.0.113.1", "")
+	if _, err := s.TagIP(ctx, "red", "203.0.113.1", []string{"relay"}, "alice"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := s.TagIP(ctx, "red", "203.0.113.2", []string{"relay", "vpn"}, "alice"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := s.TagIP(ctx, "blue", "203.0.113.1", []string{"vpn"}, "alice"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := s.AddNote(ctx, "red", model.Note{IPAddress: "203.0.113.3", Body: "see ticket 123", Author: "alice"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	relay, vpn, ticket, missing := "relay", "vpn", "ticket 123", "ticket 456"
+	tests := []struct {
+		name     string
+		tag      *string
+		note     *string
+		expected []string
+	}{
+		{"tag", &relay, nil, []string{"203.0.113.1", "203.0.113.2"}},
+		{"another tag", &vpn, nil, []string{"203.0.113.2"}},
+		{"note", nil, &ticket, []string{"203.0.113.3"}},
+		{"tag and note", &relay, &ticket, []string{}},
+		{"no matching note", nil, &missing, []string{}},
+	}
+
+	_, network, _ := net.ParseCIDR("203.0.113.0/24")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found, err := s.IPDetailsInNetwork(ctx, "red", network, model.NetworkFilter{Tag: test.tag, Note: test.note})
+			if err != nil {
+				t.Fatalf("unexpected error searching network: %s", err.Error())
+			}
+			addrs := []string{}
+			for _, d := range found {
+				addrs = append(addrs, d.IPAddress)
+			}
+			if !reflect.DeepEqual(addrs, test.expected) {
+				t.Errorf("expected %v in the network, got %v", test.expected, addrs)
+			}
+
+			addrs = []string{}
+			err = s.EachIPDetails(ctx, "red", model.ExportFilter{Tag: test.tag, Note: test.note}, func(d *model.IPDetails) error {
+				addrs = append(addrs, d.IPAddress)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error exporting: %s", err.Error())
+			}
+			if !reflect.DeepEqual(addrs, test.expected) {
+				t.Errorf("expected %v to be exported, got %v", test.expected, addrs)
+			}
+		})
+	}
+}
+
+func testSharedResult(t *testing.T, s Store) {
+	since := time.Now().Add(-time.Minute)
+
+	_, ok, err := s.SharedResult(context.Background(), "127.0.0.1", since)
+	if err != nil || ok {
+		t.Errorf("expected no shared result yet, got %v, %v", ok, err)
+	}
+
+	add(t, s, "red", "127.0.0.1", "127.0.0.2")
+	time.Sleep(2 * time.Millisecond)
+	add(t, s, "blue", "127.0.0.1", "127.0.0.4")
+
+	code, ok, err := s.SharedResult(context.Background(), "127.0.0.1", since)
+	if err != nil || !ok || code != "127.0.0.4" {
+		t.Errorf("expected the most recent result from any tenant, got %q, %v, %v", code, ok, err)
+	}
+
+	_, ok, err = s.SharedResult(context.Background(), "127.0.0.1", time.Now().Add(time.Minute))
+	if err != nil || ok {
+		t.Errorf("expected results older than since to be ignored, got %v, %v", ok, err)
+	}
+
+	// records a tenant imports, even with a later time, aren't lookups and mustn't be
+	// reused for other tenants
+	importDetails(t, s, "red", model.ConflictPolicyOverwrite,
+		model.IPDetails{IPAddress: "127.0.0.1", ResponseCode: "", UpdatedAt: time.Now()},
+		model.IPDetails{IPAddress: "127.0.0.3", ResponseCode: "", UpdatedAt: time.Now()},
+	)
+	code, ok, err = s.SharedResult(context.Background(), "127.0.0.1", since)
+	if err != nil || !ok || code != "127.0.0.4" {
+		t.Errorf("expected imported records not to be shared, got %q, %v, %v", code, ok, err)
+	}
+	_, ok, err = s.SharedResult(context.Background(), "127.0.0.3", since)
+	if err != nil || ok {
+		t.Errorf("expected an address that was only imported to have no shared result, got %v, %v", ok, err)
+	}
+}
+
+func testCancelledContext(t *testing.T, s Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := s.AddIPDetails(ctx, "red", model.IPDetails{IPAddress: "127.0.0.1"}); err == nil {
+		t.Error("expected adding details with a cancelled context to fail")
+	}
+	if _, err := s.GetIPDetails(ctx, "red", "127.0.0.1"); err == nil {
+		t.Error("expected getting details with a cancelled context to fail")
+	}
+	if _, _, err := s.SharedResult(ctx, "127.0.0.1", time.Now()); err == nil {
+		t.Error("expected checking for a shared result with a cancelled context to fail")
+	}
+	if _, err := s.DeleteIPDetails(ctx, "red", []string{"127.0.0.1"}); err == nil {
+		t.Error("expected deleting details with a cancelled context to fail")
+	}
+	if _, err := s.GetStats(ctx, "red", time.Now()); err == nil {
+		t.Error("expected getting stats with a cancelled context to fail")
+	}
+	if _, err := s.CreateAPIKey(ctx, "red", model.APIKey{Name: "ci"}, "hash"); err == nil {
+		t.Error("expected creating an api key with a cancelled context to fail")
+	}
+	if _, err := s.ListAPIKeys(ctx, "red"); err == nil {
+		t.Error("expected listing api keys with a cancelled context to fail")
+	}
+	if _, err := s.LookupAPIKey(ctx, "hash"); err == nil {
+		t.Error("expected looking up an api key with a cancelled context to fail")
+	}
+	if err := s.AddAuditEntry(ctx, "red", model.AuditEntry{Principal: "alice", Operation: model.AuditOperationCheck, Arguments: "{}"}); err == nil {
+		t.Error("expected adding an audit entry with a cancelled context to fail")
+	}
+	if err := s.EachAuditEntry(ctx, "red", model.AuditLogFilter{}, func(*model.AuditEntry) error { return nil }); err == nil {
+		t.Error("expected reading the audit log with a cancelled context to fail")
+	}
+
+	if _, err := s.GetIPDetails(context.Background(), "red", "127.0.0.1"); err == nil {
+		t.Error("expected nothing to have been stored")
+	}
+	if keys, err := s.ListAPIKeys(context.Background(), "red"); err != nil || len(keys) != 0 {
+		t.Errorf("expected no api key to have been stored, got %v, %v", keys, err)
+	}
+}
+
+func testStats(t *testing.T, s Store) {
+	add(t, s, "red", "203.0.113.1", "127.0.0.2,127.0.0.4")
+	add(t, s, "red", "203.0.113.2", "127.0.0.2")
+	add(t, s, "red", "198.51.100.1", "127.0.0.2")
+	add(t, s, "red", "192.0.2.1", "")
+	add(t, s, "red", "192.0.2.1", "")
+	add(t, s, "blue", "192.0.2.1", "")
+
+	stats, err := s.GetStats(context.Background(), "red", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error getting stats: %s", err.Error())
+	}
+
+	if stats.Total != 4 || stats.Listed != 3 || stats.Clean != 1 {
+		t.Errorf("unexpected totals: %+v", stats)
+	}
+
+	if len(stats.ByListing) != 2 ||
+		*stats.ByListing[0] != (model.ListingCount{Listing: "127.0.0.2", Count: 3}) ||
+		*stats.ByListing[1] != (model.ListingCount{Listing: "127.0.0.4", Count: 1}) {
+		t.Errorf("unexpected counts by listing: %v", stats.ByListing)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	if len(stats.LookupsPerDay) != 1 || *stats.LookupsPerDay[0] != (model.DailyLookups{Day: today, Count: 5}) {
+		t.Errorf("expected 5 lookups on %s, got %v", today, stats.LookupsPerDay)
+	}
+
+	if len(stats.TopNetworks) != 2 ||
+		*stats.TopNetworks[0] != (model.NetworkCount{Network: "203.0.113.0/24", Listed: 2}) ||
+		*stats.TopNetworks[1] != (model.NetworkCount{Network: "198.51.100.0/24", Listed: 1}) {
+		t.Errorf("unexpected top networks: %v", stats.TopNetworks)
+	}
+
+	stats, err = s.GetStats(context.Background(), "red", time.Now().Add(time.Hour))
+	if err != nil || stats.Total != 0 {
+		t.Errorf("expected no records updated since the future, got %+v, %v", stats, err)
+	}
+}
+
+func testAPIKeys(t *testing.T, s Store) {
+	created, err := s.CreateAPIKey(context.Background(), "red", model.APIKey{
+		Name:   "importer",
+		Owner:  "alice",
+		Scopes: []model.APIKeyScope{model.APIKeyScopeRead, model.APIKeyScopeEnqueue},
+	}, "hash")
+	if err != nil {
+		t.Fatalf("unexpected error creating key: %s", err.Error())
+	}
+	if created.ID == "" || created.CreatedAt.IsZero() || created.Name != "importer" {
+		t.Errorf("unexpected created key: %+v", created)
+	}
+
+	keys, err := s.ListAPIKeys(context.Background(), "red")
+	if err != nil || len(keys) != 1 || keys[0].ID != created.ID || len(keys[0].Scopes) != 2 {
+		t.Errorf("expected to list the created key, got %v, %v", keys, err)
+	}
+	keys, err = s.ListAPIKeys(context.Background(), "blue")
+	if err != nil || len(keys) != 0 {
+		t.Errorf("expected another tenant to have no keys, got %v, %v", keys, err)
+	}
+
+	record, err := s.LookupAPIKey(context.Background(), "hash")
+	if err != nil {
+		t.Fatalf("unexpected error looking up key: %s", err.Error())
+	}
+	if record.Owner != "alice" || record.Tenant != "red" || len(record.Scopes) != 2 || record.Scopes[0] != "read" || record.RevokedAt != nil {
+		t.Errorf("unexpected key record: %+v", record)
+	}
+	if _, err := s.LookupAPIKey(context.Background(), "other"); err == nil {
+		t.Error("expected an error looking up an unknown key")
+	}
+
+	_, err = s.RevokeAPIKey(context.Background(), "blue", created.ID)
+	expectNotFound(t, err)
+
+	revoked, err := s.RevokeAPIKey(context.Background(), "red", created.ID)
+	if err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("expected the key to be revoked, got %+v, %v", revoked, err)
+	}
+	again, err := s.RevokeAPIKey(context.Background(), "red", created.ID)
+	if err != nil || again.RevokedAt == nil || !again.RevokedAt.Equal(*revoked.RevokedAt) {
+		t.Errorf("expected revoking again to keep the original time, got %+v, %v", again, err)
+	}
+
+	record, _ = s.LookupAPIKey(context.Background(), "hash")
+	if record.RevokedAt == nil {
+		t.Error("expected the looked up key to be revoked")
+	}
+}
+
+func testAuditLog(t *testing.T, s Store) {
+	keyID := "key-1"
+	entries := []model.AuditEntry{
+		{Principal: "alice", ClientIP: "10.0.0.1", Operation: model.AuditOperationEnqueue, Arguments: `{"ip":["127.0.0.1","127.0.0.2"]}`},
+		{Principal: "bob", ClientIP: "10.0.0.2", Operation: model.AuditOperationGetIPDetails, Arguments: `{"ip":"127.0.0.12"}`},
+		{Principal: "alice", APIKeyID: &keyID, ClientIP: "10.0.0.1", Operation: model.AuditOperationCheck, Arguments: `{"ip":["127.0.0.2"]}`},
+	}
+	start := time.Now().Add(-time.Second)
+	for _, entry := range entries {
+		if err := s.AddAuditEntry(context.Background(), "red", entry); err != nil {
+			t.Fatalf("unexpected error adding audit entry: %s", err.Error())
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := s.AddAuditEntry(context.Background(), "blue", entries[0]); err != nil {
+		t.Fatalf("unexpected error adding audit entry: %s", err.Error())
+	}
+
+	alice := "alice"
+	check := model.AuditOperationCheck
+	ip := "127.0.0.1"
+	one := 1
+	future := time.Now().Add(time.Hour)
+	testCases := []struct {
+		name     string
+		filter   model.AuditLogFilter
+		expected []model.AuditOperation
+	}{
+		{"all, newest first", model.AuditLogFilter{}, []model.AuditOperation{model.AuditOperationCheck, model.AuditOperationGetIPDetails, model.AuditOperationEnqueue}},
+		{"principal", model.AuditLogFilter{Principal: &alice}, []model.AuditOperation{model.AuditOperationCheck, model.AuditOperationEnqueue}},
+		{"operation", model.AuditLogFilter{Operation: &check}, []model.AuditOperation{model.AuditOperationCheck}},
+		{"whole ip", model.AuditLogFilter{IP: &ip}, []model.AuditOperation{model.AuditOperationEnqueue}},
+		{"time range", model.AuditLogFilter{Since: &start, Until: &future}, []model.AuditOperation{model.AuditOperationCheck, model.AuditOperationGetIPDetails, model.AuditOperationEnqueue}},
+		{"since future", model.AuditLogFilter{Since: &future}, nil},
+		{"limit", model.AuditLogFilter{Limit: &one}, []model.AuditOperation{model.AuditOperationCheck}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var ops []model.AuditOperation
+			err := s.EachAuditEntry(context.Background(), "red", test.filter, func(entry *model.AuditEntry) error {
+				ops = append(ops, entry.Operation)
+				if withKey := entry.Operation == model.AuditOperationCheck; withKey != (entry.APIKeyID != nil && *entry.APIKeyID == keyID) {
+					t.Errorf("unexpected api key %v on %s entry", entry.APIKeyID, entry.Operation)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error reading audit log: %s", err.Error())
+			}
+			if fmt.Sprint(ops) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, ops)
+			}
+		})
+	}
+}
+
+func testPrune(t *testing.T, s Store) {
+	add(t, s, "red", "127.0.0.1", "")
+	add(t, s, "blue", "127.0.0.3", "")
+	add(t, s, "red", "127.0.0.2", "127.0.0.4")
+	if err := s.AddAuditEntry(context.Background(), "red", model.AuditEntry{Principal: "alice", Operation: model.AuditOperationCheck, Arguments: "{}"}); err != nil {
+		t.Fatalf("unexpected error adding audit entry: %s", err.Error())
+	}
+
+	policy := db.RetentionPolicy{Clean: 24 * time.Hour, Listed: 72 * time.Hour, AuditLog: 24 * time.Hour, History: 24 * time.Hour}
+	later := time.Now().Add(48 * time.Hour)
+	expected := db.PruneReport{Clean: 2, Listed: 0, AuditEntries: 1, Lookups: 3}
+
+	report, err := s.Prune(context.Background(), policy, later, true)
+	if err != nil {
+		t.Fatalf("unexpected error in dry run: %s", err.Error())
+	}
+	if report != expected {
+		t.Errorf("expected the dry run to report %+v, got %+v", expected, report)
+	}
+	if _, err := s.GetIPDetails(context.Background(), "red", "127.0.0.1"); err != nil {
+		t.Errorf("expected a dry run to keep everything, got %s", err.Error())
+	}
+
+	report, err = s.Prune(context.Background(), policy, later, false)
+	if err != nil {
+		t.Fatalf("unexpected error pruning: %s", err.Error())
+	}
+	if report != expected {
+		t.Errorf("expected %+v to be pruned, got %+v", expected, report)
+	}
+
+	_, err = s.GetIPDetails(context.Background(), "red", "127.0.0.1")
+	expectNotFound(t, err)
+	_, err = s.GetIPDetails(context.Background(), "blue", "127.0.0.3")
+	expectNotFound(t, err)
+	if _, err := s.GetIPDetails(context.Background(), "red", "127.0.0.2"); err != nil {
+		t.Errorf("expected the listed result to be kept, got %s", err.Error())
+	}
+
+	var remaining int
+	err = s.EachAuditEntry(context.Background(), "red", model.AuditLogFilter{}, func(*model.AuditEntry) error {
+		remaining++
+		return nil
+	})
+	if err != nil || remaining != 0 {
+		t.Errorf("expected the audit entry to be pruned, got %d, %v", remaining, err)
+	}
+
+	stats, err := s.GetStats(context.Background(), "red", time.Time{})
+	if err != nil || len(stats.LookupsPerDay) != 0 {
+		t.Errorf("expected the lookup history to be pruned, got %v, %v", stats.LookupsPerDay, err)
+	}
+
+	if report, err := s.Prune(context.Background(), db.RetentionPolicy{}, later, false); err != nil || report != (db.PruneReport{}) {
+		t.Errorf("expected an empty policy to keep everything, got %+v, %v", report, err)
+	}
+	if err := s.Compact(context.Background()); err != nil {
+		t.Errorf("unexpected error compacting: %s", err.Error())
+	}
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// dialect captures the SQL that differs between the databases Client supports.  Queries
+// are otherwise written once, with "?" placeholders rebound for the driver.
+type dialect struct {
+	// name is also the directory under migrations holding the dialect's migrations.
+	name string
+	// schemaVersionStmt creates the table recording applied migrations.
+	schemaVersionStmt string
+	// timestamp wraps a time column or placeholder so that it compares chronologically.
+	timestamp func(expr string) string
+	// day formats a time column as a "YYYY-MM-DD" date in UTC.
+	day func(expr string) string
+	// position returns the 1-based index of substr in s, or 0 if it isn't found.
+	position func(s, substr string) string
+	// lockMigrations serializes migrations run by several servers at once.
+	lockMigrations func(tx *sqlx.Tx) error
+	// beforeMigration holds steps that can't be written in SQL, run in the same
+	// transaction just before the migration with the same version.
+	beforeMigration map[int]func(tx *sqlx.Tx) error
+	// afterMigration likewise holds steps run just after the migration.
+	afterMigration map[int]func(tx *sqlx.Tx) error
+	// compactStmts reclaim the space left by deleted rows.
+	compactStmts []string
+	// backupStmt copies the live database to the file named by its parameter, or is
+	// empty if the server can't back the database up.
+	backupStmt string
+}
+
+var sqliteDialect = dialect{
+	name: "sqlite",
+	schemaVersionStmt: `CREATE TABLE IF NOT EXISTS schema_version
+(
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at DATETIME NOT NULL
+);`,
+	// go-sqlite3 stores times as text, which only sorts correctly in the same zone
+	timestamp: func(expr string) string { return fmt.Sprintf("julianday(%s)", expr) },
+	day:       func(expr string) string { return fmt.Sprintf("date(%s)", expr) },
+	position:  func(s, substr string) string { return fmt.Sprintf("instr(%s, %s)", s, substr) },
+	// transactions are opened with BEGIN IMMEDIATE, which already excludes other writers
+	lockMigrations: func(tx *sqlx.Tx) error { return nil },
+	beforeMigration: map[int]func(tx *sqlx.Tx) error{
+		1: adoptLegacySchema,
+	},
+	afterMigration: map[int]func(tx *sqlx.Tx) error{
+		3: fillIPBytes,
+	},
+	// VACUUM writes the rebuilt database through the WAL, so checkpoint afterwards to
+	// truncate it
+	compactStmts: []string{"VACUUM", "PRAGMA wal_checkpoint(TRUNCATE)"},
+	backupStmt:   "VACUUM INTO ?",
+}
+
+// migrationLockID is an arbitrary key for the advisory lock held while migrating.
+const migrationLockID = 4815162342
+
+var postgresDialect = dialect{
+	name: "postgres",
+	schemaVersionStmt: `CREATE TABLE IF NOT EXISTS schema_version
+(
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL
+);`,
+	timestamp: func(expr string) string { return expr },
+	day: func(expr string) string {
+		return fmt.Sprintf("to_char(%s AT TIME ZONE 'UTC', 'YYYY-MM-DD')", expr)
+	},
+	position: func(s, substr string) string { return fmt.Sprintf("strpos(%s, %s)", s, substr) },
+	lockMigrations: func(tx *sqlx.Tx) error {
+		_, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockID)
+		return err
+	},
+	beforeMigration: map[int]func(tx *sqlx.Tx) error{},
+	afterMigration: map[int]func(tx *sqlx.Tx) error{
+		3: fillIPBytes,
+	},
+}
@@ -0,0 +1,6 @@
+package db
+
+// UseRealDatabases undoes the mock openers installed by the package's unit tests.
+func UseRealDatabases() {
+	sqliteDbOpener = openSqlite
+}
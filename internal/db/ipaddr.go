@@ -0,0 +1,42 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// Addresses are also stored as 16 bytes, with IPv4 addresses in their IPv4-mapped IPv6
+// form, so that comparing the bytes orders addresses numerically and a network is a
+// contiguous range of them.
+
+// ipKey returns the 16 byte form of addr.
+func ipKey(addr string) ([]byte, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip address %q", addr)
+	}
+	return []byte(ip.To16()), nil
+}
+
+// networkRange returns the first and last addresses in network in their 16 byte form.
+func networkRange(network *net.IPNet) ([]byte, []byte) {
+	first := network.IP.To16()
+	mask := network.Mask
+	if len(mask) == net.IPv4len {
+		mask = append(bytes.Repeat([]byte{0xff}, net.IPv6len-net.IPv4len), mask...)
+	}
+
+	start := make([]byte, net.IPv6len)
+	end := make([]byte, net.IPv6len)
+	for i := range start {
+		start[i] = first[i] & mask[i]
+		end[i] = first[i] | ^mask[i]
+	}
+	return start, end
+}
+
+// inRange reports whether key lies between start and end inclusive.
+func inRange(key, start, end []byte) bool {
+	return bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) <= 0
+}
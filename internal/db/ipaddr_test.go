@@ -0,0 +1,58 @@
+package db
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestNetworkRange(t *testing.T) {
+	testCases := []struct {
+		cidr  string
+		start string
+		end   string
+	}{
+		{"203.0.113.0/24", "203.0.113.0", "203.0.113.255"},
+		{"203.0.113.77/32", "203.0.113.77", "203.0.113.77"},
+		{"10.0.0.0/8", "10.0.0.0", "10.255.255.255"},
+		{"0.0.0.0/0", "0.0.0.0", "255.255.255.255"},
+		{"2001:db8::/32", "2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{"2001:db8::1/128", "2001:db8::1", "2001:db8::1"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.cidr, func(t *testing.T) {
+			_, network, err := net.ParseCIDR(test.cidr)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			start, end := networkRange(network)
+			if !net.IP(start).Equal(net.ParseIP(test.start)) || !net.IP(end).Equal(net.ParseIP(test.end)) {
+				t.Errorf("expected %s - %s, got %s - %s", test.start, test.end, net.IP(start), net.IP(end))
+			}
+		})
+	}
+}
+
+func TestIPKeyOrdersNumerically(t *testing.T) {
+	ordered := []string{"1.2.3.4", "9.0.0.1", "10.0.0.1", "203.0.113.7", "255.255.255.255", "2001:db8::1", "2001:db8::10"}
+
+	for i := 1; i < len(ordered); i++ {
+		a, err := ipKey(ordered[i-1])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		b, err := ipKey(ordered[i])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if bytes.Compare(a, b) >= 0 {
+			t.Errorf("expected %s to sort before %s", ordered[i-1], ordered[i])
+		}
+	}
+
+	if _, err := ipKey("not an address"); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
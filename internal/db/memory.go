@@ -0,0 +1,675 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/dnsbl"
+)
+
+// MemoryPath is the database path that selects a MemoryStore instead of SQLite.
+const MemoryPath = ":memory:"
+
+// MemoryStore keeps everything a Client stores in memory, for tests and throwaway
+// deployments.  It is safe for concurrent use, and its contents are lost when the
+// process exits.  Like a Client, it fails operations whose context is already done.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	details map[string]map[string]IPDetails // by tenant, then address
+	apiKeys []APIKey                        // in order of creation
+	audit   []AuditEntry                    // in order of creation
+	tags    map[address]map[string]Tag      // by name
+	notes   map[address][]Note              // in order of creation
+	lookups []Lookup                        // in order of creation
+}
+
+// address identifies one of a tenant's addresses.
+type address struct {
+	tenant string
+	addr   string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		details: map[string]map[string]IPDetails{},
+		tags:    map[address]map[string]Tag{},
+		notes:   map[address][]Note{},
+	}
+}
+
+// Close does nothing; it lets a MemoryStore stand in for a Client.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// AddIPDetails adds or updates tenant's record for details.IPAddress, keeping the
+// original id and created_at of an existing record, and records the lookup.
+func (m *MemoryStore) AddIPDetails(ctx context.Context, tenant string, details model.IPDetails) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key, err := ipKey(details.IPAddress)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	records, ok := m.details[tenant]
+	if !ok {
+		records = map[string]IPDetails{}
+		m.details[tenant] = records
+	}
+
+	record, ok := records[details.IPAddress]
+	if !ok {
+		record = IPDetails{UUID: uuid.New().String(), CreatedAt: now, IPAddress: details.IPAddress, Tenant: tenant, IPBytes: key}
+	}
+	record.UpdatedAt = now
+	record.ResponseCode = details.ResponseCode
+	records[details.IPAddress] = record
+	m.lookups = append(m.lookups, newLookup(tenant, details, now))
+	return nil
+}
+
+func (m *MemoryStore) GetIPDetails(ctx context.Context, tenant string, addr string) (model.IPDetails, error) {
+	if err := ctx.Err(); err != nil {
+		return model.IPDetails{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, ok := m.details[tenant][addr]
+	if !ok {
+		return model.IPDetails{}, newErrNotFound(addr, sql.ErrNoRows)
+	}
+	return dbModelToGraphQL(record), nil
+}
+
+// IPDetailsInNetwork returns tenant's records for addresses within network in address
+// order, limited to those matching filter.
+func (m *MemoryStore) IPDetailsInNetwork(ctx context.Context, tenant string, network *net.IPNet, filter model.NetworkFilter) ([]*model.IPDetails, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	start, end := networkRange(network)
+	var matched []IPDetails
+	for _, record := range m.details[tenant] {
+		if !inRange(record.IPBytes, start, end) ||
+			(filter.Listed != nil && *filter.Listed != (record.ResponseCode != "")) ||
+			!m.annotated(tenant, record.IPAddress, filter.Tag, filter.Note) {
+			continue
+		}
+		matched = append(matched, record)
+	}
+	sort.Slice(matched, func(i, j int) bool { return bytes.Compare(matched[i].IPBytes, matched[j].IPBytes) < 0 })
+	if filter.Limit != nil && len(matched) > *filter.Limit {
+		matched = matched[:*filter.Limit]
+	}
+
+	res := make([]*model.IPDetails, 0, len(matched))
+	for _, record := range matched {
+		converted := dbModelToGraphQL(record)
+		res = append(res, &converted)
+	}
+	return res, nil
+}
+
+// EachIPDetails calls fn with each of tenant's records matching filter in address order.
+// fn is called without holding the store's lock.
+func (m *MemoryStore) EachIPDetails(ctx context.Context, tenant string, filter model.ExportFilter, fn func(*model.IPDetails) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var start, end []byte
+	if filter.Cidr != nil {
+		_, network, err := net.ParseCIDR(*filter.Cidr)
+		if err != nil {
+			return fmt.Errorf("%s is not a CIDR network", *filter.Cidr)
+		}
+		start, end = networkRange(network)
+	}
+
+	m.mu.RLock()
+	var matched []IPDetails
+	for _, record := range m.details[tenant] {
+		switch {
+		case filter.Cidr != nil && !inRange(record.IPBytes, start, end),
+			filter.Listed != nil && *filter.Listed != (record.ResponseCode != ""),
+			!m.annotated(tenant, record.IPAddress, filter.Tag, filter.Note),
+			filter.Since != nil && record.UpdatedAt.Before(*filter.Since),
+			filter.Until != nil && !record.UpdatedAt.Before(*filter.Until):
+			continue
+		}
+		matched = append(matched, record)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool { return bytes.Compare(matched[i].IPBytes, matched[j].IPBytes) < 0 })
+	if filter.Limit != nil && len(matched) > *filter.Limit {
+		matched = matched[:*filter.Limit]
+	}
+
+	for _, record := range matched {
+		converted := dbModelToGraphQL(record)
+		if err := fn(&converted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportIPDetails stores records for tenant, resolving conflicts with existing records by
+// policy.  Either all of the records are stored or none are.
+func (m *MemoryStore) ImportIPDetails(ctx context.Context, tenant string, records []model.IPDetails, policy model.ConflictPolicy) (model.ImportResult, error) {
+	var res model.ImportResult
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	if !policy.IsValid() {
+		return res, fmt.Errorf("unknown conflict policy %q", policy)
+	}
+
+	imported := make([]IPDetails, 0, len(records))
+	now := time.Now()
+	for _, d := range records {
+		key, err := ipKey(d.IPAddress)
+		if err != nil {
+			return res, err
+		}
+		if err := dnsbl.ValidateResponseCode(d.ResponseCode); err != nil {
+			return res, err
+		}
+		created, updated := importTimes(d, now)
+		imported = append(imported, IPDetails{
+			UUID:         uuid.New().String(),
+			CreatedAt:    created,
+			UpdatedAt:    updated,
+			ResponseCode: d.ResponseCode,
+			IPAddress:    d.IPAddress,
+			Tenant:       tenant,
+			IPBytes:      key,
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.details[tenant]
+	if !ok {
+		existing = map[string]IPDetails{}
+		m.details[tenant] = existing
+	}
+	for _, record := range imported {
+		stored, ok := existing[record.IPAddress]
+		switch {
+		case !ok:
+			existing[record.IPAddress] = record
+		case policy == model.ConflictPolicyOverwrite,
+			policy == model.ConflictPolicyNewer && record.UpdatedAt.After(stored.UpdatedAt):
+			stored.UpdatedAt = record.UpdatedAt
+			stored.ResponseCode = record.ResponseCode
+			existing[record.IPAddress] = stored
+		default:
+			res.Skipped++
+			continue
+		}
+		res.Imported++
+	}
+	return res, nil
+}
+
+// SharedResult returns the response code of the most recent lookup of addr by any
+// tenant made at or after since.
+func (m *MemoryStore) SharedResult(ctx context.Context, addr string, since time.Time) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	var newest *Lookup
+	for i, lookup := range m.lookups {
+		if lookup.IPAddress != addr || lookup.LookedUpAt.Before(since) || lookup.LookedUpAt.After(now) {
+			continue
+		}
+		if newest == nil || lookup.LookedUpAt.After(newest.LookedUpAt) {
+			newest = &m.lookups[i]
+		}
+	}
+	if newest == nil {
+		return "", false, nil
+	}
+	return newest.ResponseCode, true, nil
+}
+
+// DeleteIPDetails removes tenant's records for addrs, returning how many were found.
+func (m *MemoryStore) DeleteIPDetails(ctx context.Context, tenant string, addrs []string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, addr := range addrs {
+		if _, ok := m.details[tenant][addr]; ok {
+			delete(m.details[tenant], addr)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// GetStats summarizes tenant's records updated at or after since, and the lookups made
+// since then, in the same way as Client.GetStats.
+func (m *MemoryStore) GetStats(ctx context.Context, tenant string, since time.Time) (model.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Stats{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := model.Stats{
+		ByListing:     []*model.ListingCount{},
+		LookupsPerDay: []*model.DailyLookups{},
+		TopNetworks:   []*model.NetworkCount{},
+	}
+
+	codes := map[string]int{}
+	days := map[string]int{}
+	networks := map[string]int{}
+	for _, record := range m.details[tenant] {
+		if record.UpdatedAt.Before(since) {
+			continue
+		}
+
+		res.Total++
+		if record.ResponseCode == "" {
+			continue
+		}
+
+		res.Listed++
+		for _, code := range strings.Split(record.ResponseCode, ",") {
+			if code != "" {
+				codes[code]++
+			}
+		}
+		if !strings.Contains(record.IPAddress, ":") {
+			networks[strings.TrimRight(record.IPAddress, "0123456789")+"0/24"]++
+		}
+	}
+	res.Clean = res.Total - res.Listed
+
+	for _, l := range m.lookups {
+		if l.Tenant == tenant && !l.LookedUpAt.Before(since) {
+			days[l.LookedUpAt.UTC().Format("2006-01-02")]++
+		}
+	}
+
+	for code, count := range codes {
+		res.ByListing = append(res.ByListing, &model.ListingCount{Listing: code, Count: count})
+	}
+	sort.Slice(res.ByListing, func(i, j int) bool {
+		a, b := res.ByListing[i], res.ByListing[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Listing < b.Listing)
+	})
+
+	for day, count := range days {
+		res.LookupsPerDay = append(res.LookupsPerDay, &model.DailyLookups{Day: day, Count: count})
+	}
+	sort.Slice(res.LookupsPerDay, func(i, j int) bool { return res.LookupsPerDay[i].Day < res.LookupsPerDay[j].Day })
+
+	for network, listed := range networks {
+		res.TopNetworks = append(res.TopNetworks, &model.NetworkCount{Network: network, Listed: listed})
+	}
+	sort.Slice(res.TopNetworks, func(i, j int) bool {
+		a, b := res.TopNetworks[i], res.TopNetworks[j]
+		return a.Listed > b.Listed || (a.Listed == b.Listed && a.Network < b.Network)
+	})
+	if len(res.TopNetworks) > topNetworksLimit {
+		res.TopNetworks = res.TopNetworks[:topNetworksLimit]
+	}
+
+	return res, nil
+}
+
+// Prune removes the records policy no longer keeps as of now, in the same way as
+// Client.Prune.
+func (m *MemoryStore) Prune(ctx context.Context, policy RetentionPolicy, now time.Time, dryRun bool) (PruneReport, error) {
+	var report PruneReport
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expired := func(t time.Time, keep time.Duration) bool {
+		return keep > 0 && t.Before(now.Add(-keep))
+	}
+
+	for _, records := range m.details {
+		for addr, record := range records {
+			switch {
+			case record.ResponseCode == "" && expired(record.UpdatedAt, policy.Clean):
+				report.Clean++
+			case record.ResponseCode != "" && expired(record.UpdatedAt, policy.Listed):
+				report.Listed++
+			default:
+				continue
+			}
+			if !dryRun {
+				delete(records, addr)
+			}
+		}
+	}
+
+	kept := m.audit[:0]
+	for _, e := range m.audit {
+		if expired(e.CreatedAt, policy.AuditLog) {
+			report.AuditEntries++
+			if !dryRun {
+				continue
+			}
+		}
+		kept = append(kept, e)
+	}
+	m.audit = kept
+
+	lookups := m.lookups[:0]
+	for _, l := range m.lookups {
+		if expired(l.LookedUpAt, policy.History) {
+			report.Lookups++
+			if !dryRun {
+				continue
+			}
+		}
+		lookups = append(lookups, l)
+	}
+	m.lookups = lookups
+
+	return report, nil
+}
+
+// Compact does nothing; there is no file to shrink.
+func (m *MemoryStore) Compact(ctx context.Context) error {
+	return nil
+}
+
+// Backup always fails; the store's contents aren't meant to outlive it.
+func (m *MemoryStore) Backup(ctx context.Context, path string) error {
+	return fmt.Errorf("the in-memory store can't be backed up")
+}
+
+func (m *MemoryStore) CreateAPIKey(ctx context.Context, tenant string, key model.APIKey, keyHash string) (model.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return model.APIKey{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.KeyHash == keyHash {
+			return model.APIKey{}, fmt.Errorf("error inserting api key: duplicate key hash")
+		}
+	}
+
+	stored := newAPIKey(tenant, key, keyHash)
+	m.apiKeys = append(m.apiKeys, stored)
+	return dbAPIKeyToGraphQL(stored), nil
+}
+
+func (m *MemoryStore) ListAPIKeys(ctx context.Context, tenant string) ([]*model.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := []*model.APIKey{}
+	for _, k := range m.apiKeys {
+		if k.Tenant == tenant {
+			converted := dbAPIKeyToGraphQL(k)
+			res = append(res, &converted)
+		}
+	}
+	return res, nil
+}
+
+// RevokeAPIKey marks one of tenant's keys as revoked, keeping the original revocation
+// time of an already revoked key.
+func (m *MemoryStore) RevokeAPIKey(ctx context.Context, tenant string, id string) (model.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return model.APIKey{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, k := range m.apiKeys {
+		if k.Tenant != tenant || k.ID != id {
+			continue
+		}
+		if k.RevokedAt == nil {
+			now := time.Now()
+			m.apiKeys[i].RevokedAt = &now
+		}
+		return dbAPIKeyToGraphQL(m.apiKeys[i]), nil
+	}
+	return model.APIKey{}, newErrAPIKeyNotFound(id, sql.ErrNoRows)
+}
+
+// LookupAPIKey is an auth.APIKeyLookup backed by the store.
+func (m *MemoryStore) LookupAPIKey(ctx context.Context, keyHash string) (auth.APIKeyRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return auth.APIKeyRecord{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.apiKeys {
+		if k.KeyHash == keyHash {
+			return apiKeyRecord(k), nil
+		}
+	}
+	return auth.APIKeyRecord{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) AddAuditEntry(ctx context.Context, tenant string, entry model.AuditEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.audit = append(m.audit, newAuditEntry(tenant, entry))
+	return nil
+}
+
+// EachAuditEntry calls fn with each of tenant's entries matching filter, newest first.
+// fn is called without holding the store's lock.
+func (m *MemoryStore) EachAuditEntry(ctx context.Context, tenant string, filter model.AuditLogFilter, fn func(*model.AuditEntry) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var quoted string
+	if filter.IP != nil {
+		// arguments is a JSON object, so the quoted address only matches whole values
+		b, _ := json.Marshal(*filter.IP)
+		quoted = string(b)
+	}
+
+	m.mu.RLock()
+	var matched []AuditEntry
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		if filter.Limit != nil && len(matched) >= *filter.Limit {
+			break
+		}
+
+		e := m.audit[i]
+		switch {
+		case e.Tenant != tenant,
+			filter.Principal != nil && e.Principal != *filter.Principal,
+			filter.Operation != nil && e.Operation != string(*filter.Operation),
+			filter.IP != nil && !strings.Contains(e.Arguments, quoted),
+			filter.Since != nil && e.CreatedAt.Before(*filter.Since),
+			filter.Until != nil && !e.CreatedAt.Before(*filter.Until):
+			continue
+		}
+		matched = append(matched, e)
+	}
+	m.mu.RUnlock()
+
+	for _, e := range matched {
+		converted := dbAuditEntryToGraphQL(e)
+		if err := fn(&converted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TagIP adds tags to tenant's address addr on behalf of author, and returns all of the
+// address's tags by name.  Tags the address already has keep their author and time.
+func (m *MemoryStore) TagIP(ctx context.Context, tenant string, addr string, tags []string, author string) ([]*model.Tag, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := address{tenant, addr}
+	existing, ok := m.tags[key]
+	if !ok {
+		existing = map[string]Tag{}
+		m.tags[key] = existing
+	}
+	now := time.Now().UTC()
+	for _, tag := range tags {
+		if _, ok := existing[tag]; !ok {
+			existing[tag] = Tag{Tenant: tenant, IPAddress: addr, Tag: tag, Author: author, CreatedAt: now}
+		}
+	}
+	return m.tagsOn(key), nil
+}
+
+// UntagIP removes tags from tenant's address addr, and returns the tags it has left.
+func (m *MemoryStore) UntagIP(ctx context.Context, tenant string, addr string, tags []string) ([]*model.Tag, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := address{tenant, addr}
+	for _, tag := range tags {
+		delete(m.tags[key], tag)
+	}
+	return m.tagsOn(key), nil
+}
+
+// Tags returns the tags on each of tenant's addresses addrs by name, keyed by address.
+// Addresses without tags are left out.
+func (m *MemoryStore) Tags(ctx context.Context, tenant string, addrs []string) (map[string][]*model.Tag, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := map[string][]*model.Tag{}
+	for _, addr := range addrs {
+		if tags := m.tagsOn(address{tenant, addr}); len(tags) > 0 {
+			res[addr] = tags
+		}
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) tagsOn(key address) []*model.Tag {
+	res := make([]*model.Tag, 0, len(m.tags[key]))
+	for _, t := range m.tags[key] {
+		converted := dbTagToGraphQL(t)
+		res = append(res, &converted)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// AddNote stores a note on tenant's address note.IPAddress.
+func (m *MemoryStore) AddNote(ctx context.Context, tenant string, note model.Note) (model.Note, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Note{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := newNote(tenant, note)
+	key := address{tenant, n.IPAddress}
+	m.notes[key] = append(m.notes[key], n)
+	return dbNoteToGraphQL(n), nil
+}
+
+// Notes returns the notes on each of tenant's addresses addrs, oldest first, keyed by
+// address.  Addresses without notes are left out.
+func (m *MemoryStore) Notes(ctx context.Context, tenant string, addrs []string) (map[string][]*model.Note, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := map[string][]*model.Note{}
+	for _, addr := range addrs {
+		for _, n := range m.notes[address{tenant, addr}] {
+			converted := dbNoteToGraphQL(n)
+			res[addr] = append(res[addr], &converted)
+		}
+	}
+	return res, nil
+}
+
+// annotated reports whether tenant's address addr has tag, and a note containing note,
+// for whichever of them are set.  The caller must hold the lock.
+func (m *MemoryStore) annotated(tenant, addr string, tag, note *string) bool {
+	key := address{tenant, addr}
+	if tag != nil {
+		if _, ok := m.tags[key][*tag]; !ok {
+			return false
+		}
+	}
+	if note != nil {
+		for _, n := range m.notes[key] {
+			if strings.Contains(n.Body, *note) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
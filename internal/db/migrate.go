@@ -0,0 +1,251 @@
+package db
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// Migration is one step in the evolution of the schema, read from
+// migrations/<dialect>/<version>_<name>.sql.  Every dialect has the same migrations.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus reports whether a migration has been applied to a database.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// loadMigrations returns the embedded migrations for d in order.  Versions must start at
+// 1 and have no gaps.
+func loadMigrations(d dialect) ([]Migration, error) {
+	dir := path.Join("migrations", d.name)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		i := strings.Index(base, "_")
+		if i < 0 {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>.sql", entry.Name())
+		}
+		version, err := strconv.Atoi(base[:i])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", entry.Name(), err)
+		}
+
+		contents, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: base[i+1:], SQL: string(contents)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d (%s) is out of sequence, expected version %d", m.Version, m.Name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// appliedVersions returns when each applied migration was applied.
+func (c *Client) appliedVersions() (map[int]time.Time, error) {
+	var rows []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := c.db.Select(&rows, "SELECT version, applied_at FROM schema_version ORDER BY version"); err != nil {
+		return nil, fmt.Errorf("error reading schema version: %w", err)
+	}
+
+	applied := make(map[int]time.Time, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = r.AppliedAt
+	}
+	return applied, nil
+}
+
+// MigrationStatus lists every known migration and when it was applied, if it has been.
+func (c *Client) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(c.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			at := at
+			status.AppliedAt = &at
+		}
+		res = append(res, status)
+	}
+	return res, nil
+}
+
+// Migrate applies the migrations that haven't been applied yet, each in its own
+// transaction, and returns them.  It refuses to touch a database that has been migrated
+// by a newer build.
+func (c *Client) Migrate() ([]Migration, error) {
+	migrations, err := loadMigrations(c.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+	for version := range applied {
+		if version > len(migrations) {
+			return nil, fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, len(migrations))
+		}
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		ok, err := c.apply(m)
+		if err != nil {
+			return done, err
+		}
+		if ok {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// apply runs m unless another server applied it first, reporting whether it did.
+func (c *Client) apply(m Migration) (bool, error) {
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return false, fmt.Errorf("error starting migration %d: %w", m.Version, err)
+	}
+
+	if err := c.dialect.lockMigrations(tx); err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("error locking schema for migration %d: %w", m.Version, err)
+	}
+	var count int
+	if err := tx.Get(&count, c.db.Rebind("SELECT COUNT(*) FROM schema_version WHERE version = ?"), m.Version); err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("error reading schema version: %w", err)
+	}
+	if count > 0 {
+		return false, tx.Rollback()
+	}
+
+	if before, ok := c.dialect.beforeMigration[m.Version]; ok {
+		if err := before(tx); err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("error preparing migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("error applying migration %d (%s): %w", m.Version, m.Name, err)
+	}
+	if after, ok := c.dialect.afterMigration[m.Version]; ok {
+		if err := after(tx); err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("error completing migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	_, err = tx.Exec(c.db.Rebind("INSERT INTO schema_version(version, name, applied_at) VALUES (?, ?, ?)"), m.Version, m.Name, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("error recording migration %d: %w", m.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing migration %d: %w", m.Version, err)
+	}
+	return true, nil
+}
+
+// Columns that databases created before migrations were versioned may be missing.
+var legacyColumns = []struct {
+	table, column, definition string
+}{
+	{"detail", "tenant", "TEXT NOT NULL DEFAULT 'default'"},
+	{"api_key", "tenant", "TEXT NOT NULL DEFAULT 'default'"},
+	{"audit_log", "tenant", "TEXT NOT NULL DEFAULT 'default'"},
+}
+
+// adoptLegacySchema adds legacyColumns to any of their tables that already exist, so
+// that the initial SQLite migration finds them in the shape it expects.
+func adoptLegacySchema(tx *sqlx.Tx) error {
+	for _, col := range legacyColumns {
+		var columns []string
+		if err := tx.Select(&columns, "SELECT name FROM pragma_table_info(?)", col.table); err != nil {
+			return fmt.Errorf("error reading columns of %s: %w", col.table, err)
+		}
+		if len(columns) == 0 || contains(columns, col.column) {
+			continue
+		}
+
+		_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.column, col.definition))
+		if err != nil {
+			return fmt.Errorf("error adding column %s.%s: %w", col.table, col.column, err)
+		}
+	}
+	return nil
+}
+
+// fillIPBytes sets ip_bytes for rows stored before it existed.  Addresses that don't
+// parse are left without it, and so never match a network.
+func fillIPBytes(tx *sqlx.Tx) error {
+	var rows []struct {
+		ID        string `db:"id"`
+		IPAddress string `db:"ip_address"`
+	}
+	if err := tx.Select(&rows, "SELECT id, ip_address FROM detail WHERE ip_bytes IS NULL AND ip_address IS NOT NULL"); err != nil {
+		return fmt.Errorf("error reading addresses: %w", err)
+	}
+
+	for _, r := range rows {
+		key, err := ipKey(r.IPAddress)
+		if err != nil {
+			continue
+		}
+		if _, err := tx.Exec(tx.Rebind("UPDATE detail SET ip_bytes = ? WHERE id = ?"), key, r.ID); err != nil {
+			return fmt.Errorf("error setting ip_bytes of %s: %w", r.ID, err)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// openTestDatabase opens a real SQLite database in a temporary directory.
+func openTestDatabase(t *testing.T) string {
+	sqliteDbOpener = openSqlite
+	return filepath.Join(t.TempDir(), "test.db")
+}
+
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	sqlite, err := loadMigrations(sqliteDialect)
+	if err != nil {
+		t.Fatalf("unexpected error reading sqlite migrations: %s", err.Error())
+	}
+	postgres, err := loadMigrations(postgresDialect)
+	if err != nil {
+		t.Fatalf("unexpected error reading postgres migrations: %s", err.Error())
+	}
+
+	if len(sqlite) == 0 || sqlite[0].Name != "initial" {
+		t.Errorf("expected the first migration to be the initial schema, got %+v", sqlite)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("expected the same number of migrations, got %d for sqlite and %d for postgres", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("expected migration %d to match, got %s for sqlite and %s for postgres", i+1, sqlite[i].Name, postgres[i].Name)
+		}
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	path := openTestDatabase(t)
+
+	c, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error opening database: %s", err.Error())
+	}
+	defer c.Close()
+
+	status, err := c.MigrationStatus()
+	if err != nil {
+		t.Fatalf("unexpected error getting status: %s", err.Error())
+	}
+	for _, s := range status {
+		if s.AppliedAt != nil {
+			t.Errorf("expected migration %d not to be applied yet", s.Version)
+		}
+	}
+
+	applied, err := c.Migrate()
+	if err != nil {
+		t.Fatalf("unexpected error migrating: %s", err.Error())
+	}
+	if len(applied) != len(status) {
+		t.Errorf("expected %d migrations to be applied, got %d", len(status), len(applied))
+	}
+
+	applied, err = c.Migrate()
+	if err != nil || len(applied) != 0 {
+		t.Errorf("expected migrating again to do nothing, got %v, %v", applied, err)
+	}
+
+	status, _ = c.MigrationStatus()
+	for _, s := range status {
+		if s.AppliedAt == nil || time.Since(*s.AppliedAt) > time.Minute {
+			t.Errorf("expected migration %d to have just been applied, got %v", s.Version, s.AppliedAt)
+		}
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	path := openTestDatabase(t)
+
+	// The schema as created before tenants were added and migrations were versioned.
+	legacy, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = legacy.Exec(`CREATE TABLE detail (id TEXT PRIMARY KEY, created_at DATETIME, updated_at DATETIME, response_code TEXT, ip_address TEXT);
+		INSERT INTO detail VALUES ('some-id', '2020-01-01 00:00:00', '2020-01-01 00:00:00', '127.0.0.2', '1.2.3.4');`)
+	legacy.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	c, err := NewClient(path)
+	if err != nil {
+		t.Fatalf("unexpected error migrating legacy database: %s", err.Error())
+	}
+	defer c.Close()
+
+	details, err := c.GetIPDetails(context.Background(), "default", "1.2.3.4")
+	if err != nil {
+		t.Fatalf("expected legacy row to belong to the default tenant: %s", err.Error())
+	}
+	if details.ResponseCode != "127.0.0.2" {
+		t.Errorf("unexpected legacy row: %+v", details)
+	}
+}
+
+func TestMigrateRemovesDuplicateDetails(t *testing.T) {
+	path := openTestDatabase(t)
+
+	c, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error opening database: %s", err.Error())
+	}
+	defer c.Close()
+
+	migrations, _ := loadMigrations(sqliteDialect)
+	if _, err := c.apply(migrations[0]); err != nil {
+		t.Fatalf("unexpected error applying initial migration: %s", err.Error())
+	}
+	_, err = c.db.Exec(`INSERT INTO detail VALUES
+		('old', '2020-01-01 00:00:00', '2020-01-01 00:00:00', '127.0.0.2', '1.2.3.4', 'red'),
+		('new', '2020-01-01 00:00:00', '2020-02-01 00:00:00', '127.0.0.4', '1.2.3.4', 'red'),
+		('other', '2020-01-01 00:00:00', '2020-01-01 00:00:00', '127.0.0.2', '1.2.3.4', 'blue')`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := c.Migrate(); err != nil {
+		t.Fatalf("unexpected error migrating: %s", err.Error())
+	}
+
+	var ids []string
+	if err := c.db.Select(&ids, "SELECT id FROM detail ORDER BY id"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(ids, []string{"new", "other"}) {
+		t.Errorf("expected only the newest row for each tenant to be kept, got %v", ids)
+	}
+}
+
+func TestMigrateFillsIPBytes(t *testing.T) {
+	path := openTestDatabase(t)
+
+	c, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error opening database: %s", err.Error())
+	}
+	defer c.Close()
+
+	migrations, _ := loadMigrations(sqliteDialect)
+	for _, m := range migrations[:2] {
+		if _, err := c.apply(m); err != nil {
+			t.Fatalf("unexpected error applying migration %d: %s", m.Version, err.Error())
+		}
+	}
+	_, err = c.db.Exec(`INSERT INTO detail VALUES
+		('v4', '2020-01-01 00:00:00', '2020-01-01 00:00:00', '127.0.0.2', '203.0.113.7', 'red'),
+		('v6', '2020-01-01 00:00:00', '2020-01-01 00:00:00', '', '2001:db8::7', 'red'),
+		('bad', '2020-01-01 00:00:00', '2020-01-01 00:00:00', '', 'not an address', 'red')`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := c.Migrate(); err != nil {
+		t.Fatalf("unexpected error migrating: %s", err.Error())
+	}
+
+	for cidr, id := range map[string]string{"203.0.113.0/24": "v4", "2001:db8::/32": "v6"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		found, err := c.IPDetailsInNetwork(context.Background(), "red", network, model.NetworkFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error searching %s: %s", cidr, err.Error())
+		}
+		if len(found) != 1 || found[0].UUID != id {
+			t.Errorf("expected row %s to be found in %s, got %+v", id, cidr, found)
+		}
+	}
+}
+
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	path := openTestDatabase(t)
+
+	c, err := NewClient(path)
+	if err != nil {
+		t.Fatalf("unexpected error creating database: %s", err.Error())
+	}
+	_, err = c.db.Exec("INSERT INTO schema_version(version, name, applied_at) VALUES (9999, 'future', ?)", time.Now().UTC())
+	c.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := NewClient(path); err == nil {
+		t.Error("expected an error opening a database from a newer build")
+	}
+}
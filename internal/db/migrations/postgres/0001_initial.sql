@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS detail
+(
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ,
+	response_code TEXT,
+	ip_address TEXT,
+	tenant TEXT NOT NULL DEFAULT 'default'
+);
+CREATE TABLE IF NOT EXISTS api_key
+(
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	owner TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ,
+	tenant TEXT NOT NULL DEFAULT 'default'
+);
+CREATE TABLE IF NOT EXISTS audit_log
+(
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	principal TEXT NOT NULL,
+	client_ip TEXT NOT NULL,
+	operation TEXT NOT NULL,
+	arguments TEXT NOT NULL,
+	tenant TEXT NOT NULL DEFAULT 'default'
+);
+CREATE INDEX IF NOT EXISTS audit_log_created_at ON audit_log(created_at);
+CREATE INDEX IF NOT EXISTS detail_tenant_ip_address ON detail(tenant, ip_address);
+CREATE INDEX IF NOT EXISTS detail_ip_address ON detail(ip_address);
@@ -0,0 +1,10 @@
+-- Each tenant has at most one row per address; keep only the most recently updated.
+DELETE FROM detail WHERE EXISTS (
+	SELECT 1 FROM detail AS newer
+	WHERE newer.tenant = detail.tenant
+	AND newer.ip_address = detail.ip_address
+	AND (newer.updated_at > detail.updated_at
+		OR (newer.updated_at = detail.updated_at AND newer.id > detail.id))
+);
+DROP INDEX IF EXISTS detail_tenant_ip_address;
+CREATE UNIQUE INDEX detail_tenant_ip_address ON detail(tenant, ip_address);
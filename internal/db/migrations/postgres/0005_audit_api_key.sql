@@ -0,0 +1,3 @@
+-- Calls made with an API key act as the admin that created it, so the key is recorded
+-- to tell them apart from the admin's own calls.
+ALTER TABLE audit_log ADD COLUMN api_key_id TEXT;
@@ -0,0 +1,12 @@
+-- detail holds only the latest result for each address, so every lookup is also
+-- recorded here, for counting lookups over time.
+CREATE TABLE lookup
+(
+	id TEXT PRIMARY KEY,
+	tenant TEXT NOT NULL,
+	ip_address TEXT NOT NULL,
+	response_code TEXT NOT NULL,
+	looked_up_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX lookup_tenant_looked_up_at ON lookup(tenant, looked_up_at);
+CREATE INDEX lookup_ip_address_looked_up_at ON lookup(ip_address, looked_up_at);
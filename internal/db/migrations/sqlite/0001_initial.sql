@@ -0,0 +1,36 @@
+-- The schema as it was before migrations were versioned.  Databases created by earlier
+-- builds already have some of these tables, so everything here must be idempotent.
+CREATE TABLE IF NOT EXISTS detail
+(
+	id TEXT PRIMARY KEY,
+	created_at DATETIME,
+	updated_at DATETIME,
+	response_code TEXT,
+	ip_address TEXT,
+	tenant TEXT NOT NULL DEFAULT 'default'
+);
+CREATE TABLE IF NOT EXISTS api_key
+(
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	owner TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME,
+	revoked_at DATETIME,
+	tenant TEXT NOT NULL DEFAULT 'default'
+);
+CREATE TABLE IF NOT EXISTS audit_log
+(
+	id TEXT PRIMARY KEY,
+	created_at DATETIME NOT NULL,
+	principal TEXT NOT NULL,
+	client_ip TEXT NOT NULL,
+	operation TEXT NOT NULL,
+	arguments TEXT NOT NULL,
+	tenant TEXT NOT NULL DEFAULT 'default'
+);
+CREATE INDEX IF NOT EXISTS audit_log_created_at ON audit_log(created_at);
+CREATE INDEX IF NOT EXISTS detail_tenant_ip_address ON detail(tenant, ip_address);
+CREATE INDEX IF NOT EXISTS detail_ip_address ON detail(ip_address);
@@ -0,0 +1,11 @@
+-- Each tenant has at most one row per address.  Earlier builds could store duplicates
+-- when lookups raced, so keep only the most recently updated of each.
+DELETE FROM detail WHERE EXISTS (
+	SELECT 1 FROM detail AS newer
+	WHERE newer.tenant = detail.tenant
+	AND newer.ip_address = detail.ip_address
+	AND (julianday(newer.updated_at) > julianday(detail.updated_at)
+		OR (julianday(newer.updated_at) = julianday(detail.updated_at) AND newer.id > detail.id))
+);
+DROP INDEX IF EXISTS detail_tenant_ip_address;
+CREATE UNIQUE INDEX detail_tenant_ip_address ON detail(tenant, ip_address);
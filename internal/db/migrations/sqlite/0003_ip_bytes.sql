@@ -0,0 +1,5 @@
+-- ip_bytes holds the address in a form that sorts numerically, so that networks can be
+-- searched with a range scan.  Existing rows are filled in by the server, since the
+-- addresses can't be parsed in SQL.
+ALTER TABLE detail ADD COLUMN ip_bytes BLOB;
+CREATE INDEX detail_tenant_ip_bytes ON detail(tenant, ip_bytes);
@@ -0,0 +1,22 @@
+-- Analysts' tags and notes belong to an address rather than to its stored result, so they
+-- are kept when the result is deleted or pruned and shown again when it is looked up.
+CREATE TABLE ip_tag
+(
+	tenant TEXT NOT NULL,
+	ip_address TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	author TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (tenant, ip_address, tag)
+);
+CREATE INDEX ip_tag_tenant_tag ON ip_tag(tenant, tag);
+CREATE TABLE ip_note
+(
+	id TEXT PRIMARY KEY,
+	tenant TEXT NOT NULL,
+	ip_address TEXT NOT NULL,
+	body TEXT NOT NULL,
+	author TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+CREATE INDEX ip_note_tenant_ip_address ON ip_note(tenant, ip_address);
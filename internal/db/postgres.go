@@ -0,0 +1,26 @@
+package db
+
+import (
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+var postgresDbOpener = func(url string) (*sqlx.DB, error) {
+	return sqlx.Open("postgres", url)
+}
+
+// NewPostgresClient connects to the PostgreSQL database at url, e.g.
+// "postgres://detect:secret@db:5432/detect?sslmode=require", and brings its schema up to
+// date.  Several servers may share the database.
+func NewPostgresClient(url string) (*Client, error) {
+	return migrated(OpenPostgres(url))
+}
+
+// OpenPostgres connects to the PostgreSQL database at url without migrating it.
+func OpenPostgres(url string) (*Client, error) {
+	pgDb, err := postgresDbOpener(url)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(pgDb, postgresDialect)
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// newMockPostgresClient returns a client using the postgres dialect, whose queries are
+// rebound to $n placeholders.
+func newMockPostgresClient(t *testing.T, expect func(myMock sqlmock.Sqlmock)) (*Client, sqlmock.Sqlmock) {
+	mockDb, myMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Errorf("unexpected error creating mock db: %s", err.Error())
+	}
+
+	// insert mock db into package
+	postgresDbOpener = func(dataSource string) (*sqlx.DB, error) {
+		return sqlx.NewDb(mockDb, "postgres"), nil
+	}
+
+	myMock.ExpectPing()
+	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version(.+)TIMESTAMPTZ").WillReturnResult(sqlmock.NewResult(0, 0))
+	expect(myMock)
+
+	db, err := NewPostgresClient("postgres://detect@localhost/detect")
+	if err != nil {
+		t.Fatalf("unexpected error creating postgres client: %s", err.Error())
+	}
+
+	return db, myMock
+}
+
+func TestPostgresNewClientMigrates(t *testing.T) {
+	migrations, err := loadMigrations(postgresDialect)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	db, myMock := newMockPostgresClient(t, func(myMock sqlmock.Sqlmock) {
+		myMock.ExpectQuery("SELECT version, applied_at FROM schema_version").WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+		for _, m := range migrations {
+			myMock.ExpectBegin()
+			myMock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+			myMock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM schema_version WHERE version = \\$1").WithArgs(m.Version).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			myMock.ExpectExec(".+").WillReturnResult(sqlmock.NewResult(0, 0))
+			if _, ok := postgresDialect.afterMigration[m.Version]; ok {
+				myMock.ExpectQuery("SELECT id, ip_address FROM detail WHERE ip_bytes IS NULL").WillReturnRows(sqlmock.NewRows([]string{"id", "ip_address"}))
+			}
+			myMock.ExpectExec("INSERT INTO schema_version\\(version, name, applied_at\\) VALUES \\(\\$1, \\$2, \\$3\\)").WithArgs(m.Version, m.Name, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			myMock.ExpectCommit()
+		}
+	})
+
+	closeMockClient(t, db, myMock)
+}
+
+func TestPostgresSkipsMigrationAppliedByAnotherServer(t *testing.T) {
+	migrations, err := loadMigrations(postgresDialect)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	db, myMock := newMockPostgresClient(t, func(myMock sqlmock.Sqlmock) {
+		myMock.ExpectQuery("SELECT version, applied_at FROM schema_version").WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+		for _, m := range migrations {
+			myMock.ExpectBegin()
+			myMock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+			myMock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM schema_version").WithArgs(m.Version).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			myMock.ExpectRollback()
+		}
+	})
+
+	closeMockClient(t, db, myMock)
+}
+
+func TestPostgresQueries(t *testing.T) {
+	since := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	ip := "1.2.3.4"
+
+	testCases := []struct {
+		name  string
+		query string
+		rows  []string
+		run   func(c *Client) error
+	}{
+		{
+			"get details",
+			"SELECT \\* FROM detail WHERE tenant = \\$1 AND ip_address = \\$2",
+			[]string{"id"},
+			func(c *Client) error {
+				c.GetIPDetails(context.Background(), "red", ip)
+				return nil
+			},
+		},
+		{
+			"network",
+			"WHERE tenant = \\$1 AND ip_bytes BETWEEN \\$2 AND \\$3 AND response_code != '' ORDER BY ip_bytes LIMIT \\$4",
+			[]string{"id"},
+			func(c *Client) error {
+				_, network, _ := net.ParseCIDR("1.2.3.0/24")
+				listed, limit := true, 10
+				_, err := c.IPDetailsInNetwork(context.Background(), "red", network, model.NetworkFilter{Listed: &listed, Limit: &limit})
+				return err
+			},
+		},
+		{
+			"export",
+			"WHERE tenant = \\$1 AND COALESCE\\(response_code, ''\\) = '' AND updated_at >= \\$2 ORDER BY ip_bytes, ip_address",
+			[]string{"id"},
+			func(c *Client) error {
+				clean := false
+				return c.EachIPDetails(context.Background(), "red", model.ExportFilter{Listed: &clean, Since: &since}, func(*model.IPDetails) error { return nil })
+			},
+		},
+		{
+			"network with annotations",
+			"WHERE tenant = \\$1 AND ip_bytes BETWEEN \\$2 AND \\$3 AND EXISTS \\(.*ip_tag.tag = \\$4\\) AND EXISTS \\(.*strpos\\(ip_note.body, \\$5\\) > 0\\) ORDER BY ip_bytes",
+			[]string{"id"},
+			func(c *Client) error {
+				_, network, _ := net.ParseCIDR("1.2.3.0/24")
+				tag, note := "relay", "ticket"
+				_, err := c.IPDetailsInNetwork(context.Background(), "red", network, model.NetworkFilter{Tag: &tag, Note: &note})
+				return err
+			},
+		},
+		{
+			"tags",
+			"SELECT \\* FROM ip_tag WHERE tenant = \\$1 AND ip_address IN \\(\\$2, \\$3\\) ORDER BY ip_address, tag",
+			[]string{"tag"},
+			func(c *Client) error {
+				_, err := c.Tags(context.Background(), "red", []string{ip, "5.6.7.8"})
+				return err
+			},
+		},
+		{
+			"shared result",
+			"FROM lookup WHERE ip_address = \\$1 AND looked_up_at >= \\$2 AND looked_up_at <= \\$3 ORDER BY looked_up_at DESC LIMIT 1",
+			[]string{"response_code"},
+			func(c *Client) error {
+				_, _, err := c.SharedResult(context.Background(), ip, since)
+				return err
+			},
+		},
+		{
+			"lookups per day",
+			"SELECT to_char\\(looked_up_at AT TIME ZONE 'UTC', 'YYYY-MM-DD'\\) AS day(.|\n)*FROM lookup",
+			[]string{"day", "count"},
+			func(c *Client) error {
+				var days []dayCount
+				return c.db.Select(&days, c.stats(statsLookupsStmt, "looked_up_at", c.dialect.day("looked_up_at")), "red", since)
+			},
+		},
+		{
+			"audit log",
+			"WHERE tenant = \\$1 AND strpos\\(arguments, \\$2\\) > 0 AND created_at >= \\$3 ORDER BY created_at DESC",
+			[]string{"id"},
+			func(c *Client) error {
+				return c.EachAuditEntry(context.Background(), "red", model.AuditLogFilter{IP: &ip, Since: &since}, func(*model.AuditEntry) error { return nil })
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			db, myMock := newMockPostgresClient(t, func(myMock sqlmock.Sqlmock) {
+				expectMigrated(myMock, postgresDialect)
+			})
+
+			myMock.ExpectQuery(test.query).WillReturnRows(sqlmock.NewRows(test.rows))
+			if err := test.run(db); err != nil {
+				t.Error(err.Error())
+			}
+
+			closeMockClient(t, db, myMock)
+		})
+	}
+}
+
+func TestPostgresImportIPDetails(t *testing.T) {
+	db, myMock := newMockPostgresClient(t, func(myMock sqlmock.Sqlmock) {
+		expectMigrated(myMock, postgresDialect)
+	})
+
+	updated := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	myMock.ExpectBegin()
+	prep := myMock.ExpectPrepare("VALUES \\(\\$1, \\$2, \\$3, \\$4, \\$5, \\$6, \\$7\\) ON CONFLICT\\(tenant, ip_address\\) DO UPDATE SET .* WHERE excluded.updated_at > detail.updated_at")
+	prep.ExpectExec().
+		WithArgs(sqlmock.AnyArg(), updated, updated, "127.0.0.2", "1.2.3.4", "red", []byte(net.ParseIP("1.2.3.4"))).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	myMock.ExpectCommit()
+
+	res, err := db.ImportIPDetails(context.Background(), "red", []model.IPDetails{{IPAddress: "1.2.3.4", ResponseCode: "127.0.0.2", UpdatedAt: updated}}, model.ConflictPolicyNewer)
+	if err != nil {
+		t.Fatalf("unexpected error importing: %s", err.Error())
+	}
+	if res != (model.ImportResult{Skipped: 1}) {
+		t.Errorf("expected the record to be skipped, got %+v", res)
+	}
+
+	closeMockClient(t, db, myMock)
+}
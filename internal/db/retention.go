@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// RetentionPolicy says how long records are kept after they were last updated.  A zero
+// duration keeps them forever.
+type RetentionPolicy struct {
+	// Clean applies to results no DNSBL listed, and Listed to the others.
+	Clean  time.Duration
+	Listed time.Duration
+	// AuditLog applies to audit entries, by when they were recorded, and History to the
+	// lookup history, by when the lookups were made.
+	AuditLog time.Duration
+	History  time.Duration
+}
+
+// PruneReport counts the records a prune removed, or would have removed in a dry run.
+type PruneReport struct {
+	Clean        int
+	Listed       int
+	AuditEntries int
+	Lookups      int
+}
+
+func (r PruneReport) String() string {
+	return fmt.Sprintf("%d clean results, %d listed results, %d audit entries and %d lookups", r.Clean, r.Listed, r.AuditEntries, r.Lookups)
+}
+
+// The prune conditions select every tenant's records older than the "?" cutoff;
+// results stored by earlier builds may have a NULL response code.
+const (
+	pruneCleanCondition  = "COALESCE(response_code, '') = '' AND %s < %s"
+	pruneListedCondition = "COALESCE(response_code, '') != '' AND %s < %s"
+)
+
+// Prune removes the records policy no longer keeps as of now, in one transaction.  With
+// dryRun set nothing is removed, and the report counts what would have been.
+func (c *Client) Prune(ctx context.Context, policy RetentionPolicy, now time.Time, dryRun bool) (PruneReport, error) {
+	var report PruneReport
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return report, fmt.Errorf("error starting prune: %w", err)
+	}
+	defer tx.Rollback()
+
+	steps := []struct {
+		table, condition, column string
+		keep                     time.Duration
+		count                    *int
+	}{
+		{"detail", pruneCleanCondition, "updated_at", policy.Clean, &report.Clean},
+		{"detail", pruneListedCondition, "updated_at", policy.Listed, &report.Listed},
+		{"audit_log", "%s < %s", "created_at", policy.AuditLog, &report.AuditEntries},
+		{"lookup", "%s < %s", "looked_up_at", policy.History, &report.Lookups},
+	}
+	for _, step := range steps {
+		if step.keep <= 0 {
+			continue
+		}
+		condition := fmt.Sprintf(step.condition, c.dialect.timestamp(step.column), c.dialect.timestamp("?"))
+		n, err := c.prune(ctx, tx, step.table, condition, now.Add(-step.keep), dryRun)
+		if err != nil {
+			return report, fmt.Errorf("error pruning %s: %w", step.table, err)
+		}
+		*step.count = n
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return report, fmt.Errorf("error committing prune: %w", err)
+	}
+	return report, nil
+}
+
+// prune deletes, or with dryRun counts, the rows of table matching condition.
+func (c *Client) prune(ctx context.Context, tx *sqlx.Tx, table, condition string, before time.Time, dryRun bool) (int, error) {
+	if dryRun {
+		var n int
+		err := tx.GetContext(ctx, &n, c.db.Rebind(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", table, condition)), before)
+		return n, err
+	}
+
+	res, err := tx.ExecContext(ctx, c.db.Rebind(fmt.Sprintf("DELETE FROM %s WHERE %s", table, condition)), before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Compact returns the space freed by pruning to the operating system.  It runs
+// outside of any transaction, and does nothing on PostgreSQL, where autovacuum does the
+// same job.
+func (c *Client) Compact(ctx context.Context) error {
+	for _, stmt := range c.dialect.compactStmts {
+		if _, err := c.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error compacting database (%s): %w", stmt, err)
+		}
+	}
+	return nil
+}
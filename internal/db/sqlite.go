@@ -1,7 +1,12 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"strings"
 	"time"
 
@@ -11,42 +16,68 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Client stores results, API keys and the audit log in SQLite or, when opened with
+// OpenPostgres, PostgreSQL.
 type Client struct {
-	db *sqlx.DB
+	db      *sqlx.DB
+	dialect dialect
 }
 
-var sqliteDbOpener = func(path string) (*sqlx.DB, error) {
+var sqliteDbOpener = openSqlite
+
+func openSqlite(path string) (*sqlx.DB, error) {
 	return sqlx.Open("sqlite3", path)
 }
 
-var initStmt = `CREATE TABLE IF NOT EXISTS detail
-(
-	id TEXT PRIMARY KEY,
-	created_at DATETIME,
-	updated_at DATETIME,
-	response_code TEXT,
-	ip_address TEXT
-);`
-
+// NewClient opens the SQLite database at path, creating it if necessary, and brings its
+// schema up to date.
 func NewClient(path string) (*Client, error) {
+	return migrated(Open(path))
+}
+
+// Open opens the SQLite database at path without migrating it, so that its schema can be
+// inspected first.
+func Open(path string) (*Client, error) {
 	sqliteDb, err := sqliteDbOpener(fmt.Sprintf("file:%s?_journal_mode=WAL&_txlock=immediate", path))
 	if err != nil {
 		return nil, err
 	}
+	return newClient(sqliteDb, sqliteDialect)
+}
 
-	err = sqliteDb.Ping()
+// newClient checks that db can be reached and can record migrations.
+func newClient(db *sqlx.DB, d dialect) (*Client, error) {
+	err := db.Ping()
 	if err != nil {
-		sqliteDb.Close()
+		db.Close()
 		return nil, err
 	}
 
-	_, err = sqliteDb.Exec(initStmt)
+	_, err = db.Exec(d.schemaVersionStmt)
 	if err != nil {
-		sqliteDb.Close()
+		db.Close()
 		return nil, err
 	}
 
-	return &Client{db: sqliteDb}, nil
+	return &Client{db: db, dialect: d}, nil
+}
+
+// migrated applies pending migrations to a newly opened client.
+func migrated(c *Client, err error) (*Client, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := c.Migrate()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	for _, m := range applied {
+		log.Printf("applied database migration %d (%s)", m.Version, m.Name)
+	}
+
+	return c, nil
 }
 
 func (c *Client) Close() error {
@@ -54,60 +85,69 @@ func (c *Client) Close() error {
 }
 
 // AddIPDetails takes a partially filled in IPDetails structure
-// and either adds it to the database or updates an existing record
-// if it exists.  This process is transparent to the caller.
-func (c *Client) AddIPDetails(details model.IPDetails) error {
-	id := uuid.New().String()
-	createdAt := time.Now()
-	updatedAt := createdAt
-
-	var existingDetails IPDetails
-
-	// We open an "immediate" transaction here to ensure nobody adds
-	// a record for the same IP address before we do.
-	tx, err := c.db.Begin()
+// and either adds it to tenant's records or updates an existing record
+// if it exists.  This process is transparent to the caller.  The lookup is also
+// recorded in tenant's history.
+func (c *Client) AddIPDetails(ctx context.Context, tenant string, details model.IPDetails) error {
+	key, err := ipKey(details.IPAddress)
 	if err != nil {
-		return fmt.Errorf("error starting transaction: %w", err)
+		return err
 	}
-	rows, err := tx.Query("SELECT * FROM detail WHERE ip_address = ?", details.IPAddress)
+	now := time.Now()
+
+	tx, err := c.db.BeginTxx(ctx, nil)
 	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("error checking for existing details: %w", err)
-	}
-	if rows.Next() {
-		err = rows.Scan(&existingDetails.UUID, &existingDetails.CreatedAt, &existingDetails.UpdatedAt, &existingDetails.ResponseCode, &existingDetails.IPAddress)
-		if err != nil {
-			tx.Rollback()
-			return fmt.Errorf("error scanning db result: %w", err)
-		}
-		id = existingDetails.UUID
-		createdAt = existingDetails.CreatedAt
+		return fmt.Errorf("error beginning to store ip details: %w", err)
 	}
+	defer tx.Rollback()
 
-	_, err = tx.Exec(
-		"INSERT OR REPLACE INTO detail(id, created_at, updated_at, response_code, ip_address) VALUES ($1, $2, $3, $4, $5)",
-		id,
-		createdAt,
-		updatedAt,
+	// An existing record keeps its id and created_at, so concurrent lookups of the
+	// same address can't create duplicates or lose its history.
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO detail(id, created_at, updated_at, response_code, ip_address, tenant, ip_bytes) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT(tenant, ip_address) DO UPDATE SET updated_at = excluded.updated_at, response_code = excluded.response_code`,
+		uuid.New().String(),
+		now,
+		now,
 		details.ResponseCode,
 		details.IPAddress,
+		tenant,
+		key,
 	)
 	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("error inserting row")
+		return fmt.Errorf("error upserting ip details: %w", err)
 	}
-	err = tx.Commit()
+
+	_, err = tx.NamedExecContext(ctx,
+		`INSERT INTO lookup(id, tenant, ip_address, response_code, looked_up_at)
+		VALUES (:id, :tenant, :ip_address, :response_code, :looked_up_at)`,
+		newLookup(tenant, details, now),
+	)
 	if err != nil {
-		return fmt.Errorf("error commiting tx: %w", err)
+		return fmt.Errorf("error recording lookup: %w", err)
 	}
 
-	return err
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error storing ip details: %w", err)
+	}
+	return nil
+}
+
+func newLookup(tenant string, details model.IPDetails, now time.Time) Lookup {
+	return Lookup{
+		ID:           uuid.New().String(),
+		Tenant:       tenant,
+		IPAddress:    details.IPAddress,
+		ResponseCode: details.ResponseCode,
+		LookedUpAt:   now,
+	}
 }
 
-func (c *Client) GetIPDetails(addr string) (model.IPDetails, error) {
+func (c *Client) GetIPDetails(ctx context.Context, tenant string, addr string) (model.IPDetails, error) {
 	var details IPDetails
 	var res model.IPDetails
-	if err := c.db.Get(&details, "SELECT * FROM detail WHERE ip_address = ?", addr); err != nil {
+	if err := c.db.GetContext(ctx, &details, c.db.Rebind("SELECT * FROM detail WHERE tenant = ? AND ip_address = ?"), tenant, addr); err != nil {
 		if strings.Contains(err.Error(), "no rows") {
 			return res, newErrNotFound(addr, err)
 		}
@@ -117,3 +157,166 @@ func (c *Client) GetIPDetails(addr string) (model.IPDetails, error) {
 	res = dbModelToGraphQL(details)
 	return res, nil
 }
+
+// SharedResult returns the response code of the most recent lookup of addr by any
+// tenant made at or after since, so that a fresh DNSBL result can be reused rather than
+// queried again.  Only the response code is shared; which tenants looked addr up is not
+// revealed.  Results come from the lookup history rather than the records, so records a
+// tenant imported are never shared with others.
+func (c *Client) SharedResult(ctx context.Context, addr string, since time.Time) (string, bool, error) {
+	var code string
+	lookedUpAt := c.dialect.timestamp("looked_up_at")
+	err := c.db.GetContext(ctx, &code, c.db.Rebind(fmt.Sprintf(`SELECT response_code FROM lookup
+		WHERE ip_address = ? AND %[1]s >= %[2]s AND %[1]s <= %[2]s
+		ORDER BY %[1]s DESC LIMIT 1`, lookedUpAt, c.dialect.timestamp("?"))), addr, since, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error checking for shared result: %w", err)
+	}
+	return code, true, nil
+}
+
+// IPDetailsInNetwork returns tenant's records for addresses within network in address
+// order, limited to those matching filter.
+func (c *Client) IPDetailsInNetwork(ctx context.Context, tenant string, network *net.IPNet, filter model.NetworkFilter) ([]*model.IPDetails, error) {
+	start, end := networkRange(network)
+	query := "SELECT * FROM detail WHERE tenant = ? AND ip_bytes BETWEEN ? AND ?"
+	args := []interface{}{tenant, start, end}
+	if filter.Listed != nil {
+		if *filter.Listed {
+			query += " AND response_code != ''"
+		} else {
+			query += " AND response_code = ''"
+		}
+	}
+	conds, condArgs := c.annotationConds(filter.Tag, filter.Note)
+	for _, cond := range conds {
+		query += " AND " + cond
+	}
+	args = append(args, condArgs...)
+	query += " ORDER BY ip_bytes"
+	if filter.Limit != nil {
+		query += " LIMIT ?"
+		args = append(args, *filter.Limit)
+	}
+
+	var details []IPDetails
+	if err := c.db.SelectContext(ctx, &details, c.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("error searching network %s: %w", network, err)
+	}
+
+	res := make([]*model.IPDetails, 0, len(details))
+	for _, d := range details {
+		converted := dbModelToGraphQL(d)
+		res = append(res, &converted)
+	}
+	return res, nil
+}
+
+const topNetworksLimit = 10
+
+// The stats statements are formatted with the dialect's "updated_at >= ?" condition.
+var statsTotalsStmt = `SELECT
+	COUNT(*) AS total,
+	COUNT(CASE WHEN response_code != '' THEN 1 END) AS listed
+FROM detail WHERE tenant = ? AND %[1]s`
+
+// response_code holds a comma separated list of codes, so we split it apart with a
+// recursive CTE before counting.
+var statsCodesStmt = `WITH RECURSIVE split(code, rest) AS (
+	SELECT CAST('' AS TEXT), response_code || ',' FROM detail
+	WHERE tenant = ? AND response_code != '' AND %[1]s
+	UNION ALL
+	SELECT substr(rest, 1, %[2]s - 1), substr(rest, %[2]s + 1)
+	FROM split WHERE rest != ''
+)
+SELECT code, COUNT(*) AS count FROM split WHERE code != '' GROUP BY code ORDER BY count DESC, code`
+
+// Lookups are counted from the history rather than detail, which only holds the latest.
+var statsLookupsStmt = `SELECT %[2]s AS day, COUNT(*) AS count
+FROM lookup WHERE tenant = ? AND %[1]s
+GROUP BY day ORDER BY day`
+
+// rtrim with a set of digits strips the final octet, leaving e.g. "203.0.113."
+var statsNetworksStmt = `SELECT rtrim(ip_address, '0123456789') || '0/24' AS network, COUNT(*) AS listed
+FROM detail
+WHERE tenant = ? AND response_code != '' AND %[2]s = 0 AND %[1]s
+GROUP BY network ORDER BY listed DESC, network LIMIT ?`
+
+// stats formats one of the stats statements for the client's database, selecting rows
+// whose time column is at or after the "?" parameter.
+func (c *Client) stats(stmt string, column string, arg string) string {
+	since := fmt.Sprintf("%s >= %s", c.dialect.timestamp(column), c.dialect.timestamp("?"))
+	return c.db.Rebind(fmt.Sprintf(stmt, since, arg))
+}
+
+// DeleteIPDetails removes tenant's stored results for addrs, returning how many were
+// found.
+func (c *Client) DeleteIPDetails(ctx context.Context, tenant string, addrs []string) (int, error) {
+	if len(addrs) == 0 {
+		return 0, nil
+	}
+
+	query, args, err := sqlx.In("DELETE FROM detail WHERE tenant = ? AND ip_address IN (?)", tenant, addrs)
+	if err != nil {
+		return 0, fmt.Errorf("error building delete: %w", err)
+	}
+
+	res, err := c.db.ExecContext(ctx, c.db.Rebind(query), args...)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting ip details: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error deleting ip details: %w", err)
+	}
+	return int(n), nil
+}
+
+// GetStats summarizes tenant's records updated at or after since, and the lookups made
+// since then.  ByListing is keyed by raw response code; decoding codes into list names is
+// left to the caller.
+func (c *Client) GetStats(ctx context.Context, tenant string, since time.Time) (model.Stats, error) {
+	res := model.Stats{
+		ByListing:     []*model.ListingCount{},
+		LookupsPerDay: []*model.DailyLookups{},
+		TopNetworks:   []*model.NetworkCount{},
+	}
+
+	var totals statsTotals
+	if err := c.db.GetContext(ctx, &totals, c.stats(statsTotalsStmt, "updated_at", ""), tenant, since); err != nil {
+		return res, fmt.Errorf("error counting details: %w", err)
+	}
+	res.Total = totals.Total
+	res.Listed = totals.Listed
+	res.Clean = totals.Total - totals.Listed
+
+	var codes []codeCount
+	if err := c.db.SelectContext(ctx, &codes, c.stats(statsCodesStmt, "updated_at", c.dialect.position("rest", "','")), tenant, since); err != nil {
+		return res, fmt.Errorf("error counting response codes: %w", err)
+	}
+	for _, cc := range codes {
+		res.ByListing = append(res.ByListing, &model.ListingCount{Listing: cc.Code, Count: cc.Count})
+	}
+
+	var days []dayCount
+	if err := c.db.SelectContext(ctx, &days, c.stats(statsLookupsStmt, "looked_up_at", c.dialect.day("looked_up_at")), tenant, since); err != nil {
+		return res, fmt.Errorf("error counting lookups per day: %w", err)
+	}
+	for _, dc := range days {
+		res.LookupsPerDay = append(res.LookupsPerDay, &model.DailyLookups{Day: dc.Day, Count: dc.Count})
+	}
+
+	var networks []networkCount
+	if err := c.db.SelectContext(ctx, &networks, c.stats(statsNetworksStmt, "updated_at", c.dialect.position("ip_address", "':'")), tenant, since, topNetworksLimit); err != nil {
+		return res, fmt.Errorf("error counting networks: %w", err)
+	}
+	for _, nc := range networks {
+		res.TopNetworks = append(res.TopNetworks, &model.NetworkCount{Network: nc.Network, Listed: nc.Listed})
+	}
+
+	return res, nil
+}
@@ -1,7 +1,9 @@
 package db
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"strings"
 	"testing"
 	"time"
@@ -23,7 +25,7 @@ func TestSqliteNewClient(t *testing.T) {
 	}
 
 	myMock.ExpectPing()
-	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS detail").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectInit(myMock)
 	myMock.ExpectClose()
 
 	db, err := NewClient("somefile.db")
@@ -42,6 +44,22 @@ func TestSqliteNewClient(t *testing.T) {
 	}
 }
 
+// expectInit expects a new client to set up a database that is already up to date.
+func expectInit(myMock sqlmock.Sqlmock) {
+	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectMigrated(myMock, sqliteDialect)
+}
+
+// expectMigrated expects a client to find that every migration has been applied.
+func expectMigrated(myMock sqlmock.Sqlmock, d dialect) {
+	migrations, _ := loadMigrations(d)
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, m := range migrations {
+		rows.AddRow(m.Version, time.Now())
+	}
+	myMock.ExpectQuery("SELECT version, applied_at FROM schema_version").WillReturnRows(rows)
+}
+
 func TestSqliteNewClientReturnsErrorOnBadOpen(t *testing.T) {
 	// insert mock db into package
 	sqliteDbOpener = func(dataSource string) (*sqlx.DB, error) {
@@ -91,7 +109,7 @@ func TestSqliteNewClientErrOnBadInit(t *testing.T) {
 	}
 
 	myMock.ExpectPing()
-	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS detail").WillReturnError(fmt.Errorf("some error"))
+	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").WillReturnError(fmt.Errorf("some error"))
 	myMock.ExpectClose()
 
 	_, err = NewClient("somefile.db")
@@ -125,10 +143,10 @@ func TestSqliteAddIPDetails(t *testing.T) {
 	}
 
 	myMock.ExpectPing()
-	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS detail").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectInit(myMock)
 	myMock.ExpectBegin()
-	myMock.ExpectQuery("SELECT \\* FROM detail").WithArgs("127.0.0.1").WillReturnRows(&sqlmock.Rows{})
-	myMock.ExpectExec("INSERT OR REPLACE INTO detail").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), testDetails.ResponseCode, testDetails.IPAddress).WillReturnResult(sqlmock.NewResult(1, 1))
+	myMock.ExpectExec("INSERT INTO detail(.+) ON CONFLICT\\(tenant, ip_address\\) DO UPDATE").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), testDetails.ResponseCode, testDetails.IPAddress, "red", []byte(net.ParseIP("127.0.0.1"))).WillReturnResult(sqlmock.NewResult(1, 1))
+	myMock.ExpectExec("INSERT INTO lookup").WithArgs(sqlmock.AnyArg(), "red", testDetails.IPAddress, testDetails.ResponseCode, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
 	myMock.ExpectCommit()
 	myMock.ExpectClose()
 
@@ -137,7 +155,7 @@ func TestSqliteAddIPDetails(t *testing.T) {
 		t.Errorf("unexpected error creating sqlite client: %s", err.Error())
 	}
 
-	err = db.AddIPDetails(testDetails)
+	err = db.AddIPDetails(context.Background(), "red", testDetails)
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -153,7 +171,7 @@ func TestSqliteAddIPDetails(t *testing.T) {
 	}
 }
 
-func TestSqliteUpdateIPDetails(t *testing.T) {
+func TestSqliteAddIPDetailsInsertErr(t *testing.T) {
 	mockDb, myMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
 	if err != nil {
 		t.Errorf("unexpected error creating mock db: %s", err.Error())
@@ -172,20 +190,11 @@ func TestSqliteUpdateIPDetails(t *testing.T) {
 		IPAddress:    "127.0.0.1",
 	}
 
-	rows := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "response_code", "ip_address"}).AddRow(
-		testDetails.UUID,
-		testDetails.CreatedAt,
-		testDetails.UpdatedAt,
-		testDetails.ResponseCode,
-		testDetails.IPAddress,
-	)
-
 	myMock.ExpectPing()
-	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS detail").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectInit(myMock)
 	myMock.ExpectBegin()
-	myMock.ExpectQuery("SELECT \\* FROM detail").WithArgs("127.0.0.1").WillReturnRows(rows)
-	myMock.ExpectExec("INSERT OR REPLACE INTO detail").WithArgs(testDetails.UUID, testDetails.CreatedAt, sqlmock.AnyArg(), sqlmock.AnyArg(), testDetails.IPAddress).WillReturnResult(sqlmock.NewResult(1, 1))
-	myMock.ExpectCommit()
+	myMock.ExpectExec("INSERT INTO detail").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), testDetails.ResponseCode, testDetails.IPAddress, "red", []byte(net.ParseIP("127.0.0.1"))).WillReturnError(fmt.Errorf("some error"))
+	myMock.ExpectRollback()
 	myMock.ExpectClose()
 
 	db, err := NewClient("somefile.db")
@@ -193,9 +202,9 @@ func TestSqliteUpdateIPDetails(t *testing.T) {
 		t.Errorf("unexpected error creating sqlite client: %s", err.Error())
 	}
 
-	err = db.AddIPDetails(testDetails)
-	if err != nil {
-		t.Error(err.Error())
+	err = db.AddIPDetails(context.Background(), "red", testDetails)
+	if err == nil {
+		t.Error("expected error from AddIPDetail")
 	}
 
 	err = db.Close()
@@ -209,17 +218,12 @@ func TestSqliteUpdateIPDetails(t *testing.T) {
 	}
 }
 
-func TestSqliteAddIPDetailsTxErr(t *testing.T) {
+func TestSqliteGetIPDetails(t *testing.T) {
 	mockDb, myMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
 	if err != nil {
 		t.Errorf("unexpected error creating mock db: %s", err.Error())
 	}
 
-	// insert mock db into package
-	sqliteDbOpener = func(dataSource string) (*sqlx.DB, error) {
-		return sqlx.NewDb(mockDb, "sqlmock"), nil
-	}
-
 	testDetails := model.IPDetails{
 		UUID:         "61996e6d-bffd-42eb-9641-7567e709f6a7",
 		CreatedAt:    time.Now(),
@@ -228,56 +232,14 @@ func TestSqliteAddIPDetailsTxErr(t *testing.T) {
 		IPAddress:    "127.0.0.1",
 	}
 
-	myMock.ExpectPing()
-	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS detail").WillReturnResult(sqlmock.NewResult(1, 1))
-	myMock.ExpectBegin().WillReturnError(fmt.Errorf("some error"))
-	myMock.ExpectClose()
-
-	db, err := NewClient("somefile.db")
-	if err != nil {
-		t.Errorf("unexpected error creating sqlite client: %s", err.Error())
-	}
-
-	err = db.AddIPDetails(testDetails)
-	if err == nil {
-		t.Error("expected error from AddIPDetails when Begin() fails")
-	}
-
-	err = db.Close()
-	if err != nil {
-		t.Error(err.Error())
-	}
-
-	err = myMock.ExpectationsWereMet()
-	if err != nil {
-		t.Error(err.Error())
-	}
-}
-
-func TestSqliteAddIPDetailsQueryErr(t *testing.T) {
-	mockDb, myMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
-	if err != nil {
-		t.Errorf("unexpected error creating mock db: %s", err.Error())
-	}
-
 	// insert mock db into package
 	sqliteDbOpener = func(dataSource string) (*sqlx.DB, error) {
 		return sqlx.NewDb(mockDb, "sqlmock"), nil
 	}
 
-	testDetails := model.IPDetails{
-		UUID:         "61996e6d-bffd-42eb-9641-7567e709f6a7",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
-		ResponseCode: "123456",
-		IPAddress:    "127.0.0.1",
-	}
-
 	myMock.ExpectPing()
-	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS detail").WillReturnResult(sqlmock.NewResult(1, 1))
-	myMock.ExpectBegin()
-	myMock.ExpectQuery("SELECT \\* FROM detail").WithArgs("127.0.0.1").WillReturnError(fmt.Errorf("some error"))
-	myMock.ExpectRollback()
+	expectInit(myMock)
+	myMock.ExpectQuery("SELECT \\* FROM detail").WithArgs("red", "127.0.0.1").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "response_code", "ip_address"}).AddRow(testDetails.UUID, testDetails.CreatedAt, testDetails.UpdatedAt, testDetails.ResponseCode, testDetails.IPAddress))
 	myMock.ExpectClose()
 
 	db, err := NewClient("somefile.db")
@@ -285,57 +247,13 @@ func TestSqliteAddIPDetailsQueryErr(t *testing.T) {
 		t.Errorf("unexpected error creating sqlite client: %s", err.Error())
 	}
 
-	err = db.AddIPDetails(testDetails)
-	if err == nil {
-		t.Error("expected error from AddIPDetail")
-	}
-
-	err = db.Close()
-	if err != nil {
-		t.Error(err.Error())
-	}
-
-	err = myMock.ExpectationsWereMet()
+	d, err := db.GetIPDetails(context.Background(), "red", "127.0.0.1")
 	if err != nil {
 		t.Error(err.Error())
 	}
-}
-
-func TestSqliteAddIPDetailsInsertErr(t *testing.T) {
-	mockDb, myMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
-	if err != nil {
-		t.Errorf("unexpected error creating mock db: %s", err.Error())
-	}
-
-	// insert mock db into package
-	sqliteDbOpener = func(dataSource string) (*sqlx.DB, error) {
-		return sqlx.NewDb(mockDb, "sqlmock"), nil
-	}
 
-	testDetails := model.IPDetails{
-		UUID:         "61996e6d-bffd-42eb-9641-7567e709f6a7",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
-		ResponseCode: "123456",
-		IPAddress:    "127.0.0.1",
-	}
-
-	myMock.ExpectPing()
-	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS detail").WillReturnResult(sqlmock.NewResult(1, 1))
-	myMock.ExpectBegin()
-	myMock.ExpectQuery("SELECT \\* FROM detail").WithArgs("127.0.0.1").WillReturnRows(&sqlmock.Rows{})
-	myMock.ExpectExec("INSERT OR REPLACE INTO detail").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), testDetails.ResponseCode, testDetails.IPAddress).WillReturnError(fmt.Errorf("some error"))
-	myMock.ExpectRollback()
-	myMock.ExpectClose()
-
-	db, err := NewClient("somefile.db")
-	if err != nil {
-		t.Errorf("unexpected error creating sqlite client: %s", err.Error())
-	}
-
-	err = db.AddIPDetails(testDetails)
-	if err == nil {
-		t.Error("expected error from AddIPDetail")
+	if d != testDetails {
+		t.Error("details don't match expectation")
 	}
 
 	err = db.Close()
@@ -349,7 +267,7 @@ func TestSqliteAddIPDetailsInsertErr(t *testing.T) {
 	}
 }
 
-func TestSqliteAddIPDetailsCommitErr(t *testing.T) {
+func TestSqliteGetIPDetailsNotFound(t *testing.T) {
 	mockDb, myMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
 	if err != nil {
 		t.Errorf("unexpected error creating mock db: %s", err.Error())
@@ -360,20 +278,9 @@ func TestSqliteAddIPDetailsCommitErr(t *testing.T) {
 		return sqlx.NewDb(mockDb, "sqlmock"), nil
 	}
 
-	testDetails := model.IPDetails{
-		UUID:         "61996e6d-bffd-42eb-9641-7567e709f6a7",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
-		ResponseCode: "123456",
-		IPAddress:    "127.0.0.1",
-	}
-
 	myMock.ExpectPing()
-	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS detail").WillReturnResult(sqlmock.NewResult(1, 1))
-	myMock.ExpectBegin()
-	myMock.ExpectQuery("SELECT \\* FROM detail").WithArgs("127.0.0.1").WillReturnRows(&sqlmock.Rows{})
-	myMock.ExpectExec("INSERT OR REPLACE INTO detail").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), testDetails.ResponseCode, testDetails.IPAddress).WillReturnResult(sqlmock.NewResult(1, 1))
-	myMock.ExpectCommit().WillReturnError(fmt.Errorf("some error"))
+	expectInit(myMock)
+	myMock.ExpectQuery("SELECT \\* FROM detail").WithArgs("red", "127.0.0.1").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "response_code", "ip_address"}))
 	myMock.ExpectClose()
 
 	db, err := NewClient("somefile.db")
@@ -381,9 +288,9 @@ func TestSqliteAddIPDetailsCommitErr(t *testing.T) {
 		t.Errorf("unexpected error creating sqlite client: %s", err.Error())
 	}
 
-	err = db.AddIPDetails(testDetails)
-	if err == nil {
-		t.Error("expected error from AddIPDetail when commit fails")
+	_, err = db.GetIPDetails(context.Background(), "red", "127.0.0.1")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Error("expected an 'error not found'")
 	}
 
 	err = db.Close()
@@ -397,28 +304,25 @@ func TestSqliteAddIPDetailsCommitErr(t *testing.T) {
 	}
 }
 
-func TestSqliteGetIPDetails(t *testing.T) {
+func TestSqliteGetStats(t *testing.T) {
 	mockDb, myMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
 	if err != nil {
 		t.Errorf("unexpected error creating mock db: %s", err.Error())
 	}
 
-	testDetails := model.IPDetails{
-		UUID:         "61996e6d-bffd-42eb-9641-7567e709f6a7",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
-		ResponseCode: "123456",
-		IPAddress:    "127.0.0.1",
-	}
-
 	// insert mock db into package
 	sqliteDbOpener = func(dataSource string) (*sqlx.DB, error) {
 		return sqlx.NewDb(mockDb, "sqlmock"), nil
 	}
 
+	since := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+
 	myMock.ExpectPing()
-	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS detail").WillReturnResult(sqlmock.NewResult(1, 1))
-	myMock.ExpectQuery("SELECT \\* FROM detail").WithArgs("127.0.0.1").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "response_code", "ip_address"}).AddRow(testDetails.UUID, testDetails.CreatedAt, testDetails.UpdatedAt, testDetails.ResponseCode, testDetails.IPAddress))
+	expectInit(myMock)
+	myMock.ExpectQuery("SELECT(.|\n)*AS total").WithArgs("red", since).WillReturnRows(sqlmock.NewRows([]string{"total", "listed"}).AddRow(5, 2))
+	myMock.ExpectQuery("WITH RECURSIVE split").WithArgs("red", since).WillReturnRows(sqlmock.NewRows([]string{"code", "count"}).AddRow("127.0.0.2", 2).AddRow("127.0.0.4", 1))
+	myMock.ExpectQuery("SELECT date\\(looked_up_at\\)(.|\n)*FROM lookup").WithArgs("red", since).WillReturnRows(sqlmock.NewRows([]string{"day", "count"}).AddRow("2021-04-01", 5))
+	myMock.ExpectQuery("SELECT rtrim\\(ip_address").WithArgs("red", since, topNetworksLimit).WillReturnRows(sqlmock.NewRows([]string{"network", "listed"}).AddRow("127.0.0.0/24", 2))
 	myMock.ExpectClose()
 
 	db, err := NewClient("somefile.db")
@@ -426,13 +330,22 @@ func TestSqliteGetIPDetails(t *testing.T) {
 		t.Errorf("unexpected error creating sqlite client: %s", err.Error())
 	}
 
-	d, err := db.GetIPDetails("127.0.0.1")
+	s, err := db.GetStats(context.Background(), "red", since)
 	if err != nil {
 		t.Error(err.Error())
 	}
 
-	if d != testDetails {
-		t.Error("details don't match expectation")
+	if s.Total != 5 || s.Listed != 2 || s.Clean != 3 {
+		t.Errorf("unexpected totals: %d total, %d listed, %d clean", s.Total, s.Listed, s.Clean)
+	}
+	if len(s.ByListing) != 2 || s.ByListing[0].Listing != "127.0.0.2" {
+		t.Error("response code counts don't match expectation")
+	}
+	if len(s.LookupsPerDay) != 1 || s.LookupsPerDay[0].Count != 5 {
+		t.Error("lookups per day don't match expectation")
+	}
+	if len(s.TopNetworks) != 1 || s.TopNetworks[0].Network != "127.0.0.0/24" {
+		t.Error("top networks don't match expectation")
 	}
 
 	err = db.Close()
@@ -446,39 +359,38 @@ func TestSqliteGetIPDetails(t *testing.T) {
 	}
 }
 
-func TestSqliteGetIPDetailsNotFound(t *testing.T) {
-	mockDb, myMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+func TestSqliteDeleteIPDetails(t *testing.T) {
+	db, myMock := newMockClient(t)
+
+	myMock.ExpectExec("DELETE FROM detail WHERE tenant = \\? AND ip_address IN \\(\\?, \\?\\)").WithArgs("red", "1.2.3.4", "5.6.7.8").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := db.DeleteIPDetails(context.Background(), "red", []string{"1.2.3.4", "5.6.7.8"})
 	if err != nil {
-		t.Errorf("unexpected error creating mock db: %s", err.Error())
+		t.Error(err.Error())
 	}
-
-	// insert mock db into package
-	sqliteDbOpener = func(dataSource string) (*sqlx.DB, error) {
-		return sqlx.NewDb(mockDb, "sqlmock"), nil
+	if n != 1 {
+		t.Errorf("expected 1 row deleted, got %d", n)
 	}
 
-	myMock.ExpectPing()
-	myMock.ExpectExec("CREATE TABLE IF NOT EXISTS detail").WillReturnResult(sqlmock.NewResult(1, 1))
-	myMock.ExpectQuery("SELECT \\* FROM detail").WithArgs("127.0.0.1").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "response_code", "ip_address"}))
-	myMock.ExpectClose()
+	closeMockClient(t, db, myMock)
+}
 
-	db, err := NewClient("somefile.db")
-	if err != nil {
-		t.Errorf("unexpected error creating sqlite client: %s", err.Error())
-	}
+func TestSqliteSharedResult(t *testing.T) {
+	db, myMock := newMockClient(t)
 
-	_, err = db.GetIPDetails("127.0.0.1")
-	if err == nil || !strings.Contains(err.Error(), "not found") {
-		t.Error("expected an 'error not found'")
-	}
+	since := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	myMock.ExpectQuery("SELECT response_code FROM lookup").WithArgs("1.2.3.4", since, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"response_code"}).AddRow("127.0.0.2"))
+	myMock.ExpectQuery("SELECT response_code FROM lookup").WithArgs("5.6.7.8", since, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"response_code"}))
 
-	err = db.Close()
-	if err != nil {
-		t.Error(err.Error())
+	code, ok, err := db.SharedResult(context.Background(), "1.2.3.4", since)
+	if err != nil || !ok || code != "127.0.0.2" {
+		t.Errorf("expected shared result 127.0.0.2, got %q %v %v", code, ok, err)
 	}
 
-	err = myMock.ExpectationsWereMet()
-	if err != nil {
-		t.Error(err.Error())
+	_, ok, err = db.SharedResult(context.Background(), "5.6.7.8", since)
+	if err != nil || ok {
+		t.Errorf("expected no shared result, got %v %v", ok, err)
 	}
+
+	closeMockClient(t, db, myMock)
 }
@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jdharms/threat-detect/graph/model"
@@ -13,6 +14,8 @@ type IPDetails struct {
 	UpdatedAt    time.Time `db:"updated_at"`
 	ResponseCode string    `db:"response_code"`
 	IPAddress    string    `db:"ip_address"`
+	Tenant       string    `db:"tenant"`
+	IPBytes      []byte    `db:"ip_bytes"`
 }
 
 func dbModelToGraphQL(d IPDetails) model.IPDetails {
@@ -28,12 +31,12 @@ func dbModelToGraphQL(d IPDetails) model.IPDetails {
 }
 
 type ErrNotFound struct {
-	ipAddr   string
+	what     string
 	innerErr error
 }
 
 func (e ErrNotFound) Error() string {
-	return fmt.Sprintf("details for ip address %s not found", e.ipAddr)
+	return fmt.Sprintf("%s not found", e.what)
 }
 
 func (e ErrNotFound) Unwrap() error {
@@ -42,7 +45,132 @@ func (e ErrNotFound) Unwrap() error {
 
 func newErrNotFound(ipAddr string, innerErr error) ErrNotFound {
 	return ErrNotFound{
-		ipAddr:   ipAddr,
+		what:     fmt.Sprintf("details for ip address %s", ipAddr),
 		innerErr: innerErr,
 	}
 }
+
+func newErrAPIKeyNotFound(id string, innerErr error) ErrNotFound {
+	return ErrNotFound{
+		what:     fmt.Sprintf("api key %s", id),
+		innerErr: innerErr,
+	}
+}
+
+type statsTotals struct {
+	Total  int `db:"total"`
+	Listed int `db:"listed"`
+}
+
+type codeCount struct {
+	Code  string `db:"code"`
+	Count int    `db:"count"`
+}
+
+type dayCount struct {
+	Day   string `db:"day"`
+	Count int    `db:"count"`
+}
+
+// Lookup records one lookup of an address and its result.
+type Lookup struct {
+	ID           string    `db:"id"`
+	Tenant       string    `db:"tenant"`
+	IPAddress    string    `db:"ip_address"`
+	ResponseCode string    `db:"response_code"`
+	LookedUpAt   time.Time `db:"looked_up_at"`
+}
+
+type networkCount struct {
+	Network string `db:"network"`
+	Listed  int    `db:"listed"`
+}
+
+type APIKey struct {
+	ID        string     `db:"id"`
+	Name      string     `db:"name"`
+	Owner     string     `db:"owner"`
+	KeyHash   string     `db:"key_hash"`
+	Scopes    string     `db:"scopes"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt *time.Time `db:"expires_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+	Tenant    string     `db:"tenant"`
+}
+
+func dbAPIKeyToGraphQL(k APIKey) model.APIKey {
+	res := model.APIKey{
+		ID:        k.ID,
+		Name:      k.Name,
+		Owner:     k.Owner,
+		Scopes:    []model.APIKeyScope{},
+		CreatedAt: k.CreatedAt,
+		ExpiresAt: k.ExpiresAt,
+		RevokedAt: k.RevokedAt,
+	}
+	for _, scope := range strings.Split(k.Scopes, ",") {
+		if scope != "" {
+			res.Scopes = append(res.Scopes, model.APIKeyScope(scope))
+		}
+	}
+
+	return res
+}
+
+type AuditEntry struct {
+	ID        string    `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	Principal string    `db:"principal"`
+	APIKeyID  *string   `db:"api_key_id"`
+	ClientIP  string    `db:"client_ip"`
+	Operation string    `db:"operation"`
+	Arguments string    `db:"arguments"`
+	Tenant    string    `db:"tenant"`
+}
+
+func dbAuditEntryToGraphQL(e AuditEntry) model.AuditEntry {
+	return model.AuditEntry{
+		ID:        e.ID,
+		CreatedAt: e.CreatedAt,
+		Principal: e.Principal,
+		APIKeyID:  e.APIKeyID,
+		ClientIP:  e.ClientIP,
+		Operation: model.AuditOperation(e.Operation),
+		Arguments: e.Arguments,
+	}
+}
+
+type Tag struct {
+	Tenant    string    `db:"tenant"`
+	IPAddress string    `db:"ip_address"`
+	Tag       string    `db:"tag"`
+	Author    string    `db:"author"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func dbTagToGraphQL(t Tag) model.Tag {
+	return model.Tag{
+		Name:      t.Tag,
+		Author:    t.Author,
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+type Note struct {
+	ID        string    `db:"id"`
+	Tenant    string    `db:"tenant"`
+	IPAddress string    `db:"ip_address"`
+	Body      string    `db:"body"`
+	Author    string    `db:"author"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func dbNoteToGraphQL(n Note) model.Note {
+	return model.Note{
+		ID:        n.ID,
+		IPAddress: n.IPAddress,
+		Body:      n.Body,
+		Author:    n.Author,
+		CreatedAt: n.CreatedAt,
+	}
+}
@@ -1,24 +1,84 @@
 package dnsbl
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
-// Assigning net.LookupHost to a package internal variable lets us patch it away for tests without
+// SpamhausZone is Spamhaus ZEN, whose response codes ListingName decodes.
+const SpamhausZone = "zen.spamhaus.org"
+
+// Assigning the lookup to a package internal variable lets us patch it away for tests without
 // disrupting users of this package.
-var netLookupHost = net.LookupHost
+var netLookupHost = func(ctx context.Context, resolver *net.Resolver, host string) ([]string, error) {
+	return resolver.LookupHost(ctx, host)
+}
 
-type SpamhausClient struct{}
+// Config describes the DNSBLs a Client checks and how it queries them.
+type Config struct {
+	// Zones are checked in order; codes from zones other than SpamhausZone are
+	// returned prefixed with the zone, e.g. "bl.spamcop.net:127.0.0.2".
+	Zones []string
+	// Resolvers are "host:port" DNS servers, tried in turn.  The system resolver is
+	// used if there are none.
+	Resolvers []string
+	// Timeout bounds each query; zero leaves it to the resolver.
+	Timeout time.Duration
+	// Workers limits how many addresses are looked up at once; zero means no limit.
+	Workers int
+}
 
-func NewSpamhausClient() SpamhausClient {
-	return SpamhausClient{}
+type Client struct {
+	zones    []string
+	resolver *net.Resolver
+	timeout  time.Duration
+	workers  chan struct{}
 }
 
-func (sc SpamhausClient) Query(ip string) (string, error) {
-	if net.ParseIP(ip) == nil {
-		return "", newInvalidIPv4AddrError(ip)
+func NewClient(cfg Config) *Client {
+	c := &Client{
+		zones:    cfg.Zones,
+		resolver: net.DefaultResolver,
+		timeout:  cfg.Timeout,
+	}
+	if len(cfg.Resolvers) > 0 {
+		c.resolver = customResolver(cfg.Resolvers)
+	}
+	if cfg.Workers > 0 {
+		c.workers = make(chan struct{}, cfg.Workers)
+	}
+	return c
+}
+
+// NewSpamhausClient checks addresses against Spamhaus ZEN using the system resolver.
+func NewSpamhausClient() *Client {
+	return NewClient(Config{Zones: []string{SpamhausZone}})
+}
+
+// customResolver sends queries to servers, moving on to the next server with each
+// connection.
+func customResolver(servers []string) *net.Resolver {
+	var next uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := servers[int(atomic.AddUint32(&next, 1)-1)%len(servers)]
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// Query returns the comma separated response codes of the zones listing ip, or an
+// empty string if none do.  It gives up when ctx is done, including while waiting for
+// a worker.
+func (c *Client) Query(ctx context.Context, ip string) (string, error) {
+	if err := ValidateIPv4(ip); err != nil {
+		return "", err
 	}
 
 	reversed, err := reverseOctets(ip)
@@ -26,27 +86,44 @@ func (sc SpamhausClient) Query(ip string) (string, error) {
 		return "", err
 	}
 
-	queryAddress := reversed + ".zen.spamhaus.org"
-
-	results, err := netLookupHost(queryAddress)
-	if err != nil {
-		if strings.Contains(err.Error(), "no such host") {
-			return "", nil
+	if c.workers != nil {
+		select {
+		case c.workers <- struct{}{}:
+			defer func() { <-c.workers }()
+		case <-ctx.Done():
+			return "", ctx.Err()
 		}
-
-		return "", err
 	}
 
-	var builder strings.Builder
-	for i, code := range results {
-		if i == 0 {
-			builder.WriteString(code)
-		} else {
-			builder.WriteString(fmt.Sprintf(",%s", code))
+	var codes []string
+	for _, zone := range c.zones {
+		results, err := c.lookup(ctx, reversed+"."+zone)
+		if err != nil {
+			if strings.Contains(err.Error(), "no such host") {
+				continue
+			}
+
+			return "", fmt.Errorf("error querying %s: %w", zone, err)
+		}
+
+		for _, code := range results {
+			if zone != SpamhausZone {
+				code = zone + ":" + code
+			}
+			codes = append(codes, code)
 		}
 	}
 
-	return builder.String(), nil
+	return strings.Join(codes, ","), nil
+}
+
+func (c *Client) lookup(ctx context.Context, host string) ([]string, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	return netLookupHost(ctx, c.resolver, host)
 }
 
 // Caller may want to know if failure is due to an invalid IP, so we'll make this a separate type.
@@ -62,6 +139,38 @@ func (i InvalidIPv4AddrError) Error() string {
 	return fmt.Sprintf("%s is not a valid IPv4 address", i.ip)
 }
 
+// ValidateIPv4 returns an InvalidIPv4AddrError if ip is not a dotted-quad IPv4 address.
+func ValidateIPv4(ip string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() == nil || strings.Contains(ip, ":") {
+		return newInvalidIPv4AddrError(ip)
+	}
+	return nil
+}
+
+// ValidateResponseCode returns an error unless code has the form Query returns: empty, or
+// comma separated DNSBL answers, each a 127.0.0.0/8 address optionally prefixed with its
+// zone.  It is used to check results that didn't come from Query, such as imported ones.
+func ValidateResponseCode(code string) error {
+	if code == "" {
+		return nil
+	}
+	for _, answer := range strings.Split(code, ",") {
+		addr := answer
+		if i := strings.LastIndex(answer, ":"); i >= 0 {
+			if answer[:i] == "" {
+				return fmt.Errorf("invalid response code %q", code)
+			}
+			addr = answer[i+1:]
+		}
+		parsed := net.ParseIP(addr)
+		if ValidateIPv4(addr) != nil || !parsed.IsLoopback() {
+			return fmt.Errorf("invalid response code %q", code)
+		}
+	}
+	return nil
+}
+
 func reverseOctets(addr string) (string, error) {
 	octets := strings.Split(addr, ".")
 	if len(octets) != 4 {
@@ -71,3 +180,26 @@ func reverseOctets(addr string) (string, error) {
 	reversed := strings.Join(octets, ".")
 	return reversed, nil
 }
+
+// Spamhaus ZEN encodes which of its component lists an address appears on in the
+// returned A record.  See https://www.spamhaus.org/faq/section/DNSBL%20Usage#200
+var zenListings = map[string]string{
+	"127.0.0.2":  "SBL",
+	"127.0.0.3":  "SBL CSS",
+	"127.0.0.4":  "XBL",
+	"127.0.0.5":  "XBL",
+	"127.0.0.6":  "XBL",
+	"127.0.0.7":  "XBL",
+	"127.0.0.9":  "SBL DROP",
+	"127.0.0.10": "PBL ISP",
+	"127.0.0.11": "PBL Spamhaus",
+}
+
+// ListingName decodes a single Spamhaus ZEN response code into the name of the list
+// it represents.  Codes we don't recognize are returned unchanged.
+func ListingName(code string) string {
+	if name, ok := zenListings[code]; ok {
+		return name
+	}
+	return code
+}
@@ -1,9 +1,14 @@
 package dnsbl
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type lookupMock struct {
@@ -12,8 +17,8 @@ type lookupMock struct {
 	ResultError   error
 }
 
-func netLookupPatch(mock *lookupMock) func(string) ([]string, error) {
-	return func(s string) ([]string, error) {
+func netLookupPatch(mock *lookupMock) func(context.Context, *net.Resolver, string) ([]string, error) {
+	return func(ctx context.Context, r *net.Resolver, s string) ([]string, error) {
 		mock.ReceivedIP = s
 		return mock.ResultStrings, mock.ResultError
 	}
@@ -74,7 +79,7 @@ func TestSpamhausQuery(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			netLookupHost = netLookupPatch(&test.mock)
 			c := NewSpamhausClient()
-			res, err := c.Query(test.ip)
+			res, err := c.Query(context.Background(), test.ip)
 			if !errorContains(err, test.errKey) {
 				t.Errorf("Expected error '%s' but found '%s'", test.errKey, err.Error())
 			}
@@ -89,6 +94,97 @@ func TestSpamhausQuery(t *testing.T) {
 	}
 }
 
+func TestQueryMultipleZones(t *testing.T) {
+	var queried []string
+	netLookupHost = func(ctx context.Context, r *net.Resolver, host string) ([]string, error) {
+		queried = append(queried, host)
+		switch {
+		case strings.HasSuffix(host, SpamhausZone):
+			return []string{"127.0.0.2"}, nil
+		case strings.HasSuffix(host, "bl.spamcop.net"):
+			return []string{"127.0.0.2"}, nil
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+
+	c := NewClient(Config{Zones: []string{SpamhausZone, "bl.spamcop.net", "dnsbl.example.org"}, Timeout: time.Second})
+	res, err := c.Query(context.Background(), "1.2.3.4")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if res != "127.0.0.2,bl.spamcop.net:127.0.0.2" {
+		t.Errorf("unexpected result %q", res)
+	}
+	if len(queried) != 3 {
+		t.Errorf("expected every zone to be queried, got %v", queried)
+	}
+}
+
+func TestQueryLimitsWorkers(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	netLookupHost = func(ctx context.Context, r *net.Resolver, host string) ([]string, error) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil, fmt.Errorf("no such host")
+	}
+
+	c := NewClient(Config{Zones: []string{SpamhausZone}, Workers: 2})
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Query(context.Background(), "1.2.3.4")
+		}()
+	}
+	wg.Wait()
+
+	if most > 2 {
+		t.Errorf("expected at most 2 lookups at once, got %d", most)
+	}
+}
+
+func TestQueryCancelledWhileWaiting(t *testing.T) {
+	release := make(chan struct{})
+	netLookupHost = func(ctx context.Context, r *net.Resolver, host string) ([]string, error) {
+		<-release
+		return nil, fmt.Errorf("no such host")
+	}
+
+	c := NewClient(Config{Zones: []string{SpamhausZone}, Workers: 1})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Query(context.Background(), "1.2.3.4")
+	}()
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	// wait for the only worker to be taken
+	for len(c.workers) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Query(ctx, "1.2.3.5"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the query to give up waiting for a worker, got %v", err)
+	}
+}
+
 func errorContains(e error, want string) bool {
 	if e == nil {
 		return want == ""
@@ -98,3 +194,77 @@ func errorContains(e error, want string) bool {
 	}
 	return strings.Contains(e.Error(), want)
 }
+
+func TestListingName(t *testing.T) {
+	testCases := []struct {
+		code     string
+		expected string
+	}{
+		{"127.0.0.2", "SBL"},
+		{"127.0.0.4", "XBL"},
+		{"127.0.0.7", "XBL"},
+		{"127.0.0.11", "PBL Spamhaus"},
+		{"127.255.255.254", "127.255.255.254"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.code, func(t *testing.T) {
+			if res := ListingName(test.code); res != test.expected {
+				t.Errorf("expected listing '%s' but got '%s'", test.expected, res)
+			}
+		})
+	}
+}
+
+func TestValidateIPv4(t *testing.T) {
+	testCases := []struct {
+		ip    string
+		valid bool
+	}{
+		{"1.2.3.4", true},
+		{"255.255.255.255", true},
+		{"foobar", false},
+		{"1.2.3", false},
+		{"2001:db8::1", false},
+		{"::ffff:1.2.3.4", false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.ip, func(t *testing.T) {
+			err := ValidateIPv4(test.ip)
+			if (err == nil) != test.valid {
+				t.Errorf("expected valid=%t for %s, got error %v", test.valid, test.ip, err)
+			}
+			if err != nil {
+				if _, ok := err.(InvalidIPv4AddrError); !ok {
+					t.Errorf("expected an InvalidIPv4AddrError, got %T", err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateResponseCode(t *testing.T) {
+	testCases := []struct {
+		code  string
+		valid bool
+	}{
+		{"", true},
+		{"127.0.0.2", true},
+		{"127.0.0.2,127.0.0.4", true},
+		{"127.0.0.2,bl.spamcop.net:127.0.0.2", true},
+		{"1.2.3.4", false},
+		{"clean", false},
+		{"127.0.0.2,", false},
+		{":127.0.0.2", false},
+		{"bl.spamcop.net:8.8.8.8", false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.code, func(t *testing.T) {
+			if err := ValidateResponseCode(test.code); (err == nil) != test.valid {
+				t.Errorf("expected valid=%t for %q, got error %v", test.valid, test.code, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,453 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: detect.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetIPDetailsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ip            string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetIPDetailsRequest) Reset() {
+	*x = GetIPDetailsRequest{}
+	mi := &file_detect_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetIPDetailsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetIPDetailsRequest) ProtoMessage() {}
+
+func (x *GetIPDetailsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_detect_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetIPDetailsRequest.ProtoReflect.Descriptor instead.
+func (*GetIPDetailsRequest) Descriptor() ([]byte, []int) {
+	return file_detect_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetIPDetailsRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+type IPDetails struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Uuid      string                 `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	CreatedAt *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	// Comma separated Spamhaus response codes.  Empty if the address is not listed.
+	ResponseCode  string `protobuf:"bytes,4,opt,name=response_code,json=responseCode,proto3" json:"response_code,omitempty"`
+	IpAddress     string `protobuf:"bytes,5,opt,name=ip_address,json=ipAddress,proto3" json:"ip_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IPDetails) Reset() {
+	*x = IPDetails{}
+	mi := &file_detect_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IPDetails) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IPDetails) ProtoMessage() {}
+
+func (x *IPDetails) ProtoReflect() protoreflect.Message {
+	mi := &file_detect_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IPDetails.ProtoReflect.Descriptor instead.
+func (*IPDetails) Descriptor() ([]byte, []int) {
+	return file_detect_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *IPDetails) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+func (x *IPDetails) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *IPDetails) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *IPDetails) GetResponseCode() string {
+	if x != nil {
+		return x.ResponseCode
+	}
+	return ""
+}
+
+func (x *IPDetails) GetIpAddress() string {
+	if x != nil {
+		return x.IpAddress
+	}
+	return ""
+}
+
+type EnqueueRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ips           []string               `protobuf:"bytes,1,rep,name=ips,proto3" json:"ips,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnqueueRequest) Reset() {
+	*x = EnqueueRequest{}
+	mi := &file_detect_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnqueueRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnqueueRequest) ProtoMessage() {}
+
+func (x *EnqueueRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_detect_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnqueueRequest.ProtoReflect.Descriptor instead.
+func (*EnqueueRequest) Descriptor() ([]byte, []int) {
+	return file_detect_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *EnqueueRequest) GetIps() []string {
+	if x != nil {
+		return x.Ips
+	}
+	return nil
+}
+
+type EnqueueResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	JobId         string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	QueuedIps     []string               `protobuf:"bytes,2,rep,name=queued_ips,json=queuedIps,proto3" json:"queued_ips,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnqueueResponse) Reset() {
+	*x = EnqueueResponse{}
+	mi := &file_detect_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnqueueResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnqueueResponse) ProtoMessage() {}
+
+func (x *EnqueueResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_detect_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnqueueResponse.ProtoReflect.Descriptor instead.
+func (*EnqueueResponse) Descriptor() ([]byte, []int) {
+	return file_detect_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *EnqueueResponse) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *EnqueueResponse) GetQueuedIps() []string {
+	if x != nil {
+		return x.QueuedIps
+	}
+	return nil
+}
+
+type CheckRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ip            string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckRequest) Reset() {
+	*x = CheckRequest{}
+	mi := &file_detect_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckRequest) ProtoMessage() {}
+
+func (x *CheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_detect_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckRequest.ProtoReflect.Descriptor instead.
+func (*CheckRequest) Descriptor() ([]byte, []int) {
+	return file_detect_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CheckRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+type CheckResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Ip    string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	// Set when the lookup succeeded.
+	Details *IPDetails `protobuf:"bytes,2,opt,name=details,proto3" json:"details,omitempty"`
+	// Set when the lookup failed, using the same codes as the GraphQL API
+	// (INVALID_INPUT, INTERNAL, ...).
+	ErrorCode     string `protobuf:"bytes,3,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	ErrorMessage  string `protobuf:"bytes,4,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckResponse) Reset() {
+	*x = CheckResponse{}
+	mi := &file_detect_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckResponse) ProtoMessage() {}
+
+func (x *CheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_detect_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckResponse.ProtoReflect.Descriptor instead.
+func (*CheckResponse) Descriptor() ([]byte, []int) {
+	return file_detect_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CheckResponse) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetDetails() *IPDetails {
+	if x != nil {
+		return x.Details
+	}
+	return nil
+}
+
+func (x *CheckResponse) GetErrorCode() string {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetErrorMessage() string {
+	if x != nil {
+		return x.ErrorMessage
+	}
+	return ""
+}
+
+var File_detect_proto protoreflect.FileDescriptor
+
+const file_detect_proto_rawDesc = "" +
+	"\n" +
+	"\fdetect.proto\x12\tdetect.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"%\n" +
+	"\x13GetIPDetailsRequest\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\"\xd9\x01\n" +
+	"\tIPDetails\x12\x12\n" +
+	"\x04uuid\x18\x01 \x01(\tR\x04uuid\x129\n" +
+	"\n" +
+	"created_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12#\n" +
+	"\rresponse_code\x18\x04 \x01(\tR\fresponseCode\x12\x1d\n" +
+	"\n" +
+	"ip_address\x18\x05 \x01(\tR\tipAddress\"\"\n" +
+	"\x0eEnqueueRequest\x12\x10\n" +
+	"\x03ips\x18\x01 \x03(\tR\x03ips\"G\n" +
+	"\x0fEnqueueResponse\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12\x1d\n" +
+	"\n" +
+	"queued_ips\x18\x02 \x03(\tR\tqueuedIps\"\x1e\n" +
+	"\fCheckRequest\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\"\x93\x01\n" +
+	"\rCheckResponse\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x12.\n" +
+	"\adetails\x18\x02 \x01(\v2\x14.detect.v1.IPDetailsR\adetails\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\x03 \x01(\tR\terrorCode\x12#\n" +
+	"\rerror_message\x18\x04 \x01(\tR\ferrorMessage2\xd6\x01\n" +
+	"\x06Detect\x12D\n" +
+	"\fGetIPDetails\x12\x1e.detect.v1.GetIPDetailsRequest\x1a\x14.detect.v1.IPDetails\x12@\n" +
+	"\aEnqueue\x12\x19.detect.v1.EnqueueRequest\x1a\x1a.detect.v1.EnqueueResponse\x12D\n" +
+	"\vCheckStream\x12\x17.detect.v1.CheckRequest\x1a\x18.detect.v1.CheckResponse(\x010\x01B6Z4github.com/jdharms/threat-detect/internal/grpcapi/pbb\x06proto3"
+
+var (
+	file_detect_proto_rawDescOnce sync.Once
+	file_detect_proto_rawDescData []byte
+)
+
+func file_detect_proto_rawDescGZIP() []byte {
+	file_detect_proto_rawDescOnce.Do(func() {
+		file_detect_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_detect_proto_rawDesc), len(file_detect_proto_rawDesc)))
+	})
+	return file_detect_proto_rawDescData
+}
+
+var file_detect_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_detect_proto_goTypes = []any{
+	(*GetIPDetailsRequest)(nil),   // 0: detect.v1.GetIPDetailsRequest
+	(*IPDetails)(nil),             // 1: detect.v1.IPDetails
+	(*EnqueueRequest)(nil),        // 2: detect.v1.EnqueueRequest
+	(*EnqueueResponse)(nil),       // 3: detect.v1.EnqueueResponse
+	(*CheckRequest)(nil),          // 4: detect.v1.CheckRequest
+	(*CheckResponse)(nil),         // 5: detect.v1.CheckResponse
+	(*timestamppb.Timestamp)(nil), // 6: google.protobuf.Timestamp
+}
+var file_detect_proto_depIdxs = []int32{
+	6, // 0: detect.v1.IPDetails.created_at:type_name -> google.protobuf.Timestamp
+	6, // 1: detect.v1.IPDetails.updated_at:type_name -> google.protobuf.Timestamp
+	1, // 2: detect.v1.CheckResponse.details:type_name -> detect.v1.IPDetails
+	0, // 3: detect.v1.Detect.GetIPDetails:input_type -> detect.v1.GetIPDetailsRequest
+	2, // 4: detect.v1.Detect.Enqueue:input_type -> detect.v1.EnqueueRequest
+	4, // 5: detect.v1.Detect.CheckStream:input_type -> detect.v1.CheckRequest
+	1, // 6: detect.v1.Detect.GetIPDetails:output_type -> detect.v1.IPDetails
+	3, // 7: detect.v1.Detect.Enqueue:output_type -> detect.v1.EnqueueResponse
+	5, // 8: detect.v1.Detect.CheckStream:output_type -> detect.v1.CheckResponse
+	6, // [6:9] is the sub-list for method output_type
+	3, // [3:6] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_detect_proto_init() }
+func file_detect_proto_init() {
+	if File_detect_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_detect_proto_rawDesc), len(file_detect_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_detect_proto_goTypes,
+		DependencyIndexes: file_detect_proto_depIdxs,
+		MessageInfos:      file_detect_proto_msgTypes,
+	}.Build()
+	File_detect_proto = out.File
+	file_detect_proto_goTypes = nil
+	file_detect_proto_depIdxs = nil
+}
@@ -0,0 +1,210 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: detect.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Detect_GetIPDetails_FullMethodName = "/detect.v1.Detect/GetIPDetails"
+	Detect_Enqueue_FullMethodName      = "/detect.v1.Detect/Enqueue"
+	Detect_CheckStream_FullMethodName  = "/detect.v1.Detect/CheckStream"
+)
+
+// DetectClient is the client API for Detect service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Detect looks up IPv4 addresses against the Spamhaus DNSBL.  Calls are authenticated
+// as HTTP requests are: by Basic credentials or a bearer API key or JWT in the
+// "authorization" metadata key, an API key in the "x-api-key" key, or a mapped client
+// certificate when the server uses TLS.
+type DetectClient interface {
+	// GetIPDetails returns the stored result for an address.
+	GetIPDetails(ctx context.Context, in *GetIPDetailsRequest, opts ...grpc.CallOption) (*IPDetails, error)
+	// Enqueue queues addresses to be looked up in the background.
+	Enqueue(ctx context.Context, in *EnqueueRequest, opts ...grpc.CallOption) (*EnqueueResponse, error)
+	// CheckStream looks up each address sent by the client and streams back the result
+	// as soon as it is available.  Results are not guaranteed to arrive in request order.
+	CheckStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[CheckRequest, CheckResponse], error)
+}
+
+type detectClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDetectClient(cc grpc.ClientConnInterface) DetectClient {
+	return &detectClient{cc}
+}
+
+func (c *detectClient) GetIPDetails(ctx context.Context, in *GetIPDetailsRequest, opts ...grpc.CallOption) (*IPDetails, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IPDetails)
+	err := c.cc.Invoke(ctx, Detect_GetIPDetails_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectClient) Enqueue(ctx context.Context, in *EnqueueRequest, opts ...grpc.CallOption) (*EnqueueResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EnqueueResponse)
+	err := c.cc.Invoke(ctx, Detect_Enqueue_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectClient) CheckStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[CheckRequest, CheckResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Detect_ServiceDesc.Streams[0], Detect_CheckStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CheckRequest, CheckResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Detect_CheckStreamClient = grpc.BidiStreamingClient[CheckRequest, CheckResponse]
+
+// DetectServer is the server API for Detect service.
+// All implementations must embed UnimplementedDetectServer
+// for forward compatibility.
+//
+// Detect looks up IPv4 addresses against the Spamhaus DNSBL.  Calls are authenticated
+// as HTTP requests are: by Basic credentials or a bearer API key or JWT in the
+// "authorization" metadata key, an API key in the "x-api-key" key, or a mapped client
+// certificate when the server uses TLS.
+type DetectServer interface {
+	// GetIPDetails returns the stored result for an address.
+	GetIPDetails(context.Context, *GetIPDetailsRequest) (*IPDetails, error)
+	// Enqueue queues addresses to be looked up in the background.
+	Enqueue(context.Context, *EnqueueRequest) (*EnqueueResponse, error)
+	// CheckStream looks up each address sent by the client and streams back the result
+	// as soon as it is available.  Results are not guaranteed to arrive in request order.
+	CheckStream(grpc.BidiStreamingServer[CheckRequest, CheckResponse]) error
+	mustEmbedUnimplementedDetectServer()
+}
+
+// UnimplementedDetectServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedDetectServer struct{}
+
+func (UnimplementedDetectServer) GetIPDetails(context.Context, *GetIPDetailsRequest) (*IPDetails, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetIPDetails not implemented")
+}
+func (UnimplementedDetectServer) Enqueue(context.Context, *EnqueueRequest) (*EnqueueResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Enqueue not implemented")
+}
+func (UnimplementedDetectServer) CheckStream(grpc.BidiStreamingServer[CheckRequest, CheckResponse]) error {
+	return status.Error(codes.Unimplemented, "method CheckStream not implemented")
+}
+func (UnimplementedDetectServer) mustEmbedUnimplementedDetectServer() {}
+func (UnimplementedDetectServer) testEmbeddedByValue()                {}
+
+// UnsafeDetectServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DetectServer will
+// result in compilation errors.
+type UnsafeDetectServer interface {
+	mustEmbedUnimplementedDetectServer()
+}
+
+func RegisterDetectServer(s grpc.ServiceRegistrar, srv DetectServer) {
+	// If the following call panics, it indicates UnimplementedDetectServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Detect_ServiceDesc, srv)
+}
+
+func _Detect_GetIPDetails_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetIPDetailsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectServer).GetIPDetails(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Detect_GetIPDetails_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectServer).GetIPDetails(ctx, req.(*GetIPDetailsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Detect_Enqueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnqueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectServer).Enqueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Detect_Enqueue_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectServer).Enqueue(ctx, req.(*EnqueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Detect_CheckStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DetectServer).CheckStream(&grpc.GenericServerStream[CheckRequest, CheckResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Detect_CheckStreamServer = grpc.BidiStreamingServer[CheckRequest, CheckResponse]
+
+// Detect_ServiceDesc is the grpc.ServiceDesc for Detect service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Detect_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "detect.v1.Detect",
+	HandlerType: (*DetectServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetIPDetails",
+			Handler:    _Detect_GetIPDetails_Handler,
+		},
+		{
+			MethodName: "Enqueue",
+			Handler:    _Detect_Enqueue_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "CheckStream",
+			Handler:       _Detect_CheckStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "detect.proto",
+}
@@ -0,0 +1,262 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/jdharms/threat-detect/graph"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/grpcapi/pb"
+)
+
+// Number of lookups a single CheckStream call may have in flight at once.
+const streamConcurrency = 8
+
+var statusByCode = map[string]codes.Code{
+	graph.CodeNotFound:        codes.NotFound,
+	graph.CodeInvalidInput:    codes.InvalidArgument,
+	graph.CodeUnauthenticated: codes.Unauthenticated,
+	graph.CodeForbidden:       codes.PermissionDenied,
+	graph.CodeRateLimited:     codes.ResourceExhausted,
+}
+
+type Server struct {
+	pb.UnimplementedDetectServer
+	resolver *graph.Resolver
+}
+
+// NewServer returns a gRPC server exposing the resolver's operations, authenticating
+// every call with the same authenticators used for HTTP.  Calls are served over TLS
+// when tlsConfig isn't nil.
+func NewServer(resolver *graph.Resolver, tlsConfig *tls.Config, authenticators ...auth.Authenticator) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(unaryAuth(authenticators)),
+		grpc.StreamInterceptor(streamAuth(authenticators)),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	srv := grpc.NewServer(opts...)
+	pb.RegisterDetectServer(srv, &Server{resolver: resolver})
+	return srv
+}
+
+func (s *Server) GetIPDetails(ctx context.Context, req *pb.GetIPDetailsRequest) (*pb.IPDetails, error) {
+	if err := s.authorize(ctx, auth.RoleReader); err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	details, err := s.resolver.Query().GetIPDetails(ctx, req.GetIp())
+	if err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	return detailsToProto(*details), nil
+}
+
+func (s *Server) Enqueue(ctx context.Context, req *pb.EnqueueRequest) (*pb.EnqueueResponse, error) {
+	if err := s.authorize(ctx, auth.RoleSubmitter); err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	payload, err := s.resolver.Mutation().Enqueue(ctx, req.GetIps())
+	if err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	return &pb.EnqueueResponse{
+		JobId:     payload.JobID,
+		QueuedIps: payload.QueuedIps,
+	}, nil
+}
+
+// CheckStream stores the results of its lookups, so it requires the submitter role.
+func (s *Server) CheckStream(stream pb.Detect_CheckStreamServer) error {
+	if err := s.authorize(stream.Context(), auth.RoleSubmitter); err != nil {
+		return toStatus(err).Err()
+	}
+
+	var sendMu sync.Mutex
+	var sendErr error
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, streamConcurrency)
+
+	send := func(res *pb.CheckResponse) {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		if sendErr == nil {
+			sendErr = stream.Send(res)
+		}
+	}
+
+	var recvErr error
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				recvErr = err
+			}
+			break
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-stream.Context().Done():
+		}
+		if stream.Context().Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res := &pb.CheckResponse{Ip: ip}
+			var details model.IPDetails
+			err := s.resolver.AllowIPs(stream.Context(), 1)
+			if err == nil {
+				details, err = s.resolver.Check(stream.Context(), ip)
+			}
+			if err != nil {
+				st := toStatus(err)
+				res.ErrorCode = graph.ErrorCode(err)
+				res.ErrorMessage = st.Message()
+			} else {
+				res.Details = detailsToProto(details)
+			}
+			send(res)
+		}(req.GetIp())
+	}
+
+	wg.Wait()
+
+	if recvErr != nil {
+		return recvErr
+	}
+	return sendErr
+}
+
+// authorize checks the principal in ctx holds role and counts the call against its
+// query rate limit.
+func (s *Server) authorize(ctx context.Context, role string) error {
+	if err := auth.RequireRole(ctx, role); err != nil {
+		return err
+	}
+	return s.resolver.AllowQuery(ctx)
+}
+
+// toStatus classifies err the same way the GraphQL API does, hiding the text of
+// internal errors from the caller.  Rate limited calls carry a RetryInfo detail.
+func toStatus(err error) *status.Status {
+	code, ok := statusByCode[graph.ErrorCode(err)]
+	if !ok {
+		log.Printf("internal error: %s", err.Error())
+		return status.New(codes.Internal, "internal error")
+	}
+
+	st := status.New(code, err.Error())
+	if retryAfter := graph.RetryAfter(err); retryAfter > 0 {
+		delay := durationpb.New(time.Duration(retryAfter) * time.Second)
+		if withInfo, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: delay}); err == nil {
+			return withInfo
+		}
+	}
+	return st
+}
+
+func detailsToProto(d model.IPDetails) *pb.IPDetails {
+	return &pb.IPDetails{
+		Uuid:         d.UUID,
+		CreatedAt:    timestamppb.New(d.CreatedAt),
+		UpdatedAt:    timestamppb.New(d.UpdatedAt),
+		ResponseCode: d.ResponseCode,
+		IpAddress:    d.IPAddress,
+	}
+}
+
+// authenticate presents the call's metadata to the authenticators as if it were the
+// headers of an HTTP request.
+func authenticate(ctx context.Context, authenticators []auth.Authenticator) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := &http.Request{Header: http.Header{}}
+	for key, values := range md {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	clientIP := ""
+	if peer, ok := peer.FromContext(ctx); ok {
+		clientIP = peerIP(peer.Addr)
+		r.RemoteAddr = peer.Addr.String()
+		if info, ok := peer.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+
+	p, err := auth.Authenticate(r, authenticators...)
+	var throttled auth.ErrThrottled
+	if errors.As(err, &throttled) {
+		return nil, status.Error(codes.ResourceExhausted, throttled.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authorization failed")
+	}
+	ctx = auth.ContextWithPrincipal(ctx, p)
+	return auth.ContextWithClientIP(ctx, clientIP), nil
+}
+
+func peerIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	return addr.String()
+}
+
+func unaryAuth(authenticators []auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, authenticators)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authenticatedStream swaps in a context carrying the authorized principal.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func streamAuth(authenticators []auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), authenticators)
+		if err != nil {
+			return err
+		}
+		return handler(srv, authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
@@ -0,0 +1,180 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/jdharms/threat-detect/graph"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/db"
+	"github.com/jdharms/threat-detect/internal/grpcapi/pb"
+	"github.com/jdharms/threat-detect/internal/jobs"
+)
+
+type mockStore struct {
+	mu      sync.Mutex
+	details map[string]model.IPDetails
+}
+
+func (ms *mockStore) AddIPDetails(ctx context.Context, tenant string, d model.IPDetails) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.details[d.IPAddress] = d
+	return nil
+}
+
+func (ms *mockStore) GetIPDetails(ctx context.Context, tenant string, ip string) (model.IPDetails, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	d, ok := ms.details[ip]
+	if !ok {
+		return d, db.ErrNotFound{}
+	}
+	return d, nil
+}
+
+type mockDNSBL struct{}
+
+func (mockDNSBL) Query(ctx context.Context, ip string) (string, error) {
+	return "127.0.0.2", nil
+}
+
+func dialTestServer(t *testing.T) (pb.DetectClient, *mockStore) {
+	store := &mockStore{details: map[string]model.IPDetails{}}
+	srv := NewServer(&graph.Resolver{
+		Adder:  store,
+		Getter: store,
+		DNSBL:  mockDNSBL{},
+		Jobs:   jobs.NewTracker(),
+	}, nil, auth.BasicAuthenticator(
+		auth.NewMapValidator(map[string]string{"user": "pass", "reader": "pass"}),
+		func(username string) []string {
+			if username == "reader" {
+				return []string{auth.RoleReader}
+			}
+			return auth.AllRoles
+		},
+	))
+
+	listener := bufconn.Listen(1 << 20)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("error dialing test server: %s", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewDetectClient(conn), store
+}
+
+func withCredentials(user, pass string) context.Context {
+	creds := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic "+creds)
+}
+
+func TestGetIPDetails(t *testing.T) {
+	client, store := dialTestServer(t)
+	store.details["1.2.3.4"] = model.IPDetails{IPAddress: "1.2.3.4", ResponseCode: "127.0.0.2"}
+
+	testCases := []struct {
+		name         string
+		ctx          context.Context
+		ip           string
+		expectedCode codes.Code
+	}{
+		{"found", withCredentials("user", "pass"), "1.2.3.4", codes.OK},
+		{"not found", withCredentials("user", "pass"), "4.3.2.1", codes.NotFound},
+		{"invalid ip", withCredentials("user", "pass"), "foobar", codes.InvalidArgument},
+		{"bad credentials", withCredentials("user", "wrong"), "1.2.3.4", codes.Unauthenticated},
+		{"no credentials", context.Background(), "1.2.3.4", codes.Unauthenticated},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := client.GetIPDetails(test.ctx, &pb.GetIPDetailsRequest{Ip: test.ip})
+			if status.Code(err) != test.expectedCode {
+				t.Errorf("expected code %s but got %s", test.expectedCode, status.Code(err))
+			}
+			if err == nil && res.GetResponseCode() != "127.0.0.2" {
+				t.Errorf("unexpected details returned: %+v", res)
+			}
+		})
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	client, _ := dialTestServer(t)
+
+	res, err := client.Enqueue(withCredentials("user", "pass"), &pb.EnqueueRequest{Ips: []string{"1.1.1.1", "2.2.2.2"}})
+	if err != nil {
+		t.Fatalf("unexpected error from Enqueue: %s", err.Error())
+	}
+	if res.GetJobId() == "" || len(res.GetQueuedIps()) != 2 {
+		t.Errorf("unexpected enqueue response: %+v", res)
+	}
+
+	_, err = client.Enqueue(withCredentials("reader", "pass"), &pb.EnqueueRequest{Ips: []string{"1.1.1.1"}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for a reader, got %s", status.Code(err))
+	}
+}
+
+func TestCheckStream(t *testing.T) {
+	client, _ := dialTestServer(t)
+
+	stream, err := client.CheckStream(withCredentials("user", "pass"))
+	if err != nil {
+		t.Fatalf("unexpected error opening stream: %s", err.Error())
+	}
+
+	ips := []string{"1.1.1.1", "2.2.2.2", "foobar"}
+	for _, ip := range ips {
+		if err := stream.Send(&pb.CheckRequest{Ip: ip}); err != nil {
+			t.Fatalf("unexpected error sending: %s", err.Error())
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("unexpected error closing stream: %s", err.Error())
+	}
+
+	results := map[string]*pb.CheckResponse{}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error receiving: %s", err.Error())
+		}
+		results[res.GetIp()] = res
+	}
+
+	if len(results) != len(ips) {
+		t.Fatalf("expected %d results but got %d", len(ips), len(results))
+	}
+	if results["1.1.1.1"].GetDetails().GetResponseCode() != "127.0.0.2" {
+		t.Errorf("unexpected result for 1.1.1.1: %+v", results["1.1.1.1"])
+	}
+	if results["foobar"].GetErrorCode() != graph.CodeInvalidInput {
+		t.Errorf("expected %s for invalid ip, got %+v", graph.CodeInvalidInput, results["foobar"])
+	}
+}
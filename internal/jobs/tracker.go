@@ -0,0 +1,126 @@
+package jobs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jdharms/threat-detect/graph/model"
+)
+
+// By default finished jobs are kept around this long so callers can check on them.
+const DefaultRetention = 24 * time.Hour
+
+// Tracker keeps the progress of enqueued lookups in memory.  Job status does not survive
+// a restart, but the results of the lookups themselves are persisted as usual.
+type Tracker struct {
+	mu        sync.Mutex
+	jobs      map[string]*trackedJob
+	now       func() time.Time
+	retention time.Duration
+}
+
+// trackedJob is a job along with the tenant that started it.
+type trackedJob struct {
+	*model.Job
+	tenant string
+}
+
+func NewTracker() *Tracker {
+	return NewTrackerWithRetention(DefaultRetention)
+}
+
+// NewTrackerWithRetention returns a Tracker that keeps finished jobs for retention.
+func NewTrackerWithRetention(retention time.Duration) *Tracker {
+	return &Tracker{
+		jobs:      map[string]*trackedJob{},
+		now:       time.Now,
+		retention: retention,
+	}
+}
+
+// Start records a new job for tenant covering ips and returns its ID.
+func (t *Tracker) Start(tenant string, ips []string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune()
+
+	job := &model.Job{
+		ID:        uuid.New().String(),
+		CreatedAt: t.now(),
+		Status:    model.JobStatusPending,
+		Ips:       append([]string{}, ips...),
+	}
+	if len(ips) == 0 {
+		t.finish(job)
+	}
+	t.jobs[job.ID] = &trackedJob{Job: job, tenant: tenant}
+
+	return job.ID
+}
+
+// Finish records the outcome of looking up one of a job's addresses.
+func (t *Tracker) Finish(id string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	job, ok := t.jobs[id]
+	if !ok || job.Status == model.JobStatusDone {
+		return
+	}
+
+	if err != nil {
+		job.Failed++
+	} else {
+		job.Completed++
+	}
+
+	if job.Completed+job.Failed >= len(job.Ips) {
+		t.finish(job.Job)
+	}
+}
+
+// Get returns one of tenant's jobs.  Other tenants' jobs are reported as not found.
+func (t *Tracker) Get(tenant string, id string) (model.Job, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	job, ok := t.jobs[id]
+	if !ok || job.tenant != tenant {
+		return model.Job{}, newErrNotFound(id)
+	}
+
+	res := *job.Job
+	res.Ips = append([]string{}, job.Ips...)
+	return res, nil
+}
+
+func (t *Tracker) finish(job *model.Job) {
+	finishedAt := t.now()
+	job.FinishedAt = &finishedAt
+	job.Status = model.JobStatusDone
+}
+
+// prune must be called with the lock held.
+func (t *Tracker) prune() {
+	cutoff := t.now().Add(-t.retention)
+	for id, job := range t.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(t.jobs, id)
+		}
+	}
+}
+
+type ErrNotFound struct {
+	id string
+}
+
+func (e ErrNotFound) Error() string {
+	return fmt.Sprintf("job %s not found", e.id)
+}
+
+func newErrNotFound(id string) ErrNotFound {
+	return ErrNotFound{id: id}
+}
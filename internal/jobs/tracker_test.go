@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph/model"
+)
+
+func TestTrackerLifecycle(t *testing.T) {
+	sut := NewTracker()
+
+	id := sut.Start("red", []string{"1.1.1.1", "2.2.2.2"})
+
+	job, err := sut.Get("red", id)
+	if err != nil {
+		t.Fatalf("unexpected error getting job: %s", err.Error())
+	}
+	if job.Status != model.JobStatusPending || len(job.Ips) != 2 {
+		t.Errorf("unexpected new job: %+v", job)
+	}
+
+	sut.Finish(id, nil)
+	job, _ = sut.Get("red", id)
+	if job.Status != model.JobStatusPending || job.Completed != 1 {
+		t.Errorf("expected job to be pending with one completed lookup: %+v", job)
+	}
+
+	sut.Finish(id, fmt.Errorf("some error"))
+	job, _ = sut.Get("red", id)
+	if job.Status != model.JobStatusDone || job.Failed != 1 || job.FinishedAt == nil {
+		t.Errorf("expected job to be done with one failed lookup: %+v", job)
+	}
+}
+
+func TestTrackerNotFound(t *testing.T) {
+	sut := NewTracker()
+
+	_, err := sut.Get("red", "missing")
+	if _, ok := err.(ErrNotFound); !ok {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestTrackerHidesOtherTenantsJobs(t *testing.T) {
+	sut := NewTracker()
+
+	id := sut.Start("red", []string{"1.1.1.1"})
+	if _, err := sut.Get("blue", id); err == nil {
+		t.Error("expected another tenant's job not to be found")
+	}
+	if _, err := sut.Get("red", id); err != nil {
+		t.Errorf("unexpected error getting own job: %s", err.Error())
+	}
+}
+
+func TestTrackerPrunesFinishedJobs(t *testing.T) {
+	now := time.Now()
+	sut := NewTracker()
+	sut.now = func() time.Time { return now }
+
+	old := sut.Start("red", nil)
+	pending := sut.Start("red", []string{"1.1.1.1"})
+
+	now = now.Add(DefaultRetention + time.Minute)
+	sut.Start("red", nil)
+
+	if _, err := sut.Get("red", old); err == nil {
+		t.Error("expected finished job to be pruned")
+	}
+	if _, err := sut.Get("red", pending); err != nil {
+		t.Error("pending job should not be pruned")
+	}
+}
@@ -0,0 +1,281 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jdharms/threat-detect/internal/auth"
+)
+
+// Policy is the set of limits applied to a principal.  In the default policy a zero
+// limit means unlimited.  In user and role policies a zero limit falls back to the
+// default policy and a negative limit means unlimited.
+type Policy struct {
+	QueriesPerMinute int `json:"queries_per_minute"`
+	IPsPerHour       int `json:"ips_per_hour"`
+	IPsPerDay        int `json:"ips_per_day"`
+}
+
+// Config assigns policies to principals.  A principal's user policy takes precedence
+// over its role policies; if it has several roles, the most generous limit among them
+// applies.  Names are only unique within a tenant, so Users holds the policies of the
+// default tenant's principals and Tenants those of other tenants' by tenant and name.
+type Config struct {
+	Default Policy                       `json:"default"`
+	Roles   map[string]Policy            `json:"roles"`
+	Users   map[string]Policy            `json:"users"`
+	Tenants map[string]map[string]Policy `json:"tenants"`
+}
+
+// DefaultConfig is used when no configuration file is given.
+var DefaultConfig = Config{
+	Default: Policy{QueriesPerMinute: 300, IPsPerHour: 1000, IPsPerDay: 10000},
+}
+
+func LoadConfig(path string) (Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading rate limits: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		return Config{}, fmt.Errorf("error parsing rate limits: %w", err)
+	}
+	return cfg, nil
+}
+
+// ErrLimited is returned when a principal has used up one of its limits.
+type ErrLimited struct {
+	// Limit describes the limit that was reached, e.g. "queries per minute".
+	Limit string
+
+	// RetryAfter is how long until the request would be allowed, or zero if it never
+	// will be because it is larger than the limit itself.
+	RetryAfter time.Duration
+}
+
+func (e ErrLimited) Error() string {
+	if e.RetryAfter == 0 {
+		return fmt.Sprintf("request exceeds the limit of %s", e.Limit)
+	}
+	return fmt.Sprintf("rate limit of %s exceeded, retry after %s", e.Limit, e.RetryAfter.Round(time.Second))
+}
+
+// Limits tracked for each principal.
+const (
+	limitQueries = iota
+	limitIPsHour
+	limitIPsDay
+)
+
+var limitNames = map[int]string{
+	limitQueries: "queries per minute",
+	limitIPsHour: "IPs per hour",
+	limitIPsDay:  "IPs per day",
+}
+
+var limitWindows = map[int]time.Duration{
+	limitQueries: time.Minute,
+	limitIPsHour: time.Hour,
+	limitIPsDay:  24 * time.Hour,
+}
+
+// Principals' names are only unique within a tenant, so buckets are keyed by both.
+type bucketKey struct {
+	tenant    string
+	principal string
+	limit     int
+}
+
+// bucket is a token bucket holding up to capacity tokens that refills completely over
+// the limit's window.
+type bucket struct {
+	tokens   float64
+	capacity int
+	last     time.Time
+}
+
+// sweepInterval is how often buckets that have refilled are dropped.  A full bucket
+// is the same as none at all, so this only bounds the memory used by principals that
+// have stopped making requests.
+const sweepInterval = 10 * time.Minute
+
+// Limiter applies token bucket limits to the principal in a request's context.
+// Requests without a principal aren't limited.
+type Limiter struct {
+	cfg Config
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+func NewLimiter(cfg Config) *Limiter {
+	return &Limiter{
+		cfg:     cfg,
+		now:     time.Now,
+		buckets: map[bucketKey]*bucket{},
+	}
+}
+
+// AllowQuery counts one query against the principal's limits.
+func (l *Limiter) AllowQuery(ctx context.Context) error {
+	return l.take(ctx, 1, limitQueries)
+}
+
+// AllowIPs counts n enqueued addresses against the principal's limits.  Either both the
+// hourly and daily limits allow them and they are counted against both, or neither.
+func (l *Limiter) AllowIPs(ctx context.Context, n int) error {
+	return l.take(ctx, n, limitIPsHour, limitIPsDay)
+}
+
+func (l *Limiter) take(ctx context.Context, n int, limits ...int) error {
+	p, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	policy := l.policy(p)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	var taken []*bucket
+	for _, limit := range limits {
+		capacity := policy.limit(limit)
+		if capacity <= 0 {
+			continue
+		}
+		if n > capacity {
+			return ErrLimited{Limit: fmt.Sprintf("%d %s", capacity, limitNames[limit])}
+		}
+
+		b := l.bucket(bucketKey{tenant: p.Tenant, principal: p.Name, limit: limit}, capacity, now)
+		if b.tokens < float64(n) {
+			perToken := limitWindows[limit] / time.Duration(capacity)
+			wait := time.Duration(math.Ceil((float64(n) - b.tokens) * float64(perToken)))
+			return ErrLimited{Limit: fmt.Sprintf("%d %s", capacity, limitNames[limit]), RetryAfter: wait}
+		}
+		taken = append(taken, b)
+	}
+
+	for _, b := range taken {
+		b.tokens -= float64(n)
+	}
+	return nil
+}
+
+// bucket returns the refilled bucket for key, creating a full one if needed.
+func (l *Limiter) bucket(key bucketKey, capacity int, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(capacity), capacity: capacity, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.refill(now, limitWindows[key.limit])
+	return b
+}
+
+func (b *bucket) refill(now time.Time, window time.Duration) {
+	elapsed := now.Sub(b.last)
+	b.tokens = math.Min(float64(b.capacity), b.tokens+float64(b.capacity)*elapsed.Seconds()/window.Seconds())
+	b.last = now
+}
+
+// sweep drops the buckets that have refilled completely by now.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.refill(now, limitWindows[key.limit]); b.tokens >= float64(b.capacity) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// policy resolves the limits that apply to p.  Non-positive limits in the result mean
+// unlimited.
+func (l *Limiter) policy(p auth.Principal) Policy {
+	if user, ok := l.userPolicy(p); ok {
+		return l.cfg.Default.override(user)
+	}
+
+	var roles *Policy
+	for _, role := range p.Roles {
+		rp, ok := l.cfg.Roles[role]
+		if !ok {
+			continue
+		}
+		rp = l.cfg.Default.override(rp)
+		if roles == nil {
+			roles = &rp
+			continue
+		}
+		roles.QueriesPerMinute = generous(roles.QueriesPerMinute, rp.QueriesPerMinute)
+		roles.IPsPerHour = generous(roles.IPsPerHour, rp.IPsPerHour)
+		roles.IPsPerDay = generous(roles.IPsPerDay, rp.IPsPerDay)
+	}
+	if roles != nil {
+		return *roles
+	}
+
+	return l.cfg.Default
+}
+
+// userPolicy returns the policy configured for p by its tenant and name.
+func (l *Limiter) userPolicy(p auth.Principal) (Policy, bool) {
+	if p.Tenant == "" || p.Tenant == auth.DefaultTenant {
+		user, ok := l.cfg.Users[p.Name]
+		return user, ok
+	}
+	user, ok := l.cfg.Tenants[p.Tenant][p.Name]
+	return user, ok
+}
+
+// override returns p with the non-zero limits of o replacing its own.
+func (p Policy) override(o Policy) Policy {
+	if o.QueriesPerMinute != 0 {
+		p.QueriesPerMinute = o.QueriesPerMinute
+	}
+	if o.IPsPerHour != 0 {
+		p.IPsPerHour = o.IPsPerHour
+	}
+	if o.IPsPerDay != 0 {
+		p.IPsPerDay = o.IPsPerDay
+	}
+	return p
+}
+
+func (p Policy) limit(limit int) int {
+	switch limit {
+	case limitQueries:
+		return p.QueriesPerMinute
+	case limitIPsHour:
+		return p.IPsPerHour
+	default:
+		return p.IPsPerDay
+	}
+}
+
+// generous returns the more permissive of two resolved limits.
+func generous(a, b int) int {
+	if a <= 0 || b <= 0 {
+		return -1
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,165 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jdharms/threat-detect/internal/auth"
+)
+
+func principalCtx(name string, roles ...string) context.Context {
+	return auth.ContextWithPrincipal(context.Background(), auth.Principal{Name: name, Roles: roles})
+}
+
+func TestAllowQuery(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(Config{Default: Policy{QueriesPerMinute: 2}})
+	l.now = func() time.Time { return now }
+
+	alice := principalCtx("alice")
+	for i := 0; i < 2; i++ {
+		if err := l.AllowQuery(alice); err != nil {
+			t.Fatalf("unexpected error for query %d: %s", i, err.Error())
+		}
+	}
+
+	err := l.AllowQuery(alice)
+	limited, ok := err.(ErrLimited)
+	if !ok {
+		t.Fatalf("expected ErrLimited, got %v", err)
+	}
+	if limited.RetryAfter != 30*time.Second {
+		t.Errorf("expected to retry after 30s, got %s", limited.RetryAfter)
+	}
+
+	if err := l.AllowQuery(principalCtx("bob")); err != nil {
+		t.Errorf("expected other principals to have their own limit, got %v", err)
+	}
+	if err := l.AllowQuery(context.Background()); err != nil {
+		t.Errorf("expected requests without a principal not to be limited, got %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if err := l.AllowQuery(alice); err != nil {
+		t.Errorf("expected bucket to refill, got %v", err)
+	}
+}
+
+func TestBucketsPerTenant(t *testing.T) {
+	l := NewLimiter(Config{Default: Policy{QueriesPerMinute: 1}})
+	red := auth.ContextWithPrincipal(context.Background(), auth.Principal{Name: "alice", Tenant: "red"})
+	blue := auth.ContextWithPrincipal(context.Background(), auth.Principal{Name: "alice", Tenant: "blue"})
+
+	if err := l.AllowQuery(red); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := l.AllowQuery(blue); err != nil {
+		t.Errorf("expected a principal with the same name in another tenant to have its own limit, got %v", err)
+	}
+	if err := l.AllowQuery(red); err == nil {
+		t.Error("expected the limit to be reached")
+	}
+}
+
+func TestSweepDropsFullBuckets(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(Config{Default: Policy{QueriesPerMinute: 10, IPsPerHour: 100, IPsPerDay: 1000}})
+	l.now = func() time.Time { return now }
+
+	if err := l.AllowQuery(principalCtx("alice")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := l.AllowIPs(principalCtx("bob"), 50); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(l.buckets) != 3 {
+		t.Fatalf("expected 3 buckets, got %d", len(l.buckets))
+	}
+
+	// alice's query bucket has refilled, but bob's hourly and daily ones haven't
+	now = now.Add(sweepInterval)
+	if err := l.AllowQuery(principalCtx("carol")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, ok := l.buckets[bucketKey{principal: "alice", limit: limitQueries}]; ok {
+		t.Error("expected alice's full bucket to be dropped")
+	}
+	if len(l.buckets) != 3 {
+		t.Errorf("expected bob's 2 buckets and carol's to be kept, got %d", len(l.buckets))
+	}
+
+	// a dropped bucket starts out full again
+	for i := 0; i < 10; i++ {
+		if err := l.AllowQuery(principalCtx("alice")); err != nil {
+			t.Fatalf("unexpected error for query %d: %s", i, err.Error())
+		}
+	}
+}
+
+func TestAllowIPs(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(Config{Default: Policy{IPsPerHour: 10, IPsPerDay: 15}})
+	l.now = func() time.Time { return now }
+	ctx := principalCtx("alice")
+
+	if err := l.AllowIPs(ctx, 11); err == nil || err.(ErrLimited).RetryAfter != 0 {
+		t.Errorf("expected a request larger than the limit to be refused outright, got %v", err)
+	}
+
+	if err := l.AllowIPs(ctx, 10); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := l.AllowIPs(ctx, 1); err == nil || err.(ErrLimited).Limit != "10 IPs per hour" {
+		t.Errorf("expected the hourly limit to be reached, got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := l.AllowIPs(ctx, 6); err == nil || err.(ErrLimited).Limit != "15 IPs per day" {
+		t.Errorf("expected the daily limit to be reached, got %v", err)
+	}
+
+	// the refused request must not have been counted against the hourly limit
+	if err := l.AllowIPs(ctx, 5); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPolicyResolution(t *testing.T) {
+	l := NewLimiter(Config{
+		Default: Policy{QueriesPerMinute: 10, IPsPerHour: 100, IPsPerDay: 1000},
+		Roles: map[string]Policy{
+			auth.RoleSubmitter: {IPsPerHour: 500},
+			auth.RoleAdmin:     {QueriesPerMinute: -1, IPsPerHour: 200},
+		},
+		Users: map[string]Policy{
+			"batch": {IPsPerDay: 50000},
+		},
+		Tenants: map[string]map[string]Policy{
+			"red": {"importer": {IPsPerHour: 5000}},
+		},
+	})
+
+	testCases := []struct {
+		name     string
+		p        auth.Principal
+		expected Policy
+	}{
+		{"default", auth.Principal{Name: "a", Roles: []string{auth.RoleReader}}, Policy{10, 100, 1000}},
+		{"role", auth.Principal{Name: "b", Roles: []string{auth.RoleSubmitter}}, Policy{10, 500, 1000}},
+		{"most generous role", auth.Principal{Name: "c", Roles: []string{auth.RoleSubmitter, auth.RoleAdmin}}, Policy{-1, 500, 1000}},
+		{"user", auth.Principal{Name: "batch", Roles: []string{auth.RoleAdmin}}, Policy{10, 100, 50000}},
+		{"default tenant user", auth.Principal{Name: "batch", Tenant: auth.DefaultTenant}, Policy{10, 100, 50000}},
+		{"same name in another tenant", auth.Principal{Name: "batch", Tenant: "red"}, Policy{10, 100, 1000}},
+		{"tenant user", auth.Principal{Name: "importer", Tenant: "red"}, Policy{10, 5000, 1000}},
+		{"tenant user in another tenant", auth.Principal{Name: "importer", Tenant: "blue"}, Policy{10, 100, 1000}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if policy := l.policy(test.p); policy != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, policy)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package rest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jdharms/threat-detect/graph"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// Largest enqueue request body we'll accept.
+const maxBodyBytes = 1 << 20
+
+type enqueueRequest struct {
+	IPs []string `json:"ips"`
+}
+
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var statusByCode = map[string]int{
+	graph.CodeNotFound:        http.StatusNotFound,
+	graph.CodeInvalidInput:    http.StatusBadRequest,
+	graph.CodeUnauthenticated: http.StatusUnauthorized,
+	graph.CodeForbidden:       http.StatusForbidden,
+	graph.CodeRateLimited:     http.StatusTooManyRequests,
+}
+
+// NewHandler exposes the resolver's operations as a small REST/JSON API for clients
+// that can't speak GraphQL.  The handler expects to be mounted at the root of the
+// server, as it serves paths under /v1/.
+func NewHandler(resolver *graph.Resolver) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/ip/", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) || !requireRole(w, r, auth.RoleReader) || !allowQuery(w, r, resolver) {
+			return
+		}
+
+		ip := strings.TrimPrefix(r.URL.Path, "/v1/ip/")
+		details, err := resolver.Query().GetIPDetails(r.Context(), ip)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, details)
+	})
+
+	mux.HandleFunc("/v1/enqueue", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) || !requireRole(w, r, auth.RoleSubmitter) || !allowQuery(w, r, resolver) {
+			return
+		}
+
+		var req enqueueRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorBody{Error: errorDetail{
+				Code:    graph.CodeInvalidInput,
+				Message: fmt.Sprintf("request body must be a JSON object with an \"ips\" array: %s", err.Error()),
+			}})
+			return
+		}
+
+		payload, err := resolver.Mutation().Enqueue(r.Context(), req.IPs)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, payload)
+	})
+
+	mux.HandleFunc("/v1/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) || !requireRole(w, r, auth.RoleReader) || !allowQuery(w, r, resolver) {
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/v1/jobs/")
+		job, err := resolver.Query().Job(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, job)
+	})
+
+	mux.HandleFunc("/v1/audit", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) || !requireRole(w, r, auth.RoleAdmin) || !allowQuery(w, r, resolver) {
+			return
+		}
+
+		filter, err := auditFilter(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		err = resolver.Audit.EachAuditEntry(r.Context(), auth.TenantFromContext(r.Context()), filter, func(e *model.AuditEntry) error {
+			return enc.Encode(e)
+		})
+		if err != nil {
+			// the status has already been sent, so all we can do is cut the response short
+			log.Printf("error exporting audit log: %s", err.Error())
+		}
+	})
+
+	mux.HandleFunc("/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	})
+
+	return mux
+}
+
+// auditFilter reads an audit log filter from the request's query parameters.
+func auditFilter(r *http.Request) (model.AuditLogFilter, error) {
+	var filter model.AuditLogFilter
+	q := r.URL.Query()
+
+	if v := q.Get("principal"); v != "" {
+		filter.Principal = &v
+	}
+	if v := q.Get("operation"); v != "" {
+		op := model.AuditOperation(strings.ToUpper(v))
+		if !op.IsValid() {
+			return filter, graph.InputError(fmt.Sprintf("unknown operation %q", v))
+		}
+		filter.Operation = &op
+	}
+	if v := q.Get("ip"); v != "" {
+		filter.IP = &v
+	}
+	for name, dest := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, graph.InputError(fmt.Sprintf("%s must be an RFC 3339 time", name))
+		}
+		*dest = &t
+	}
+
+	return filter, nil
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeJSON(w, http.StatusMethodNotAllowed, errorBody{Error: errorDetail{
+		Code:    graph.CodeInvalidInput,
+		Message: fmt.Sprintf("method %s not allowed", r.Method),
+	}})
+	return false
+}
+
+func requireRole(w http.ResponseWriter, r *http.Request, role string) bool {
+	if err := auth.RequireRole(r.Context(), role); err != nil {
+		writeError(w, err)
+		return false
+	}
+	return true
+}
+
+// allowQuery counts the request against the principal's query rate limit.
+func allowQuery(w http.ResponseWriter, r *http.Request, resolver *graph.Resolver) bool {
+	if err := resolver.AllowQuery(r.Context()); err != nil {
+		writeError(w, err)
+		return false
+	}
+	return true
+}
+
+// writeError classifies err the same way the GraphQL API does, hiding the text of
+// internal errors from the caller.
+func writeError(w http.ResponseWriter, err error) {
+	code := graph.ErrorCode(err)
+	status, ok := statusByCode[code]
+	message := err.Error()
+	if !ok {
+		log.Printf("internal error: %s", err.Error())
+		status = http.StatusInternalServerError
+		message = "internal error"
+	}
+
+	if retryAfter := graph.RetryAfter(err); retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+
+	writeJSON(w, status, errorBody{Error: errorDetail{Code: code, Message: message}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("error writing response: %s", err.Error())
+	}
+}
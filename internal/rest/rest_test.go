@@ -0,0 +1,254 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jdharms/threat-detect/graph"
+	"github.com/jdharms/threat-detect/graph/model"
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/db"
+	"github.com/jdharms/threat-detect/internal/jobs"
+	"github.com/jdharms/threat-detect/internal/ratelimit"
+)
+
+type mockStore struct {
+	mu      sync.Mutex
+	details map[string]model.IPDetails
+	wg      sync.WaitGroup
+}
+
+func (ms *mockStore) AddIPDetails(ctx context.Context, tenant string, d model.IPDetails) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.details[d.IPAddress] = d
+	ms.wg.Done()
+	return nil
+}
+
+func (ms *mockStore) GetIPDetails(ctx context.Context, tenant string, ip string) (model.IPDetails, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	d, ok := ms.details[ip]
+	if !ok {
+		return d, db.ErrNotFound{}
+	}
+	return d, nil
+}
+
+type mockDNSBL struct{}
+
+func (mockDNSBL) Query(ctx context.Context, ip string) (string, error) {
+	return "127.0.0.2", nil
+}
+
+func newTestHandler() (http.Handler, *mockStore) {
+	store := &mockStore{details: map[string]model.IPDetails{}}
+	return NewHandler(&graph.Resolver{
+		Adder:  store,
+		Getter: store,
+		DNSBL:  mockDNSBL{},
+		Jobs:   jobs.NewTracker(),
+	}), store
+}
+
+func serve(h http.Handler, method, path string, body []byte) *httptest.ResponseRecorder {
+	return serveAs(h, auth.Principal{Name: "test", Roles: auth.AllRoles}, method, path, body)
+}
+
+func serveAs(h http.Handler, p auth.Principal, method, path string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req = req.WithContext(auth.ContextWithPrincipal(req.Context(), p))
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestGetIP(t *testing.T) {
+	h, store := newTestHandler()
+	store.details["1.2.3.4"] = model.IPDetails{IPAddress: "1.2.3.4", ResponseCode: "127.0.0.2"}
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		expectedCode int
+	}{
+		{"found", "GET", "/v1/ip/1.2.3.4", http.StatusOK},
+		{"not found", "GET", "/v1/ip/4.3.2.1", http.StatusNotFound},
+		{"invalid ip", "GET", "/v1/ip/foobar", http.StatusBadRequest},
+		{"wrong method", "POST", "/v1/ip/1.2.3.4", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(h, test.method, test.path, nil)
+			if recorder.Code != test.expectedCode {
+				t.Errorf("expected status code %d but got %d", test.expectedCode, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestEnqueueAndJob(t *testing.T) {
+	h, store := newTestHandler()
+	store.wg.Add(2)
+
+	recorder := serve(h, "POST", "/v1/enqueue", []byte(`{"ips": ["1.1.1.1", "2.2.2.2"]}`))
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("expected status code %d but got %d: %s", http.StatusAccepted, recorder.Code, recorder.Body.String())
+	}
+
+	var payload model.EnqueuePayload
+	if err := json.Unmarshal(recorder.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("error decoding enqueue response: %s", err.Error())
+	}
+	if len(payload.QueuedIps) != 2 || payload.JobID == "" {
+		t.Errorf("unexpected enqueue response: %+v", payload)
+	}
+
+	store.wg.Wait()
+
+	recorder = serve(h, "GET", "/v1/jobs/"+payload.JobID, nil)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status code %d but got %d", http.StatusOK, recorder.Code)
+	}
+
+	recorder = serve(h, "GET", "/v1/jobs/missing", nil)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d but got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+func TestEnqueueRejectsBadInput(t *testing.T) {
+	h, _ := newTestHandler()
+
+	testCases := []struct {
+		name string
+		body string
+	}{
+		{"malformed body", `{"ips": `},
+		{"invalid ip", `{"ips": ["1.1.1.1", "foobar"]}`},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(h, "POST", "/v1/enqueue", []byte(test.body))
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d but got %d", http.StatusBadRequest, recorder.Code)
+			}
+
+			var body errorBody
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil || body.Error.Code != graph.CodeInvalidInput {
+				t.Errorf("expected an %s error body, got %s", graph.CodeInvalidInput, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	h, _ := newTestHandler()
+
+	recorder := serve(h, "GET", "/v1/openapi.json", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status code %d but got %d", http.StatusOK, recorder.Code)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &doc); err != nil {
+		t.Errorf("OpenAPI document is not valid JSON: %s", err.Error())
+	}
+}
+
+func TestRolesEnforced(t *testing.T) {
+	h, _ := newTestHandler()
+	reader := auth.Principal{Name: "reader", Roles: []string{auth.RoleReader}}
+
+	recorder := serveAs(h, reader, "POST", "/v1/enqueue", []byte(`{"ips": ["1.1.1.1"]}`))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected status code %d but got %d", http.StatusForbidden, recorder.Code)
+	}
+
+	recorder = serveAs(h, reader, "GET", "/v1/ip/1.2.3.4", nil)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected reader to be allowed to look up details, got %d", recorder.Code)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	store := &mockStore{details: map[string]model.IPDetails{}}
+	h := NewHandler(&graph.Resolver{
+		Getter: store,
+		Limits: ratelimit.NewLimiter(ratelimit.Config{Default: ratelimit.Policy{QueriesPerMinute: 1}}),
+	})
+
+	serve(h, "GET", "/v1/ip/1.2.3.4", nil)
+	recorder := serve(h, "GET", "/v1/ip/1.2.3.4", nil)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, recorder.Code)
+	}
+	if recorder.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected Retry-After of 60, got %q", recorder.Header().Get("Retry-After"))
+	}
+}
+
+type mockAudit struct {
+	filter model.AuditLogFilter
+}
+
+func (m *mockAudit) AddAuditEntry(ctx context.Context, tenant string, entry model.AuditEntry) error {
+	return nil
+}
+
+func (m *mockAudit) EachAuditEntry(ctx context.Context, tenant string, filter model.AuditLogFilter, fn func(*model.AuditEntry) error) error {
+	m.filter = filter
+	for _, id := range []string{"2", "1"} {
+		if err := fn(&model.AuditEntry{ID: id, Principal: "alice", Operation: model.AuditOperationEnqueue}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestAuditExport(t *testing.T) {
+	audit := &mockAudit{}
+	h := NewHandler(&graph.Resolver{Audit: audit})
+
+	recorder := serve(h, "GET", "/v1/audit?principal=alice&operation=enqueue&since=2021-04-01T00:00:00Z", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("unexpected content type %q", recorder.Header().Get("Content-Type"))
+	}
+
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", recorder.Body.String())
+	}
+	var entry model.AuditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil || entry.ID != "2" {
+		t.Errorf("unexpected first line %q", lines[0])
+	}
+
+	f := audit.filter
+	if *f.Principal != "alice" || *f.Operation != model.AuditOperationEnqueue || f.Since == nil || f.Until != nil {
+		t.Errorf("unexpected filter: %+v", f)
+	}
+
+	recorder = serve(h, "GET", "/v1/audit?since=yesterday", nil)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a bad time, got %d", http.StatusBadRequest, recorder.Code)
+	}
+
+	recorder = serveAs(h, auth.Principal{Name: "reader", Roles: []string{auth.RoleReader}}, "GET", "/v1/audit", nil)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for a non-admin, got %d", http.StatusForbidden, recorder.Code)
+	}
+}
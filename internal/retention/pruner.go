@@ -0,0 +1,104 @@
+// Package retention removes stored records once the retention policy no longer keeps
+// them.
+package retention
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+
+	"github.com/jdharms/threat-detect/internal/db"
+)
+
+// Counters published at /debug/vars.  In a dry run the records counted are the ones
+// that would have been removed.
+var (
+	prunedClean        = expvar.NewInt("retention_pruned_clean")
+	prunedListed       = expvar.NewInt("retention_pruned_listed")
+	prunedAuditEntries = expvar.NewInt("retention_pruned_audit_entries")
+	prunedLookups      = expvar.NewInt("retention_pruned_lookups")
+	pruneErrors        = expvar.NewInt("retention_prune_errors")
+	lastPrune          = expvar.NewInt("retention_last_prune_unix")
+	compactions        = expvar.NewInt("retention_compactions")
+)
+
+// Store is implemented by db.Client and db.MemoryStore.
+type Store interface {
+	Prune(ctx context.Context, policy db.RetentionPolicy, now time.Time, dryRun bool) (db.PruneReport, error)
+	Compact(ctx context.Context) error
+}
+
+// Config controls how often a Pruner runs and what it removes.
+type Config struct {
+	Policy db.RetentionPolicy
+	// Interval is how often records are pruned.
+	Interval time.Duration
+	// CompactInterval is how often the database is compacted afterwards; zero never
+	// compacts it.
+	CompactInterval time.Duration
+	// DryRun only logs what would have been removed.
+	DryRun bool
+}
+
+// Pruner periodically prunes a Store.
+type Pruner struct {
+	store       Store
+	cfg         Config
+	now         func() time.Time
+	lastCompact time.Time
+}
+
+func NewPruner(store Store, cfg Config) *Pruner {
+	// the first compaction waits a full interval, rather than slowing down startup
+	return &Pruner{store: store, cfg: cfg, now: time.Now, lastCompact: time.Now()}
+}
+
+// Run prunes the store straight away and then every interval, until stop is closed.
+func (p *Pruner) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.RunOnce(context.Background())
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// RunOnce prunes the store, then compacts it if that is due, logging the outcome.
+func (p *Pruner) RunOnce(ctx context.Context) {
+	now := p.now()
+	report, err := p.store.Prune(ctx, p.cfg.Policy, now, p.cfg.DryRun)
+	if err != nil {
+		pruneErrors.Add(1)
+		log.Printf("error pruning expired records: %s", err.Error())
+		return
+	}
+	lastPrune.Set(now.Unix())
+	prunedClean.Add(int64(report.Clean))
+	prunedListed.Add(int64(report.Listed))
+	prunedAuditEntries.Add(int64(report.AuditEntries))
+	prunedLookups.Add(int64(report.Lookups))
+
+	if p.cfg.DryRun {
+		log.Printf("retention dry run: would prune %s", report)
+		return
+	}
+	if report != (db.PruneReport{}) {
+		log.Printf("pruned %s", report)
+	}
+
+	if p.cfg.CompactInterval <= 0 || now.Sub(p.lastCompact) < p.cfg.CompactInterval {
+		return
+	}
+	if err := p.store.Compact(ctx); err != nil {
+		log.Printf("error compacting database: %s", err.Error())
+		return
+	}
+	p.lastCompact = now
+	compactions.Add(1)
+}
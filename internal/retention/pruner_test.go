@@ -0,0 +1,113 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jdharms/threat-detect/internal/db"
+)
+
+type mockStore struct {
+	report    db.PruneReport
+	err       error
+	dryRuns   []bool
+	compacted int
+}
+
+func (ms *mockStore) Prune(ctx context.Context, policy db.RetentionPolicy, now time.Time, dryRun bool) (db.PruneReport, error) {
+	ms.dryRuns = append(ms.dryRuns, dryRun)
+	return ms.report, ms.err
+}
+
+func (ms *mockStore) Compact(ctx context.Context) error {
+	ms.compacted++
+	return nil
+}
+
+func TestRunOnceCountsPrunedRecords(t *testing.T) {
+	store := &mockStore{report: db.PruneReport{Clean: 3, Listed: 2, AuditEntries: 1, Lookups: 4}}
+	sut := NewPruner(store, Config{Interval: time.Hour})
+
+	clean, listed, audit, lookups := prunedClean.Value(), prunedListed.Value(), prunedAuditEntries.Value(), prunedLookups.Value()
+	sut.RunOnce(context.Background())
+
+	if prunedClean.Value()-clean != 3 || prunedListed.Value()-listed != 2 || prunedAuditEntries.Value()-audit != 1 || prunedLookups.Value()-lookups != 4 {
+		t.Errorf("expected the pruned records to be counted, got %d, %d, %d and %d more",
+			prunedClean.Value()-clean, prunedListed.Value()-listed, prunedAuditEntries.Value()-audit, prunedLookups.Value()-lookups)
+	}
+	if lastPrune.Value() == 0 {
+		t.Error("expected the time of the prune to be recorded")
+	}
+}
+
+func TestRunOnceCountsErrors(t *testing.T) {
+	store := &mockStore{err: fmt.Errorf("some error")}
+	sut := NewPruner(store, Config{Interval: time.Hour, CompactInterval: time.Nanosecond})
+
+	errors := pruneErrors.Value()
+	sut.RunOnce(context.Background())
+
+	if pruneErrors.Value()-errors != 1 {
+		t.Error("expected the error to be counted")
+	}
+	if store.compacted != 0 {
+		t.Error("expected a failed prune not to compact the database")
+	}
+}
+
+func TestRunOnceCompactsOnSchedule(t *testing.T) {
+	testCases := []struct {
+		name      string
+		cfg       Config
+		elapsed   time.Duration
+		compacted int
+	}{
+		{"not yet due", Config{Interval: time.Hour, CompactInterval: 24 * time.Hour}, time.Hour, 0},
+		{"due", Config{Interval: time.Hour, CompactInterval: 24 * time.Hour}, 25 * time.Hour, 1},
+		{"disabled", Config{Interval: time.Hour}, 25 * time.Hour, 0},
+		{"dry run", Config{Interval: time.Hour, CompactInterval: 24 * time.Hour, DryRun: true}, 25 * time.Hour, 0},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			store := &mockStore{}
+			sut := NewPruner(store, test.cfg)
+			start := time.Now()
+			sut.lastCompact = start
+			sut.now = func() time.Time { return start.Add(test.elapsed) }
+
+			sut.RunOnce(context.Background())
+
+			if store.compacted != test.compacted {
+				t.Errorf("expected %d compactions, got %d", test.compacted, store.compacted)
+			}
+			if len(store.dryRuns) != 1 || store.dryRuns[0] != test.cfg.DryRun {
+				t.Errorf("expected one prune with dry run %v, got %v", test.cfg.DryRun, store.dryRuns)
+			}
+		})
+	}
+}
+
+func TestRunStopsWhenClosed(t *testing.T) {
+	store := &mockStore{}
+	sut := NewPruner(store, Config{Interval: time.Hour})
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		sut.Run(stop)
+		close(done)
+	}()
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to return once stop was closed")
+	}
+	if len(store.dryRuns) != 1 {
+		t.Errorf("expected one prune on starting, got %d", len(store.dryRuns))
+	}
+}
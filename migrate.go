@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jdharms/threat-detect/internal/db"
+)
+
+const migrateUsage = `usage: detect migrate [-db path-or-url] <command>
+
+Manage the database schema.  The server applies pending migrations when it starts, so this
+is only needed to inspect a database or migrate it ahead of a deployment.
+
+Commands:
+  status  list the migrations and when each was applied
+  up      apply the pending migrations
+`
+
+// runMigrate implements the "migrate" subcommand.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), migrateUsage) }
+	cfg := envConfig().Database
+	target := &cfg.Path
+	if cfg.Driver == "postgres" {
+		target = &cfg.URL
+	}
+	fs.StringVar(target, "db", *target, "path of the SQLite database, or URL of the PostgreSQL one when DB_DRIVER is postgres")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one command")
+	}
+
+	if cfg.Driver != "postgres" && *target == db.MemoryPath {
+		return fmt.Errorf("the in-memory store has no schema to migrate")
+	}
+
+	open := db.Open
+	if cfg.Driver == "postgres" {
+		open = db.OpenPostgres
+	}
+	client, err := open(*target)
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer client.Close()
+
+	switch fs.Arg(0) {
+	case "status":
+		status, err := client.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	case "up":
+		applied, err := client.Migrate()
+		for _, m := range applied {
+			fmt.Printf("applied %d (%s)\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("already up to date")
+		}
+		return nil
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %s", fs.Arg(0))
+	}
+}
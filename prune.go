@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jdharms/threat-detect/internal/config"
+	"github.com/jdharms/threat-detect/internal/db"
+	"github.com/jdharms/threat-detect/internal/retention"
+)
+
+const pruneUsage = `usage: detect prune [-dry-run] [-compact]
+
+Remove the records the configured retention policy no longer keeps, as the server does
+every retention.prune_interval.  With -dry-run, report what would be removed instead.
+
+`
+
+// runPrune implements the "prune" subcommand.
+func runPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), pruneUsage)
+		fs.PrintDefaults()
+	}
+	dryRun := fs.Bool("dry-run", false, "report what would be removed without removing it")
+	compact := fs.Bool("compact", false, "vacuum a SQLite database afterwards")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments")
+	}
+
+	cfg := envConfig()
+	if !cfg.Retention.Prunes() {
+		return fmt.Errorf("no retention policy is configured; set retention.clean, retention.listed, retention.audit_log or retention.history")
+	}
+	if cfg.Database.Driver != "postgres" && cfg.Database.Path == db.MemoryPath {
+		return fmt.Errorf("the in-memory store has nothing to prune")
+	}
+
+	client, err := openDatabase(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	report, err := client.Prune(ctx, pruneConfig(cfg.Retention).Policy, time.Now(), *dryRun)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("would remove %s\n", report)
+		return nil
+	}
+	fmt.Printf("removed %s\n", report)
+
+	if *compact {
+		return client.Compact(ctx)
+	}
+	return nil
+}
+
+// pruneConfig returns the pruner's settings from the configuration.
+func pruneConfig(c config.RetentionConfig) retention.Config {
+	return retention.Config{
+		Policy: db.RetentionPolicy{
+			Clean:    c.Clean,
+			Listed:   c.Listed,
+			AuditLog: c.AuditLog,
+			History:  c.History,
+		},
+		Interval:        c.PruneInterval,
+		CompactInterval: c.CompactInterval,
+		DryRun:          c.DryRun,
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/jdharms/threat-detect/internal/backup"
+	"github.com/jdharms/threat-detect/internal/db"
+)
+
+const restoreUsage = `usage: detect restore [-db path] <backup>
+       detect restore -list
+
+Replace the SQLite database with a backup, after checking that the backup is intact and
+that this build supports its schema.  The database it replaces is kept alongside it with
+a ".pre-restore" suffix.  Stop the server first.
+
+`
+
+// runRestore implements the "restore" subcommand.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), restoreUsage)
+		fs.PrintDefaults()
+	}
+	cfg := envConfig()
+	path := fs.String("db", cfg.Database.Path, "path of the SQLite database to replace")
+	list := fs.Bool("list", false, "list the backups in backup.dir, oldest first")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cfg.Database.Driver != "sqlite" || *path == db.MemoryPath {
+		return fmt.Errorf("only SQLite databases can be restored from a backup")
+	}
+
+	if *list {
+		if cfg.Backup.Dir == "" {
+			return fmt.Errorf("backup.dir isn't set")
+		}
+		backups, err := backup.List(cfg.Backup.Dir)
+		if err != nil {
+			return err
+		}
+		for _, b := range backups {
+			fmt.Println(b)
+		}
+		return nil
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected the backup to restore")
+	}
+
+	version, err := db.Restore(fs.Arg(0), *path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %s (schema version %d) to %s\n", fs.Arg(0), version, *path)
+	return nil
+}
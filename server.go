@@ -1,53 +1,316 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/backup"
+	"github.com/jdharms/threat-detect/internal/config"
 	"github.com/jdharms/threat-detect/internal/db"
 	"github.com/jdharms/threat-detect/internal/dnsbl"
-
-	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/jdharms/threat-detect/internal/grpcapi"
+	"github.com/jdharms/threat-detect/internal/jobs"
+	"github.com/jdharms/threat-detect/internal/ratelimit"
+	"github.com/jdharms/threat-detect/internal/rest"
+	"github.com/jdharms/threat-detect/internal/retention"
 
 	"github.com/jdharms/threat-detect/graph"
-	"github.com/jdharms/threat-detect/graph/generated"
 )
 
-const defaultPort = "8080"
-const defaultDBPath = "./data.db"
+const credentialsPollInterval = 10 * time.Second
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = defaultPort
+	if len(os.Args) > 1 {
+		subcommands := map[string]func(args []string) error{
+			"users":   runUsers,
+			"migrate": runMigrate,
+			"prune":   runPrune,
+			"restore": runRestore,
+			"export":  runExport,
+			"import":  runImport,
+		}
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fs := flag.NewFlagSet("detect", flag.ExitOnError)
+	flags := config.NewFlags(fs)
+	fs.Parse(os.Args[1:])
+
+	cfg, err := flags.Config(os.LookupEnv)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if flags.PrintConfig() {
+		out, err := cfg.YAML()
+		if err != nil {
+			log.Fatal(fmt.Sprintf("could not print config: %s", err.Error()))
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
+	opts := graph.HandlerOptions{
+		ComplexityLimit: cfg.Query.ComplexityLimit,
+		DepthLimit:      cfg.Query.DepthLimit,
 	}
 
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = defaultDBPath
+	if path := cfg.Query.PersistedQueries; path != "" {
+		pq, err := graph.LoadPersistedQueries(path)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("could not load persisted queries: %s", err.Error()))
+		}
+		opts.PersistedQueries = pq
 	}
 
-	dbClient, err := db.NewClient(dbPath)
+	limits := ratelimit.DefaultConfig
+	if path := cfg.Auth.RateLimitsFile; path != "" {
+		limits, err = ratelimit.LoadConfig(path)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("could not load rate limits: %s", err.Error()))
+		}
+	}
+
+	dbClient, err := openDatabase(cfg.Database)
 	if err != nil {
 		log.Fatal(fmt.Sprintf("could not open database: %s", err.Error()))
 	}
 	defer dbClient.Close()
 
-	blClient := dnsbl.NewSpamhausClient()
+	if cfg.Retention.Prunes() {
+		go retention.NewPruner(dbClient, pruneConfig(cfg.Retention)).Run(nil)
+	}
+
+	blClient := dnsbl.NewClient(dnsbl.Config{
+		Zones:     cfg.DNSBL.Zones,
+		Resolvers: cfg.DNSBL.Resolvers,
+		Timeout:   cfg.DNSBL.Timeout,
+		Workers:   cfg.DNSBL.Workers,
+	})
 
 	resolver := &graph.Resolver{
-		Adder:  dbClient,
-		Getter: dbClient,
-		DNSBL:  blClient,
+		Adder:       dbClient,
+		Getter:      dbClient,
+		Deleter:     dbClient,
+		Networks:    dbClient,
+		Bulk:        dbClient,
+		Shared:      dbClient,
+		Stats:       dbClient,
+		DNSBL:       blClient,
+		Jobs:        jobs.NewTrackerWithRetention(cfg.Retention.Jobs),
+		APIKeys:     dbClient,
+		Limits:      ratelimit.NewLimiter(limits),
+		Audit:       dbClient,
+		Annotations: dbClient,
+	}
+
+	if cfg.Backup.Dir != "" {
+		backups := backup.NewScheduler(dbClient, backup.Config{
+			Dir:      cfg.Backup.Dir,
+			Interval: cfg.Backup.Interval,
+			Keep:     cfg.Backup.Keep,
+		})
+		resolver.Backups = backups
+		if cfg.Backup.Interval > 0 {
+			go backups.Run(nil)
+		}
+	}
+
+	credentials, err := auth.NewFileValidator(cfg.Auth.CredentialsFile)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("could not load credentials (add a user with `detect users add <username>`): %s", err.Error()))
+	}
+	go credentials.Watch(credentialsPollInterval, nil)
+
+	roles, err := auth.NewRoleFile(cfg.Auth.RolesFile, auth.RoleReader)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("could not load roles: %s", err.Error()))
+	}
+	go roles.Watch(credentialsPollInterval, nil)
+
+	tenants, err := auth.NewTenantFile(cfg.Auth.TenantsFile)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("could not load tenants: %s", err.Error()))
+	}
+	go tenants.Watch(credentialsPollInterval, nil)
+
+	go reloadOnHangup(credentials, roles, tenants)
+	authenticators := []auth.Authenticator{
+		auth.NewLoginThrottle(auth.DefaultThrottleConfig).Authenticator(auth.BasicAuthenticator(credentials.Validate, roles.Roles)),
+		auth.APIKeyAuthenticator(dbClient.LookupAPIKey, roles.Roles),
+	}
+	if cfg.Auth.JWT.JWKS != "" {
+		authenticators = append(authenticators, jwtAuthenticator(cfg.Auth.JWT))
+	}
+
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("could not configure TLS: %s", err.Error()))
+	}
+	if path := cfg.TLS.ClientCertMapping; path != "" {
+		mapping, err := auth.LoadCertMapping(path)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("could not load client certificate mapping: %s", err.Error()))
+		}
+		authenticators = append([]auth.Authenticator{auth.CertAuthenticator(mapping)}, authenticators...)
 	}
+	authenticators = auth.WithTenants(tenants.Tenant, authenticators...)
 
-	fmt.Printf("server running on port %s\n", port)
-	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+	grpcListener, err := net.Listen("tcp", cfg.GRPC.Addr)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("could not listen for gRPC: %s", err.Error()))
+	}
+	grpcSrv := grpcapi.NewServer(resolver, tlsConfig, authenticators...)
+	go func() {
+		log.Fatal(grpcSrv.Serve(grpcListener))
+	}()
+	fmt.Printf("gRPC server running on %s\n", cfg.GRPC.Addr)
 
-	http.Handle("/graphql", auth.NewBasicAuth(auth.NewMapValidator(map[string]string{"secureworks": "supersecret"}))(srv))
+	fmt.Printf("server running on %s\n", cfg.HTTP.Addr)
+	srv := graph.NewHandler(resolver, opts)
 
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	authenticate := auth.NewMiddleware(authenticators...)
+
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", authenticate(srv))
+	mux.Handle("/v1/", authenticate(rest.NewHandler(resolver)))
+	mux.Handle("/debug/vars", authenticate(requireAdmin(expvar.Handler())))
+
+	httpSrv := &http.Server{Addr: cfg.HTTP.Addr, Handler: mux, TLSConfig: tlsConfig}
+	if tlsConfig != nil {
+		log.Fatal(httpSrv.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(httpSrv.ListenAndServe())
+}
+
+// storage is implemented by db.Client and db.MemoryStore.
+type storage interface {
+	graph.IPDetailsAdder
+	graph.IPDetailsGetter
+	graph.IPDetailsDeleter
+	graph.NetworkSearcher
+	graph.BulkDetails
+	graph.SharedResults
+	graph.StatsGetter
+	graph.APIKeyStore
+	graph.AuditLog
+	graph.Annotations
+	retention.Store
+	backup.Store
+	LookupAPIKey(ctx context.Context, keyHash string) (auth.APIKeyRecord, error)
+	Close() error
+}
+
+// openDatabase connects to the configured database and brings its schema up to date.
+func openDatabase(c config.DatabaseConfig) (storage, error) {
+	switch {
+	case c.Driver == "postgres":
+		return db.NewPostgresClient(c.URL)
+	case c.Path == db.MemoryPath:
+		log.Print("storing data in memory; it will be lost when the server stops")
+		return db.NewMemoryStore(), nil
+	default:
+		return db.NewClient(c.Path)
+	}
+}
+
+// newTLSConfig returns the TLS configuration for both servers, or nil if TLS isn't
+// enabled.  Client certificates are verified against the client CA file when it is set;
+// they are optional unless ClientAuth is "require".
+func newTLSConfig(c config.TLSConfig) (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile == "" {
+		return cfg, nil
+	}
+	pem, err := ioutil.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client CA file: %w", err)
+	}
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+	}
+
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if c.ClientAuth == "require" {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := auth.RequireRole(r.Context(), auth.RoleAdmin); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// jwtAuthenticator accepts tokens signed by the keys in the configured JWKS.
+func jwtAuthenticator(c config.JWTConfig) auth.Authenticator {
+	keys, err := auth.LoadJWKS(c.JWKS)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("could not load JWKS: %s", err.Error()))
+	}
+	return auth.JWTAuthenticator(keys, auth.JWTConfig{
+		Issuer:      c.Issuer,
+		Audience:    c.Audience,
+		NameClaim:   c.NameClaim,
+		RolesClaim:  c.RolesClaim,
+		TenantClaim: c.TenantClaim,
+	})
+}
+
+func reloadOnHangup(credentials *auth.FileValidator, roles *auth.RoleFile, tenants *auth.TenantFile) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := credentials.Reload(); err != nil {
+			log.Printf("error reloading credentials: %s", err.Error())
+		} else {
+			log.Printf("reloaded credentials")
+		}
+		if err := roles.Reload(); err != nil {
+			log.Printf("error reloading roles: %s", err.Error())
+		} else {
+			log.Printf("reloaded roles")
+		}
+		if err := tenants.Reload(); err != nil {
+			log.Printf("error reloading tenants: %s", err.Error())
+		} else {
+			log.Printf("reloaded tenants")
+		}
+	}
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/jdharms/threat-detect/internal/auth"
+	"github.com/jdharms/threat-detect/internal/config"
+)
+
+const usersUsage = `usage: detect users [-file path] <command> [username]
+
+Manage the credentials file used for Basic Authentication.
+
+Commands:
+  list               print the usernames in the file
+  add <username>     add a user, reading the password from stdin
+  rotate <username>  replace a user's password, reading it from stdin
+  remove <username>  delete a user
+`
+
+// runUsers implements the "users" subcommand.
+func runUsers(args []string) error {
+	fs := flag.NewFlagSet("users", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), usersUsage) }
+	path := fs.String("file", credentialsPath(), "path to the credentials file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("missing command")
+	}
+
+	cf, err := auth.LoadCredentialsFile(*path)
+	if err != nil {
+		return err
+	}
+
+	command := fs.Arg(0)
+	if command == "list" {
+		for _, user := range cf.Users() {
+			fmt.Println(user)
+		}
+		return nil
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("%s requires a username", command)
+	}
+	username := fs.Arg(1)
+
+	switch command {
+	case "add", "rotate":
+		password, err := readPassword(username)
+		if err != nil {
+			return err
+		}
+		if command == "add" {
+			err = cf.Add(username, password)
+		} else {
+			err = cf.Rotate(username, password)
+		}
+		if err != nil {
+			return err
+		}
+	case "remove":
+		if err := cf.Remove(username); err != nil {
+			return err
+		}
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %s", command)
+	}
+
+	return cf.Save()
+}
+
+// readPassword prompts for a password without echoing it when stdin is a terminal, and
+// otherwise reads the first line of stdin so the command can be scripted.
+func readPassword(username string) (string, error) {
+	var password string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintf(os.Stderr, "password for %s: ", username)
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("error reading password: %w", err)
+		}
+		password = string(b)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("error reading password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if password == "" {
+		return "", fmt.Errorf("password may not be empty")
+	}
+	return password, nil
+}
+
+// credentialsPath returns the credentials file the server would use, as set by the config
+// file named by CONFIG_FILE or the environment.
+func credentialsPath() string {
+	return envConfig().Auth.CredentialsFile
+}
+
+// envConfig returns the configuration the server would load without any flags, falling
+// back to the defaults if it can't be loaded.
+func envConfig() config.Config {
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"), os.LookupEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ignoring configuration: %s\n", err.Error())
+		return config.Default()
+	}
+	return cfg
+}